@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// URLStats summarises how a single proxied page has been shown.
+type URLStats struct {
+	URL          string  `json:"url"`
+	DwellSeconds float64 `json:"dwellSeconds"`
+	Loads        int     `json:"loads"`
+	Failures     int     `json:"failures"`
+	Interactions int     `json:"interactions"`
+	LastSeen     int64   `json:"lastSeen"`
+}
+
+var (
+	analytics      = map[string]*URLStats{}
+	analyticsMutex sync.Mutex
+)
+
+// maxBeaconSeconds caps a single dwell report so a misbehaving client
+// can't claim hours of screen time in one request.
+const maxBeaconSeconds = 60
+
+// maxTrackedURLs bounds the pages kept in analytics and heatmaps, since
+// displays report URLs without authenticating. Pages beyond it are not
+// recorded.
+const maxTrackedURLs = 500
+
+// statsFor returns the stats for u, or nil when u is new and the table is
+// full. Callers must hold analyticsMutex.
+func statsFor(u string) *URLStats {
+	s, ok := analytics[u]
+	if !ok {
+		if len(analytics) >= maxTrackedURLs {
+			return nil
+		}
+		s = &URLStats{URL: u}
+		analytics[u] = s
+	}
+	s.LastSeen = time.Now().UnixMilli()
+	return s
+}
+
+func recordLoad(u string) {
+	analyticsMutex.Lock()
+	defer analyticsMutex.Unlock()
+	if s := statsFor(u); s != nil {
+		s.Loads++
+	}
+}
+
+func recordFailure(u string) {
+	analyticsMutex.Lock()
+	defer analyticsMutex.Unlock()
+	if s := statsFor(u); s != nil {
+		s.Failures++
+	}
+}
+
+func recordDwell(u string, seconds float64, interactions int) {
+	if seconds < 0 {
+		seconds = 0
+	}
+	if seconds > maxBeaconSeconds {
+		seconds = maxBeaconSeconds
+	}
+	if interactions < 0 {
+		interactions = 0
+	}
+	analyticsMutex.Lock()
+	defer analyticsMutex.Unlock()
+	s := statsFor(u)
+	if s == nil {
+		return
+	}
+	s.DwellSeconds += seconds
+	s.Interactions += interactions
+}
+
+func snapshotAnalytics() []URLStats {
+	analyticsMutex.Lock()
+	out := make([]URLStats, 0, len(analytics))
+	for _, s := range analytics {
+		out = append(out, *s)
+	}
+	analyticsMutex.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].DwellSeconds > out[j].DwellSeconds
+	})
+	return out
+}
+
+func apiAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := snapshotAnalytics()
+	if u := r.URL.Query().Get("url"); u != "" {
+		filtered := stats[:0]
+		for _, s := range stats {
+			if s.URL == u {
+				filtered = append(filtered, s)
+			}
+		}
+		stats = filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"since": startTime,
+		"urls":  stats,
+	})
+}
+
+func apiAnalyticsBeaconHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var beacon struct {
+		URL          string  `json:"url"`
+		Seconds      float64 `json:"seconds"`
+		Interactions int     `json:"interactions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&beacon); err != nil || beacon.URL == "" {
+		http.Error(w, "Invalid beacon", http.StatusBadRequest)
+		return
+	}
+	recordDwell(beacon.URL, beacon.Seconds, beacon.Interactions)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The audit log records what the display did (navigations, inputs, overlay
+// and target changes) into one JSON-lines file per day under
+// DATA_DIR/audit. Every entry carries an HMAC over its content and the
+// previous entry's signature, so edits or deletions break the chain.
+// Enabled with AUDIT_LOG=true; AUDIT_KEY sets the signing key.
+type AuditEntry struct {
+	Seq   int                    `json:"seq"`
+	Time  int64                  `json:"time"`
+	Event string                 `json:"event"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+	Prev  string                 `json:"prev"`
+	Sig   string                 `json:"sig"`
+}
+
+type auditLog struct {
+	mu      sync.Mutex
+	enabled bool
+	key     []byte
+	dir     string
+	day     string
+	seq     int
+	prev    string
+}
+
+var audit auditLog
+
+var auditDayRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// auditedEvents are bus topics written to the audit log as they happen.
+var auditedEvents = []string{EventTargetChanged, EventNavigation, EventRecoveryAction, EventWatchdogTrip}
+
+// initAuditEvents records audited bus events.
+func initAuditEvents() {
+	ch := subscribeEventsReliable(auditedEvents...)
+	go func() {
+		for e := range ch {
+			recordAudit(e.Event, e.Data)
+		}
+	}()
+}
+
+func initAudit() error {
+	if setting("AUDIT_LOG") != "true" {
+		return nil
+	}
+	dir := filepath.Join(dataDir, "audit")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	key := []byte(setting("AUDIT_KEY"))
+	if len(key) == 0 {
+		var err error
+		if key, err = loadOrCreateAuditKey(filepath.Join(dataDir, "audit.key")); err != nil {
+			return err
+		}
+		slog.Warn("AUDIT_KEY not set, using a generated key stored in the data directory")
+	}
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	audit.enabled = true
+	audit.key = key
+	audit.dir = dir
+	return nil
+}
+
+func loadOrCreateAuditKey(path string) ([]byte, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		return data, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encoded := []byte(hex.EncodeToString(key))
+	return encoded, os.WriteFile(path, encoded, 0600)
+}
+
+func (a *auditLog) sign(e AuditEntry) string {
+	e.Sig = ""
+	payload, _ := json.Marshal(e)
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// resume picks up the sequence and signature chain from an existing file
+// for day, e.g. after a restart.
+func (a *auditLog) resume(day string) {
+	a.day, a.seq, a.prev = day, 0, ""
+	f, err := os.Open(filepath.Join(a.dir, day+".jsonl"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			a.seq, a.prev = e.Seq, e.Sig
+		}
+	}
+}
+
+func recordAudit(event string, data map[string]interface{}) {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if !audit.enabled {
+		return
+	}
+
+	now := time.Now()
+	if day := now.UTC().Format(time.DateOnly); day != audit.day {
+		audit.resume(day)
+	}
+	e := AuditEntry{
+		Seq:   audit.seq + 1,
+		Time:  now.UnixMilli(),
+		Event: event,
+		Data:  data,
+		Prev:  audit.prev,
+	}
+	e.Sig = audit.sign(e)
+	line, _ := json.Marshal(e)
+
+	f, err := os.OpenFile(filepath.Join(audit.dir, audit.day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("audit: failed to open log", "err", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		slog.Error("audit: failed to write entry", "err", err)
+		return
+	}
+	audit.seq, audit.prev = e.Seq, e.Sig
+}
+
+// verifyAuditDay walks the chain for one day and returns the number of
+// valid entries, or an error at the first broken link.
+func verifyAuditDay(day string) (int, error) {
+	audit.mu.Lock()
+	key, dir := audit.key, audit.dir
+	audit.mu.Unlock()
+
+	f, err := os.Open(filepath.Join(dir, day+".jsonl"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	verifier := auditLog{key: key}
+	prev, count := "", 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return count, err
+		}
+		if e.Seq != count+1 || e.Prev != prev || !hmac.Equal([]byte(e.Sig), []byte(verifier.sign(e))) {
+			return count, fmt.Errorf("chain broken at seq %d", e.Seq)
+		}
+		prev = e.Sig
+		count++
+	}
+	return count, scanner.Err()
+}
+
+func auditEnabledOr404(w http.ResponseWriter, r *http.Request) bool {
+	audit.mu.Lock()
+	enabled := audit.enabled
+	audit.mu.Unlock()
+	if !enabled {
+		http.Error(w, "Audit log disabled", http.StatusNotFound)
+	}
+	return enabled
+}
+
+func apiAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if !auditEnabledOr404(w, r) {
+		return
+	}
+	entries, _ := os.ReadDir(audit.dir)
+	days := []string{}
+	for _, e := range entries {
+		if day, ok := strings.CutSuffix(e.Name(), ".jsonl"); ok && auditDayRe.MatchString(day) {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"days": days})
+}
+
+func apiAuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !auditEnabledOr404(w, r) {
+		return
+	}
+	day := r.URL.Query().Get("date")
+	if !auditDayRe.MatchString(day) {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-`+day+`.jsonl"`)
+	http.ServeFile(w, r, filepath.Join(audit.dir, day+".jsonl"))
+}
+
+func apiAuditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if !auditEnabledOr404(w, r) {
+		return
+	}
+	day := r.URL.Query().Get("date")
+	if !auditDayRe.MatchString(day) {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	count, err := verifyAuditDay(day)
+	result := map[string]interface{}{"date": day, "entries": count, "valid": err == nil}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin guards sensitive endpoints with the ADMIN_TOKEN shared secret,
+// sent as "Authorization: Bearer <token>" or an X-Admin-Token header. When no
+// token is configured the endpoint is refused outright.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := setting("ADMIN_TOKEN")
+		if token == "" {
+			http.Error(w, "Admin token not configured", http.StatusForbidden)
+			return
+		}
+
+		given := r.Header.Get("X-Admin-Token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdminIfConfigured protects control endpoints only once ADMIN_TOKEN is
+// set, so single-box setups keep working without credentials.
+func requireAdminIfConfigured(next http.HandlerFunc) http.HandlerFunc {
+	guarded := requireAdmin(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if setting("ADMIN_TOKEN") == "" {
+			next(w, r)
+			return
+		}
+		guarded(w, r)
+	}
+}
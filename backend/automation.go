@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Automations are action lists kept as YAML files in DATA_DIR/automations,
+// one per name:
+//
+//	at: "07:00"       # run every day at this time (optional)
+//	onLoad: /login*   # run when a display loads a matching page (optional)
+//	timeout: 10s      # how long in-page steps wait (default 10s)
+//	steps:
+//	  - navigate: https://example.com/login
+//	  - waitVisible: "#user"
+//	  - click: "#user"
+//	  - type: operator
+//	  - evaluate: document.title
+//	  - sleep: 2s
+//
+// navigate and sleep run on the server. The other steps are sent to the
+// displays; the first display to answer decides the outcome.
+type AutomationStep struct {
+	Action string `json:"action"`
+	Value  string `json:"value"`
+}
+
+type Automation struct {
+	Name    string           `json:"name"`
+	At      string           `json:"at,omitempty"`
+	OnLoad  string           `json:"onLoad,omitempty"`
+	Timeout string           `json:"timeout,omitempty"`
+	Steps   []AutomationStep `json:"steps"`
+
+	onLoad  *regexp.Regexp
+	timeout time.Duration
+}
+
+// AutomationRun is the progress or outcome of a run. Results holds the
+// value of each evaluate step.
+type AutomationRun struct {
+	Name     string        `json:"name"`
+	Trigger  string        `json:"trigger"`
+	Started  int64         `json:"started"`
+	Finished int64         `json:"finished,omitempty"`
+	Step     int           `json:"step"`
+	Error    string        `json:"error,omitempty"`
+	Results  []interface{} `json:"results"`
+}
+
+// automationCommand is an in-page step handed to the displays.
+type automationCommand struct {
+	Seq     int64  `json:"seq"`
+	Action  string `json:"action"`
+	Value   string `json:"value"`
+	Timeout int64  `json:"timeout"`
+}
+
+type automationResult struct {
+	Seq   int64       `json:"seq"`
+	OK    bool        `json:"ok"`
+	Value interface{} `json:"value,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+var automationActions = map[string]bool{
+	"navigate": true, "waitVisible": true, "click": true, "type": true, "evaluate": true, "sleep": true,
+}
+
+const (
+	maxAutomationBytes       = 64 * 1024
+	defaultAutomationTimeout = 10 * time.Second
+	maxAutomationSleep       = 10 * time.Minute
+	// automationGrace covers the displays' polling delay on top of a step's
+	// timeout.
+	automationGrace = 5 * time.Second
+	// automationLoadCooldown keeps an onLoad automation from retriggering on
+	// the pages its own steps load.
+	automationLoadCooldown = time.Minute
+)
+
+var (
+	automationMutex   sync.Mutex
+	automationRunning bool
+	automationRuns    = map[string]AutomationRun{}
+	automationLoadRan = map[string]time.Time{}
+
+	// automationCommandCur is the step the displays should run, answered on
+	// automationWaiter. Sequence numbers start at the clock so a display
+	// doesn't mistake a step after a restart for one it already ran.
+	automationCommandCur *automationCommand
+	automationWaiter     chan automationResult
+	automationSeq        = time.Now().UnixMilli()
+	displayCommandMutex  sync.Mutex
+
+	errNoDisplayAnswer = errors.New("no display answered")
+)
+
+func automationsDir() string {
+	return filepath.Join(dataDir, "automations")
+}
+
+// parseAutomation reads the YAML subset shown above: top-level "key: value"
+// lines and a steps list of "- action: value" items.
+func parseAutomation(name string, src []byte) (*Automation, error) {
+	a := &Automation{Name: name, Steps: []AutomationStep{}}
+	inSteps := false
+	for n, line := range strings.Split(string(src), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "-"); ok {
+			if !inSteps {
+				return nil, fmt.Errorf("line %d: list item outside steps", n+1)
+			}
+			action, value, ok := strings.Cut(strings.TrimSpace(item), ":")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected - action: value", n+1)
+			}
+			a.Steps = append(a.Steps, AutomationStep{Action: strings.TrimSpace(action), Value: yamlScalar(value)})
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n+1)
+		}
+		inSteps = false
+		switch value = yamlScalar(value); strings.TrimSpace(key) {
+		case "at":
+			a.At = value
+		case "onLoad":
+			a.OnLoad = value
+		case "timeout":
+			a.Timeout = value
+		case "steps":
+			inSteps = true
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", n+1, key)
+		}
+	}
+	return a, a.validate()
+}
+
+func (a *Automation) validate() error {
+	if !scriptNameRe.MatchString(a.Name) {
+		return errors.New("invalid automation name")
+	}
+	if len(a.Steps) == 0 {
+		return errors.New("steps are required")
+	}
+	if a.At != "" {
+		if _, err := parseClock(a.At); err != nil {
+			return err
+		}
+	}
+	if a.OnLoad != "" {
+		re, err := globRegexp(a.OnLoad)
+		if err != nil {
+			return err
+		}
+		a.onLoad = re
+	}
+	a.timeout = defaultAutomationTimeout
+	if a.Timeout != "" {
+		d, err := time.ParseDuration(a.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", a.Timeout)
+		}
+		a.timeout = d
+	}
+	for i, s := range a.Steps {
+		if s.Action == "screenshot" {
+			return fmt.Errorf("step %d: screenshot is not supported, the displays render pages in their own browser", i+1)
+		}
+		if !automationActions[s.Action] {
+			return fmt.Errorf("step %d: unknown action %q", i+1, s.Action)
+		}
+		if s.Value == "" {
+			return fmt.Errorf("step %d: %s needs a value", i+1, s.Action)
+		}
+		switch s.Action {
+		case "navigate":
+			if _, err := normalizeTargetURL(s.Value); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		case "sleep":
+			if d, err := time.ParseDuration(s.Value); err != nil || d < 0 || d > maxAutomationSleep {
+				return fmt.Errorf("step %d: invalid sleep %q", i+1, s.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesLoad reports whether loading the page at uri (path and query)
+// triggers a.
+func (a *Automation) matchesLoad(uri string) bool {
+	return a.onLoad != nil && a.onLoad.MatchString(uri)
+}
+
+func loadAutomation(name string) (*Automation, []byte, error) {
+	if !scriptNameRe.MatchString(name) {
+		return nil, nil, os.ErrNotExist
+	}
+	src, err := os.ReadFile(filepath.Join(automationsDir(), name+".yml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := parseAutomation(name, src)
+	return a, src, err
+}
+
+// listAutomations loads every automation, skipping invalid files.
+func listAutomations() []*Automation {
+	entries, err := os.ReadDir(automationsDir())
+	if err != nil {
+		return nil
+	}
+	var list []*Automation
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".yml")
+		if !ok || e.IsDir() {
+			continue
+		}
+		a, _, err := loadAutomation(name)
+		if err != nil {
+			slog.Warn("skipping invalid automation", "name", name, "err", err)
+			continue
+		}
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// initAutomationEvents runs automations on their schedule and when a
+// display loads a matching page.
+func initAutomationEvents() {
+	loads := subscribeEvents(EventNavigation)
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for {
+			select {
+			case e := <-loads:
+				u, _ := e.Data["url"].(string)
+				for _, a := range listAutomations() {
+					if !a.matchesLoad(u) {
+						continue
+					}
+					automationMutex.Lock()
+					recent := time.Since(automationLoadRan[a.Name]) < automationLoadCooldown
+					if !recent {
+						automationLoadRan[a.Name] = time.Now()
+					}
+					automationMutex.Unlock()
+					if !recent {
+						startAutomationLogged(a, "load")
+					}
+				}
+			case now := <-ticker.C:
+				if inMaintenance() {
+					continue
+				}
+				clock := now.Format("15:04")
+				for _, a := range listAutomations() {
+					if a.At == clock {
+						startAutomationLogged(a, "schedule")
+					}
+				}
+			}
+		}
+	}()
+}
+
+func startAutomationLogged(a *Automation, trigger string) {
+	if _, err := startAutomation(a, trigger); err != nil {
+		slog.Warn("automation not started", "name", a.Name, "trigger", trigger, "err", err)
+	}
+}
+
+// startAutomation runs a in the background. The returned channel receives
+// the finished run.
+func startAutomation(a *Automation, trigger string) (<-chan AutomationRun, error) {
+	automationMutex.Lock()
+	if automationRunning {
+		automationMutex.Unlock()
+		return nil, errors.New("another automation is running")
+	}
+	automationRunning = true
+	run := AutomationRun{Name: a.Name, Trigger: trigger, Started: time.Now().UnixMilli(), Results: []interface{}{}}
+	automationRuns[a.Name] = run
+	automationMutex.Unlock()
+
+	slog.Info("automation started", "name", a.Name, "trigger", trigger)
+	recordAudit("automation_run", map[string]interface{}{"name": a.Name, "trigger": trigger})
+	done := make(chan AutomationRun, 1)
+	go func() {
+		for i, s := range a.Steps {
+			run.Step = i + 1
+			automationMutex.Lock()
+			automationRuns[a.Name] = run
+			automationMutex.Unlock()
+			value, err := runAutomationStep(s, a.timeout)
+			if err != nil {
+				run.Error = fmt.Sprintf("step %d (%s): %v", i+1, s.Action, err)
+				break
+			}
+			if s.Action == "evaluate" {
+				run.Results = append(run.Results, value)
+			}
+		}
+		run.Finished = time.Now().UnixMilli()
+		if run.Error != "" {
+			slog.Error("automation failed", "name", a.Name, "err", run.Error)
+		} else {
+			slog.Info("automation finished", "name", a.Name)
+		}
+		automationMutex.Lock()
+		automationRuns[a.Name] = run
+		automationRunning = false
+		automationMutex.Unlock()
+		done <- run
+	}()
+	return done, nil
+}
+
+func runAutomationStep(s AutomationStep, timeout time.Duration) (interface{}, error) {
+	switch s.Action {
+	case "navigate":
+		u, err := normalizeTargetURL(s.Value)
+		if err == nil {
+			err = checkTarget(u)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switchTarget(u)
+		return nil, nil
+	case "sleep":
+		d, _ := time.ParseDuration(s.Value)
+		time.Sleep(d)
+		return nil, nil
+	}
+	if s.Action == "evaluate" {
+		if err := evaluateAllowed(s.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return askDisplay(s.Action, s.Value, timeout, timeout+automationGrace)
+}
+
+// askDisplay hands an in-page command to the displays and waits up to wait
+// for the first answer; timeout is how long the display itself may take.
+// Commands are sent one at a time.
+func askDisplay(action, value string, timeout, wait time.Duration) (interface{}, error) {
+	displayCommandMutex.Lock()
+	defer displayCommandMutex.Unlock()
+
+	automationMutex.Lock()
+	automationSeq++
+	automationCommandCur = &automationCommand{Seq: automationSeq, Action: action, Value: value, Timeout: timeout.Milliseconds()}
+	ch := make(chan automationResult, 1)
+	automationWaiter = ch
+	automationMutex.Unlock()
+	defer func() {
+		automationMutex.Lock()
+		automationCommandCur = nil
+		automationWaiter = nil
+		automationMutex.Unlock()
+	}()
+
+	select {
+	case res := <-ch:
+		if !res.OK {
+			return nil, errors.New(res.Error)
+		}
+		return res.Value, nil
+	case <-time.After(wait):
+		return nil, errNoDisplayAnswer
+	}
+}
+
+// apiAutomationHandler lists automations (GET), returns one with its
+// source (GET ?name=), saves one from a YAML body (PUT ?name=) or removes
+// one (DELETE ?name=).
+func apiAutomationHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if name == "" {
+			list := []map[string]interface{}{}
+			for _, a := range listAutomations() {
+				list = append(list, automationView(a))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"automations": list})
+			return
+		}
+		a, src, err := loadAutomation(name)
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		resp := map[string]interface{}{"name": name, "source": string(src)}
+		if err != nil {
+			resp["error"] = err.Error()
+		} else {
+			resp = automationView(a)
+			resp["source"] = string(src)
+		}
+		json.NewEncoder(w).Encode(resp)
+	case http.MethodPut, http.MethodPost:
+		src, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAutomationBytes))
+		if err != nil {
+			http.Error(w, "Automation too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		a, err := parseAutomation(name, src)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = os.MkdirAll(automationsDir(), 0755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(automationsDir(), name+".yml"), src, 0644)
+		}
+		if err != nil {
+			slog.Error("failed to save automation", "name", name, "err", err)
+			http.Error(w, "Failed to save automation", http.StatusInternalServerError)
+			return
+		}
+		recordAudit("automation_set", map[string]interface{}{"name": name})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(automationView(a))
+	case http.MethodDelete:
+		if !scriptNameRe.MatchString(name) {
+			http.NotFound(w, r)
+			return
+		}
+		if err := os.Remove(filepath.Join(automationsDir(), name+".yml")); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		recordAudit("automation_delete", map[string]interface{}{"name": name})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func automationView(a *Automation) map[string]interface{} {
+	view := map[string]interface{}{"name": a.Name, "at": a.At, "onLoad": a.OnLoad, "timeout": a.timeout.String(), "steps": a.Steps}
+	automationMutex.Lock()
+	if run, ok := automationRuns[a.Name]; ok {
+		view["lastRun"] = run
+	}
+	automationMutex.Unlock()
+	return view
+}
+
+// apiAutomationRunHandler starts ?name=. With ?wait=true it answers once
+// the run has finished.
+func apiAutomationRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	a, _, err := loadAutomation(r.URL.Query().Get("name"))
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	done, err := startAutomation(a, "api")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("wait") == "true" {
+		json.NewEncoder(w).Encode(<-done)
+		return
+	}
+	automationMutex.Lock()
+	run := automationRuns[a.Name]
+	automationMutex.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// apiAutomationPendingHandler gives the displays the step to run, if any.
+func apiAutomationPendingHandler(w http.ResponseWriter, r *http.Request) {
+	automationMutex.Lock()
+	cmd := automationCommandCur
+	automationMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"command": cmd})
+}
+
+// apiAutomationResultHandler takes a display's answer to the current step.
+func apiAutomationResultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var res automationResult
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAutomationBytes)).Decode(&res); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	automationMutex.Lock()
+	if automationCommandCur != nil && automationCommandCur.Seq == res.Seq && automationWaiter != nil {
+		automationWaiter <- res
+		automationWaiter = nil
+	}
+	automationMutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// automationScript runs automation steps on the display. The last step
+// taken is kept in sessionStorage so a click that navigates away isn't
+// repeated by the next page.
+const automationScript = `
+<script>
+(() => {
+    const done = () => +sessionStorage.getItem('automationSeq') || 0;
+    const visible = (el) => el.getClientRects().length > 0 && getComputedStyle(el).visibility !== 'hidden';
+    const waitFor = (selector, timeout) => new Promise((resolve, reject) => {
+        const end = Date.now() + timeout;
+        const tick = () => {
+            const el = document.querySelector(selector);
+            if (el && visible(el)) return resolve(el);
+            if (Date.now() > end) return reject(new Error('timed out waiting for ' + selector));
+            setTimeout(tick, 100);
+        };
+        tick();
+    });
+    const run = async (cmd) => {
+        switch (cmd.action) {
+        case 'waitVisible':
+            await waitFor(cmd.value, cmd.timeout);
+            return;
+        case 'click': {
+            const el = await waitFor(cmd.value, cmd.timeout);
+            el.focus();
+            el.click();
+            return;
+        }
+        case 'type': {
+            const el = document.activeElement || document.body;
+            if (!document.execCommand('insertText', false, cmd.value) && 'setRangeText' in el) {
+                el.setRangeText(cmd.value, el.selectionStart, el.selectionEnd, 'end');
+                el.dispatchEvent(new Event('input', { bubbles: true }));
+            }
+            return;
+        }
+        case 'evaluate':
+            return await (0, eval)(cmd.value);
+        case 'query':
+            return [...document.querySelectorAll(cmd.value)].slice(0, 200).map((el) => {
+                const box = el.getBoundingClientRect();
+                return {
+                    tag: el.tagName.toLowerCase(),
+                    id: el.id || undefined,
+                    classes: [...el.classList],
+                    text: (el.innerText || el.textContent || '').trim().slice(0, 500),
+                    visible: visible(el),
+                    box: { x: box.x + scrollX, y: box.y + scrollY, width: box.width, height: box.height },
+                };
+            });
+        case 'page':
+            return {
+                title: document.title,
+                url: location.href,
+                scrollWidth: document.documentElement.scrollWidth,
+                scrollHeight: document.documentElement.scrollHeight,
+                scrollX, scrollY,
+                viewport: { width: innerWidth, height: innerHeight, devicePixelRatio },
+            };
+        }
+        throw new Error('unknown action ' + cmd.action);
+    };
+    const report = (result) => fetch('/api/automation/result', {
+        method: 'POST', keepalive: true, headers: { 'Content-Type': 'application/json' }, body: JSON.stringify(result),
+    }).catch(() => {});
+    let busy = false;
+    const poll = () => {
+        if (busy) return;
+        fetch('/api/automation/pending', { cache: 'no-store' })
+            .then(res => res.json())
+            .then(async (data) => {
+                const cmd = data.command;
+                if (!cmd || cmd.seq <= done()) return;
+                sessionStorage.setItem('automationSeq', cmd.seq);
+                busy = true;
+                try {
+                    let value = await run(cmd);
+                    try { JSON.stringify(value); } catch (e) { value = String(value); }
+                    report({ seq: cmd.seq, ok: true, value });
+                } catch (e) {
+                    report({ seq: cmd.seq, ok: false, error: String((e && e.message) || e) });
+                } finally {
+                    busy = false;
+                }
+            })
+            .catch(() => {});
+    };
+    poll();
+    setInterval(poll, 500);
+})();
+</script>
+`
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAutomation(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		steps   []AutomationStep
+		timeout time.Duration
+		err     string
+	}{
+		{
+			name: "full",
+			src: `# log in every morning
+at: "07:00"
+onLoad: /login*
+timeout: 3s
+steps:
+  - navigate: https://example.com/login
+  - waitVisible: "#user"
+  - type: 'operator # one'
+  - sleep: 2s
+`,
+			steps: []AutomationStep{
+				{"navigate", "https://example.com/login"},
+				{"waitVisible", "#user"},
+				{"type", "operator # one"},
+				{"sleep", "2s"},
+			},
+			timeout: 3 * time.Second,
+		},
+		{
+			name:    "default timeout",
+			src:     "steps:\n  - click: button # the first one\n",
+			steps:   []AutomationStep{{"click", "button"}},
+			timeout: defaultAutomationTimeout,
+		},
+		{name: "empty", src: "", err: "steps are required"},
+		{name: "item outside steps", src: "- click: a\n", err: "line 1: list item outside steps"},
+		{name: "item after another key", src: "steps:\n  - click: a\nat: 07:00\n  - click: b\n", err: "line 4: list item outside steps"},
+		{name: "item without value", src: "steps:\n  - click\n", err: "line 2: expected - action: value"},
+		{name: "line without colon", src: "steps\n", err: "line 1: expected key: value"},
+		{name: "unknown key", src: "every: 5m\n", err: `line 1: unknown key "every"`},
+		{name: "unknown action", src: "steps:\n  - hover: a\n", err: `step 1: unknown action "hover"`},
+		{name: "screenshot", src: "steps:\n  - screenshot: x\n", err: "screenshot is not supported"},
+		{name: "missing value", src: "steps:\n  - click: \"\"\n", err: "step 1: click needs a value"},
+		{name: "bad navigate", src: "steps:\n  - navigate: ftp://example.com\n", err: "step 1: scheme ftp is not allowed"},
+		{name: "bad sleep", src: "steps:\n  - sleep: forever\n", err: `step 1: invalid sleep "forever"`},
+		{name: "sleep too long", src: "steps:\n  - sleep: 11m\n", err: `step 1: invalid sleep "11m"`},
+		{name: "bad at", src: "at: 25:00\nsteps:\n  - click: a\n", err: "invalid time"},
+		{name: "bad timeout", src: "timeout: -1s\nsteps:\n  - click: a\n", err: `invalid timeout "-1s"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := parseAutomation("morning", []byte(tt.src))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a.Steps) != len(tt.steps) {
+				t.Fatalf("steps = %v, want %v", a.Steps, tt.steps)
+			}
+			for i := range tt.steps {
+				if a.Steps[i] != tt.steps[i] {
+					t.Errorf("step %d = %v, want %v", i+1, a.Steps[i], tt.steps[i])
+				}
+			}
+			if a.timeout != tt.timeout {
+				t.Errorf("timeout = %v, want %v", a.timeout, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestParseAutomationRejectsBadName(t *testing.T) {
+	if _, err := parseAutomation("../escape", []byte("steps:\n  - click: a\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestAutomationMatchesLoad(t *testing.T) {
+	tests := []struct {
+		onLoad string
+		uri    string
+		want   bool
+	}{
+		{"", "/login", false},
+		{"/login*", "/login", true},
+		{"/login*", "/login?next=/", true},
+		{"/login*", "/app/login", false},
+		{"/login", "/login?next=/", false},
+		{"/*/settings", "/team/settings", true},
+		{"/a.b", "/axb", false},
+	}
+	for _, tt := range tests {
+		src := "steps:\n  - click: a\n"
+		if tt.onLoad != "" {
+			src = "onLoad: " + tt.onLoad + "\n" + src
+		}
+		a, err := parseAutomation("rule", []byte(src))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.onLoad, err)
+		}
+		if got := a.matchesLoad(tt.uri); got != tt.want {
+			t.Errorf("onLoad %q, load %q: got %v, want %v", tt.onLoad, tt.uri, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ScrollRange is one leg of a scroll sequence: hold at Start for Pause
+// seconds, then scroll down to End (pixels).
+type ScrollRange struct {
+	Start int     `json:"start"`
+	End   int     `json:"end"`
+	Pause float64 `json:"pause"`
+}
+
+const defaultScrollPause = 3
+
+// parseScrollSequence parses "0-800,1200-2400:10": comma-separated pixel
+// ranges, each with an optional ":seconds" pause (3 by default).
+func parseScrollSequence(seq string) ([]ScrollRange, error) {
+	ranges := []ScrollRange{}
+	for _, part := range strings.Split(seq, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		r := ScrollRange{Pause: defaultScrollPause}
+		span, pause, hasPause := strings.Cut(part, ":")
+		if hasPause {
+			p, err := strconv.ParseFloat(strings.TrimSpace(pause), 64)
+			if err != nil || p < 0 {
+				return nil, errors.New("invalid pause in " + strconv.Quote(part))
+			}
+			r.Pause = p
+		}
+		from, to, ok := strings.Cut(span, "-")
+		var err1, err2 error
+		r.Start, err1 = strconv.Atoi(strings.TrimSpace(from))
+		r.End, err2 = strconv.Atoi(strings.TrimSpace(to))
+		if !ok || err1 != nil || err2 != nil || r.Start < 0 || r.End < r.Start {
+			return nil, errors.New("invalid range " + strconv.Quote(part))
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// ScrollAnchor is one stop of an anchor-based scroll: the element matching
+// Selector is scrolled into view and held for Dwell seconds.
+type ScrollAnchor struct {
+	Selector string  `json:"selector"`
+	Dwell    float64 `json:"dwell"`
+}
+
+// parseScrollAnchors parses "#intro:10|#sales|.footer": "|"-separated CSS
+// selectors, each with an optional ":seconds" dwell (3 by default). Only a
+// numeric suffix is taken as the dwell, so pseudo-classes are left alone.
+func parseScrollAnchors(raw string) ([]ScrollAnchor, error) {
+	anchors := []ScrollAnchor{}
+	for _, sel := range splitSelectors(raw) {
+		a := ScrollAnchor{Selector: sel, Dwell: defaultScrollPause}
+		if i := strings.LastIndex(sel, ":"); i > 0 {
+			if d, err := strconv.ParseFloat(sel[i+1:], 64); err == nil {
+				if d < 0 {
+					return nil, errors.New("invalid dwell in " + strconv.Quote(sel))
+				}
+				a.Selector, a.Dwell = strings.TrimSpace(sel[:i]), d
+			}
+		}
+		if err := validateSelectors([]string{a.Selector}); err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, a)
+	}
+	return anchors, nil
+}
+
+// SetScrollAnchors validates and applies a new anchor list. A non-empty
+// list takes precedence over the pixel sequence.
+func SetScrollAnchors(raw string) error {
+	if _, err := parseScrollAnchors(raw); err != nil {
+		return err
+	}
+	state.Update("", func(c *Config) { c.ScrollAnchors = raw })
+	return nil
+}
+
+// SetScrollSequence validates and applies a new sequence; displays pick it
+// up on their next version poll.
+func SetScrollSequence(seq string) error {
+	if _, err := parseScrollSequence(seq); err != nil {
+		return err
+	}
+	state.Update("scrollsequence", func(c *Config) { c.ScrollSequence = seq })
+	return nil
+}
+
+var scrollDirections = map[string]bool{"vertical": true, "horizontal": true, "snake": true}
+
+// autoscrollSettings is the body of /api/config/autoscroll; fields left out
+// are kept.
+type autoscrollSettings struct {
+	Enabled   *bool   `json:"enabled"`
+	Direction *string `json:"direction"`
+	Speed     *int    `json:"speed"`
+	SpeedX    *int    `json:"speedX"`
+}
+
+// SetAutoscroll validates and applies new autoscroll settings.
+func SetAutoscroll(s autoscrollSettings) error {
+	if s.Direction != nil && !scrollDirections[*s.Direction] {
+		return errors.New("direction must be vertical, horizontal or snake")
+	}
+	if (s.Speed != nil && *s.Speed <= 0) || (s.SpeedX != nil && *s.SpeedX <= 0) {
+		return errors.New("speed must be positive")
+	}
+	// Speed changes are picked up by the running engine; turning it on or
+	// off or changing direction needs a reload.
+	setting := ""
+	if s.Enabled == nil && s.Direction == nil {
+		setting = "scrollspeed"
+	}
+	state.Update(setting, func(c *Config) {
+		if s.Enabled != nil {
+			c.AutoScroll = *s.Enabled
+		}
+		if s.Direction != nil {
+			c.ScrollDirection = *s.Direction
+		}
+		if s.Speed != nil {
+			c.ScrollSpeed = *s.Speed
+		}
+		if s.SpeedX != nil {
+			c.ScrollSpeedX = *s.SpeedX
+		}
+	})
+	return nil
+}
+
+// apiConfigAutoscrollHandler returns (GET) or changes (POST) whether and
+// how the display scrolls, e.g. {"direction":"snake","speed":40,"speedX":120}.
+func apiConfigAutoscrollHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req autoscrollSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := SetAutoscroll(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config := state.Snapshot()
+		slog.Info("autoscroll changed", "enabled", config.AutoScroll, "direction", config.ScrollDirection)
+		recordAudit("autoscroll", map[string]interface{}{"enabled": config.AutoScroll, "direction": config.ScrollDirection})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	config := state.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   config.AutoScroll,
+		"direction": config.ScrollDirection,
+		"speed":     config.ScrollSpeed,
+		"speedX":    config.ScrollSpeedX,
+	})
+}
+
+// apiConfigScrollSequenceHandler returns (GET) or replaces (POST) the scroll
+// sequence and anchors, e.g. {"sequence": "0-800:5,1200-2400"} or
+// {"anchors": "#intro:10|#sales"}. Fields left out are kept.
+func apiConfigScrollSequenceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Sequence *string `json:"sequence"`
+			Anchors  *string `json:"anchors"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Sequence != nil {
+			if err := SetScrollSequence(strings.TrimSpace(*req.Sequence)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("scroll sequence changed", "sequence", *req.Sequence)
+			recordAudit("scroll_sequence", map[string]interface{}{"sequence": *req.Sequence})
+		}
+		if req.Anchors != nil {
+			if err := SetScrollAnchors(strings.TrimSpace(*req.Anchors)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			slog.Info("scroll anchors changed", "anchors", *req.Anchors)
+			recordAudit("scroll_anchors", map[string]interface{}{"anchors": *req.Anchors})
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	config := state.Snapshot()
+	ranges, _ := parseScrollSequence(config.ScrollSequence)
+	anchors, _ := parseScrollAnchors(config.ScrollAnchors)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sequence":   config.ScrollSequence,
+		"ranges":     ranges,
+		"anchors":    config.ScrollAnchors,
+		"anchorList": anchors,
+	})
+}
+
+// autoscrollScript is the autoscroll engine, injected into every document
+// when AutoScroll is on. It starts as soon as the DOM is ready (or right away
+// if it already is) and re-syncs its ranges when a single-page app navigates
+// or the viewport changes size, so there is no dead window after a reload
+// and no stale page height.
+const autoscrollScript = `
+<script>
+(() => {
+    let lastTime = 0, currentSequenceIndex = 0, sequences = [], pauseUntil = 0, running = false;
+    // Ranges come pre-parsed from the server; the end is clamped to the
+    // current page height.
+    function parseSequences() {
+        const pageHeight = document.documentElement.scrollHeight - window.innerHeight;
+        sequences = (config.scrollRanges || []).map(r => ({ start: r.start, end: Math.min(r.end, pageHeight), pause: r.pause * 1000 }));
+        if (sequences.length === 0) sequences.push({ start: 0, end: pageHeight, pause: 3000 });
+    }
+    // Anchor mode: scroll each element into view in turn, skipping ones
+    // that aren't on the page (yet).
+    let anchorIndex = 0, anchorTimer = null;
+    function nextAnchor() {
+        const anchors = config.scrollAnchors;
+        for (let i = 0; i < anchors.length; i++) {
+            const a = anchors[(anchorIndex + i) % anchors.length];
+            let el = null;
+            try { el = document.querySelector(a.selector); } catch (e) {}
+            if (!el) continue;
+            anchorIndex = (anchorIndex + i + 1) % anchors.length;
+            el.scrollIntoView({ behavior: 'smooth', block: 'start' });
+            anchorTimer = setTimeout(nextAnchor, a.dwell * 1000);
+            return;
+        }
+        anchorTimer = setTimeout(nextAnchor, 1000);
+    }
+    const anchorMode = (config.scrollAnchors || []).length > 0;
+    // Horizontal and snake modes track the position as floats so slow
+    // speeds still move; snake goes right, down a screen, left, down, ...
+    const mode2D = config.scrollDirection === 'horizontal' || config.scrollDirection === 'snake';
+    let x = 0, y = 0, leg = 'right', afterDown = 'left', downTarget = 0;
+    function restart2D() {
+        x = 0; y = 0; leg = 'right';
+        window.scrollTo(0, 0);
+        pauseUntil = Date.now() + 3000;
+    }
+    function step2D(timestamp) {
+        if (!lastTime) lastTime = timestamp;
+        const deltaTime = timestamp - lastTime;
+        lastTime = timestamp;
+        if (Date.now() < pauseUntil) { requestAnimationFrame(step2D); return; }
+        const doc = document.documentElement;
+        const maxX = Math.max(doc.scrollWidth - window.innerWidth, 0), maxY = Math.max(doc.scrollHeight - window.innerHeight, 0);
+        if (leg === 'down') {
+            y = Math.min(y + (config.scrollSpeed / 1000) * deltaTime, downTarget);
+            if (y >= downTarget) leg = afterDown;
+        } else {
+            x += (leg === 'right' ? 1 : -1) * (config.scrollSpeedX / 1000) * deltaTime;
+            const done = leg === 'right' ? x >= maxX : x <= 0;
+            x = Math.min(Math.max(x, 0), maxX);
+            if (done) {
+                if (config.scrollDirection === 'horizontal' || y >= maxY) { restart2D(); requestAnimationFrame(step2D); return; }
+                afterDown = leg === 'right' ? 'left' : 'right';
+                leg = 'down';
+                downTarget = Math.min(y + window.innerHeight, maxY);
+            }
+        }
+        window.scrollTo(Math.round(x), Math.round(y));
+        requestAnimationFrame(step2D);
+    }
+    function resync() {
+        if (anchorMode) {
+            clearTimeout(anchorTimer);
+            anchorIndex = 0;
+            nextAnchor();
+            return;
+        }
+        if (mode2D) { restart2D(); return; }
+        parseSequences();
+        currentSequenceIndex = 0;
+        window.scrollTo(0, sequences[0].start);
+        pauseUntil = Date.now() + sequences[0].pause;
+    }
+    function scrollStep(timestamp) {
+        if (!lastTime) lastTime = timestamp;
+        const deltaTime = timestamp - lastTime;
+        lastTime = timestamp;
+        if (Date.now() < pauseUntil) { requestAnimationFrame(scrollStep); return; }
+        const current = sequences[currentSequenceIndex];
+        window.scrollBy(0, (config.scrollSpeed / 1000) * deltaTime);
+        if (window.scrollY >= current.end) {
+            parseSequences();
+            currentSequenceIndex = (currentSequenceIndex + 1) % sequences.length;
+            window.scrollTo(0, sequences[currentSequenceIndex].start);
+            pauseUntil = Date.now() + sequences[currentSequenceIndex].pause;
+        }
+        requestAnimationFrame(scrollStep);
+    }
+    function start() {
+        if (running) return;
+        running = true;
+        if (anchorMode) { nextAnchor(); return; }
+        if (mode2D) { requestAnimationFrame(step2D); return; }
+        parseSequences();
+        window.scrollTo(0, sequences[0].start);
+        requestAnimationFrame(scrollStep);
+    }
+    ['pushState', 'replaceState'].forEach(fn => {
+        const orig = history[fn];
+        history[fn] = function () { const r = orig.apply(this, arguments); setTimeout(resync, 500); return r; };
+    });
+    window.addEventListener('popstate', () => setTimeout(resync, 500));
+    window.addEventListener('resize', parseSequences);
+    if (document.readyState === 'loading') document.addEventListener('DOMContentLoaded', start);
+    else start();
+})();
+</script>
+`
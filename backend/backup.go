@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A backup is a tar.gz of the settings file (as settings.yml) and the data
+// dir under data/: bookmarks, macros, automations, scripts, page rules,
+// cookie jars and the rest. Restoring one on replacement hardware and
+// restarting brings the display back as it was.
+
+// maxRestoreBytes caps the uploaded archive.
+var maxRestoreBytes int64 = 512 << 20
+
+// errBadBackup is returned for archives with entries outside the layout.
+var errBadBackup = errors.New("not a backup archive")
+
+// apiBackupHandler streams a backup. ?exclude= lists top-level data dir
+// entries to leave out, e.g. exclude=downloads,profiles.
+func apiBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	exclude := map[string]bool{}
+	for _, name := range strings.Split(r.URL.Query().Get("exclude"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			exclude[name] = true
+		}
+	}
+
+	name := fmt.Sprintf("backup-%s-%s.tar.gz", state.Snapshot().Identity.Name, time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeBackup(tw, exclude); err != nil {
+		// The headers are out; the truncated archive fails to extract.
+		slog.Error("backup failed", "err", err)
+		return
+	}
+	tw.Close()
+	gz.Close()
+	recordAudit("backup", map[string]interface{}{"exclude": r.URL.Query().Get("exclude")})
+}
+
+func writeBackup(tw *tar.Writer, exclude map[string]bool) error {
+	settingsMutex.Lock()
+	settings := settingsPath
+	settingsMutex.Unlock()
+	if err := addBackupFile(tw, settings, "settings.yml"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return filepath.WalkDir(dataDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dataDir, p)
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if exclude[strings.SplitN(rel, "/", 2)[0]] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return addBackupFile(tw, p, "data/"+rel)
+	})
+}
+
+func addBackupFile(tw *tar.Writer, src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// backupTarget maps an archive entry to where it is restored.
+func backupTarget(name, settings string) (string, error) {
+	clean := path.Clean(name)
+	if clean == "settings.yml" {
+		return settings, nil
+	}
+	rel, ok := strings.CutPrefix(clean, "data/")
+	if !ok || !fs.ValidPath(rel) {
+		return "", fmt.Errorf("%w: %s", errBadBackup, name)
+	}
+	return filepath.Join(dataDir, filepath.FromSlash(rel)), nil
+}
+
+// apiRestoreHandler restores a backup sent as the request body. Every
+// entry is unpacked beside its destination first and only moved into place
+// once the whole archive has been read. Files the backup doesn't have are
+// kept. The restored state takes effect on the next restart.
+func apiRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	settingsMutex.Lock()
+	settings := settingsPath
+	settingsMutex.Unlock()
+
+	gz, err := gzip.NewReader(http.MaxBytesReader(w, r.Body, maxRestoreBytes))
+	if err != nil {
+		http.Error(w, errBadBackup.Error(), http.StatusBadRequest)
+		return
+	}
+	staged := map[string]string{}
+	defer func() {
+		for tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && hdr.Typeflag != tar.TypeReg {
+			err = fmt.Errorf("%w: %s is not a file", errBadBackup, hdr.Name)
+		}
+		var dst, tmp string
+		if err == nil {
+			dst, err = backupTarget(hdr.Name, settings)
+		}
+		if err == nil {
+			tmp, err = stageFile(dst, tr)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		staged[tmp] = dst
+	}
+	if len(staged) == 0 {
+		http.Error(w, errBadBackup.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files := len(staged)
+	for tmp, dst := range staged {
+		if err := os.Rename(tmp, dst); err != nil {
+			slog.Error("restore failed", "file", dst, "err", err)
+			http.Error(w, "Restore incomplete: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		delete(staged, tmp)
+	}
+	slog.Warn("backup restored, restart to apply", "files", files)
+	recordAudit("restore", map[string]interface{}{"files": files})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"files": files, "restartRequired": true})
+}
+
+// stageFile writes r next to dst and returns the temporary file's name.
+func stageFile(dst string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".restore-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
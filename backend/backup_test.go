@@ -0,0 +1,165 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const backupTestToken = "backup-secret"
+
+// newBackupHarness starts a harness with an admin token and a settings
+// file in a temporary directory.
+func newBackupHarness(t *testing.T) (*harness, string) {
+	h := newHarness(t, "", map[string]string{"ADMIN_TOKEN": backupTestToken})
+	settings := filepath.Join(t.TempDir(), "settings.yml")
+	if err := os.WriteFile(settings, []byte("SCROLL_SPEED: \"40\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settingsMutex.Lock()
+	prev := settingsPath
+	settingsPath = settings
+	settingsMutex.Unlock()
+	t.Cleanup(func() {
+		settingsMutex.Lock()
+		settingsPath = prev
+		settingsMutex.Unlock()
+	})
+	return h, settings
+}
+
+func (h *harness) restore(archive []byte) (*http.Response, string) {
+	return h.do(http.MethodPost, "/api/restore", bytes.NewReader(archive), http.Header{"X-Admin-Token": {backupTestToken}})
+}
+
+// backupArchive builds a tar.gz of entries with the matching bodies.
+func backupArchive(t *testing.T, entries []tar.Header, bodies []string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i, hdr := range entries {
+		hdr.Size = int64(len(bodies[i]))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(bodies[i]))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	h, settings := newBackupHarness(t)
+	os.MkdirAll(filepath.Join(h.dataDir, "scripts"), 0755)
+	os.WriteFile(filepath.Join(h.dataDir, "scripts", "clock.js"), []byte("tick()"), 0644)
+	os.WriteFile(filepath.Join(h.dataDir, "bookmarks.json"), []byte(`[{"name":"a"}]`), 0644)
+
+	resp, archive := h.do(http.MethodGet, "/api/backup", nil, http.Header{"X-Admin-Token": {backupTestToken}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("backup: status = %d", resp.StatusCode)
+	}
+
+	os.WriteFile(settings, []byte("SCROLL_SPEED: \"99\"\n"), 0644)
+	os.Remove(filepath.Join(h.dataDir, "scripts", "clock.js"))
+	os.WriteFile(filepath.Join(h.dataDir, "bookmarks.json"), []byte(`[]`), 0644)
+	os.WriteFile(filepath.Join(h.dataDir, "extra.txt"), []byte("kept"), 0644)
+
+	resp, body := h.restore([]byte(archive))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore: status = %d, body %q", resp.StatusCode, body)
+	}
+	assertContains(t, body, `"restartRequired":true`)
+	for path, want := range map[string]string{
+		settings: "SCROLL_SPEED: \"40\"\n",
+		filepath.Join(h.dataDir, "scripts", "clock.js"): "tick()",
+		filepath.Join(h.dataDir, "bookmarks.json"):      `[{"name":"a"}]`,
+		filepath.Join(h.dataDir, "extra.txt"):           "kept",
+	} {
+		if got, err := os.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestRestoreRejectsBadArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tar.Header
+		bodies  []string
+	}{
+		{
+			name:    "path traversal",
+			entries: []tar.Header{{Name: "data/bookmarks.json", Mode: 0644}, {Name: "data/../../escaped.txt", Mode: 0644}},
+			bodies:  []string{"[]", "pwned"},
+		},
+		{
+			name:    "outside the layout",
+			entries: []tar.Header{{Name: "data/bookmarks.json", Mode: 0644}, {Name: "etc/passwd", Mode: 0644}},
+			bodies:  []string{"[]", "pwned"},
+		},
+		{
+			name:    "symlink",
+			entries: []tar.Header{{Name: "data/bookmarks.json", Mode: 0644}, {Name: "data/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+			bodies:  []string{"[]", ""},
+		},
+		{
+			name:    "directory",
+			entries: []tar.Header{{Name: "data/bookmarks.json", Mode: 0644}, {Name: "data/dir/", Typeflag: tar.TypeDir, Mode: 0755}},
+			bodies:  []string{"[]", ""},
+		},
+		{name: "empty", entries: nil, bodies: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newBackupHarness(t)
+			resp, body := h.restore(backupArchive(t, tt.entries, tt.bodies))
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("status = %d, body %q", resp.StatusCode, body)
+			}
+			if _, err := os.Stat(filepath.Join(h.dataDir, "bookmarks.json")); !os.IsNotExist(err) {
+				t.Errorf("bookmarks.json written from a rejected archive: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(h.dataDir), "escaped.txt")); !os.IsNotExist(err) {
+				t.Errorf("entry escaped the data dir: %v", err)
+			}
+			leftovers, _ := filepath.Glob(filepath.Join(h.dataDir, ".restore-*"))
+			if len(leftovers) > 0 {
+				t.Errorf("staged files left behind: %v", leftovers)
+			}
+		})
+	}
+
+	t.Run("not gzip", func(t *testing.T) {
+		h, _ := newBackupHarness(t)
+		if resp, _ := h.restore([]byte("plain text")); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestRestoreSizeCap(t *testing.T) {
+	prev := maxRestoreBytes
+	maxRestoreBytes = 4 << 10
+	t.Cleanup(func() { maxRestoreBytes = prev })
+
+	h, _ := newBackupHarness(t)
+	// Random content, so gzip can't shrink it below the cap.
+	big := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(big)
+	archive := backupArchive(t, []tar.Header{{Name: "data/big.bin", Mode: 0644}}, []string{string(big)})
+	if len(archive) <= int(maxRestoreBytes) {
+		t.Fatalf("archive is only %d bytes", len(archive))
+	}
+	if resp, _ := h.restore(archive); resp.StatusCode == http.StatusOK {
+		t.Fatal("oversized archive accepted")
+	}
+	if _, err := os.Stat(filepath.Join(h.dataDir, "big.bin")); !os.IsNotExist(err) {
+		t.Errorf("big.bin written: %v", err)
+	}
+}
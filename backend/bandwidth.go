@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket limits writes to a rate in bytes per second. One bucket is
+// shared by every client to hold the instance to BANDWIDTH_LIMIT, and each
+// display gets its own for CLIENT_BANDWIDTH_LIMIT.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+var (
+	bandwidth  *tokenBucket
+	bytesSent  atomic.Int64
+	throttledN atomic.Int64
+	// clientBandwidth is the per-display cap from CLIENT_BANDWIDTH_LIMIT.
+	clientBandwidth int64
+)
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// take blocks until n bytes may be sent.
+func (b *tokenBucket) take(n int) {
+	need := float64(n)
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= need {
+			b.tokens -= need
+			b.mu.Unlock()
+			return
+		}
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		throttledN.Add(1)
+		time.Sleep(wait)
+	}
+}
+
+// parseByteSize accepts plain byte counts or values with a KB/MB/GB suffix
+// (powers of 1024).
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.mult
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, errors.New("size must not be negative")
+	}
+	return int64(v * float64(mult)), nil
+}
+
+func initBandwidth() error {
+	bandwidth, clientBandwidth = nil, 0
+	if raw := setting("BANDWIDTH_LIMIT"); raw != "" {
+		rate, err := parseByteSize(raw)
+		if err != nil {
+			return err
+		}
+		if rate > 0 {
+			bandwidth = newTokenBucket(rate)
+		}
+	}
+	if raw := setting("CLIENT_BANDWIDTH_LIMIT"); raw != "" {
+		rate, err := parseByteSize(raw)
+		if err != nil {
+			return fmt.Errorf("CLIENT_BANDWIDTH_LIMIT: %w", err)
+		}
+		clientBandwidth = rate
+	}
+	return nil
+}
+
+// throttledWriter takes every write from bucket (when set) and adds it to
+// counter (when set).
+type throttledWriter struct {
+	http.ResponseWriter
+	bucket  *tokenBucket
+	counter *atomic.Int64
+}
+
+func (t throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if t.bucket != nil {
+			if limit := int(t.bucket.burst); len(chunk) > limit {
+				chunk = chunk[:limit]
+			}
+			t.bucket.take(len(chunk))
+		}
+		n, err := t.ResponseWriter.Write(chunk)
+		written += n
+		if t.counter != nil {
+			t.counter.Add(int64(n))
+		}
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (t throttledWriter) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (t throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// throttle counts and, when BANDWIDTH_LIMIT is set, rate-limits the bytes
+// sent to displays.
+func throttle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(throttledWriter{ResponseWriter: w, bucket: bandwidth, counter: &bytesSent}, r)
+	}
+}
+
+func bandwidthReport() map[string]interface{} {
+	report := map[string]interface{}{
+		"bytesSent": bytesSent.Load(),
+		"throttled": throttledN.Load(),
+	}
+	if bandwidth != nil {
+		report["limitBytesPerSec"] = int64(bandwidth.rate)
+	}
+	if clientBandwidth > 0 {
+		report["clientLimitBytesPerSec"] = clientBandwidth
+	}
+	return report
+}
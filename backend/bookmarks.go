@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Bookmark is a saved destination with the settings it is best shown at.
+// Unlike history, bookmarks are only created by hand. Opening one switches
+// the target and applies whichever settings it holds.
+type Bookmark struct {
+	Name            string   `json:"name"`
+	URL             string   `json:"url"`
+	Icon            string   `json:"icon,omitempty"`
+	ScaleFactor     *float64 `json:"scaleFactor,omitempty"`
+	AutoScroll      *bool    `json:"autoScroll,omitempty"`
+	ScrollSpeed     *int     `json:"scrollSpeed,omitempty"`
+	ScrollDirection *string  `json:"scrollDirection,omitempty"`
+}
+
+var errBookmarkNotFound = errors.New("bookmark not found")
+
+var (
+	bookmarks      []Bookmark
+	bookmarksMutex sync.RWMutex
+	bookmarksPath  string
+)
+
+func (b *Bookmark) validate() error {
+	b.Name = strings.TrimSpace(b.Name)
+	if b.Name == "" {
+		return errors.New("name is required")
+	}
+	u, err := normalizeTargetURL(b.URL)
+	if err != nil {
+		return err
+	}
+	b.URL = u.String()
+	if b.ScaleFactor != nil && *b.ScaleFactor <= 0 {
+		return errors.New("scaleFactor must be positive")
+	}
+	if b.ScrollSpeed != nil && *b.ScrollSpeed <= 0 {
+		return errors.New("speed must be positive")
+	}
+	if b.ScrollDirection != nil && !scrollDirections[*b.ScrollDirection] {
+		return errors.New("direction must be vertical, horizontal or snake")
+	}
+	return nil
+}
+
+func initBookmarks() error {
+	bookmarksMutex.Lock()
+	defer bookmarksMutex.Unlock()
+	bookmarksPath = filepath.Join(dataDir, "bookmarks.json")
+	bookmarks = nil
+
+	data, err := os.ReadFile(bookmarksPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &bookmarks)
+}
+
+func saveBookmarks() {
+	bookmarksMutex.RLock()
+	data, err := json.MarshalIndent(bookmarks, "", "  ")
+	bookmarksMutex.RUnlock()
+	if err == nil {
+		err = os.WriteFile(bookmarksPath, data, 0644)
+	}
+	if err != nil {
+		slog.Error("failed to save bookmarks", "err", err)
+	}
+}
+
+func GetBookmarks() []Bookmark {
+	bookmarksMutex.RLock()
+	defer bookmarksMutex.RUnlock()
+	return append([]Bookmark(nil), bookmarks...)
+}
+
+func findBookmark(name string) (Bookmark, bool) {
+	bookmarksMutex.RLock()
+	defer bookmarksMutex.RUnlock()
+	for _, b := range bookmarks {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Bookmark{}, false
+}
+
+func upsertBookmark(b Bookmark) {
+	bookmarksMutex.Lock()
+	replaced := false
+	for i := range bookmarks {
+		if bookmarks[i].Name == b.Name {
+			bookmarks[i] = b
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		bookmarks = append(bookmarks, b)
+	}
+	bookmarksMutex.Unlock()
+	saveBookmarks()
+}
+
+func deleteBookmark(name string) bool {
+	bookmarksMutex.Lock()
+	found := false
+	for i := range bookmarks {
+		if bookmarks[i].Name == name {
+			bookmarks = append(bookmarks[:i], bookmarks[i+1:]...)
+			found = true
+			break
+		}
+	}
+	bookmarksMutex.Unlock()
+	if found {
+		saveBookmarks()
+	}
+	return found
+}
+
+// openBookmark applies the bookmark's settings and switches to its URL.
+// The settings are applied quietly so the target switch reloads the
+// displays only once.
+func openBookmark(name string) (Bookmark, error) {
+	b, ok := findBookmark(name)
+	if !ok {
+		return b, errBookmarkNotFound
+	}
+	u, err := normalizeTargetURL(b.URL)
+	if err != nil {
+		return b, err
+	}
+	if err := checkTarget(u); err != nil {
+		return b, err
+	}
+	state.Modify(func(c *Config) {
+		if b.ScaleFactor != nil {
+			c.ScaleFactor = *b.ScaleFactor
+		}
+		if b.AutoScroll != nil {
+			c.AutoScroll = *b.AutoScroll
+		}
+		if b.ScrollSpeed != nil {
+			c.ScrollSpeed = *b.ScrollSpeed
+		}
+		if b.ScrollDirection != nil {
+			c.ScrollDirection = *b.ScrollDirection
+		}
+	})
+	switchTarget(u)
+	return b, nil
+}
+
+// apiBookmarksHandler lists bookmarks (GET), creates or replaces one by
+// name (POST with a Bookmark body) or removes one (DELETE ?name=).
+func apiBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"bookmarks": GetBookmarks()})
+	case http.MethodPost:
+		var b Bookmark
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := b.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		upsertBookmark(b)
+		recordAudit("bookmark_set", map[string]interface{}{"name": b.Name, "url": b.URL})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if !deleteBookmark(name) {
+			http.NotFound(w, r)
+			return
+		}
+		recordAudit("bookmark_delete", map[string]interface{}{"name": name})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiBookmarkOpenHandler opens the bookmark ?name= on the displays.
+func apiBookmarkOpenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := openBookmark(r.URL.Query().Get("name"))
+	if err == errBookmarkNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
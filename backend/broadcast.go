@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Broadcast replaces whatever the display shows with a full-screen
+// message until it is cleared. It is saved to the data dir so an emergency
+// message survives a restart.
+type Broadcast struct {
+	Text       string `json:"text"`
+	ImageURL   string `json:"imageUrl,omitempty"`
+	Color      string `json:"color,omitempty"`
+	Background string `json:"background,omitempty"`
+	Since      int64  `json:"since"`
+}
+
+var (
+	broadcast      *Broadcast
+	broadcastMutex sync.RWMutex
+)
+
+var cssColorRe = regexp.MustCompile(`^[#a-zA-Z0-9(),.% ]*$`)
+
+// forwardedHeader marks broadcast requests relayed from another instance so
+// they aren't relayed again.
+const forwardedHeader = "X-CTRL-Forwarded"
+
+func broadcastPath() string {
+	return filepath.Join(dataDir, "broadcast.json")
+}
+
+func initBroadcast() error {
+	broadcastMutex.Lock()
+	broadcast = nil
+	broadcastMutex.Unlock()
+
+	data, err := os.ReadFile(broadcastPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var b Broadcast
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	broadcastMutex.Lock()
+	broadcast = &b
+	broadcastMutex.Unlock()
+	return nil
+}
+
+func GetBroadcast() *Broadcast {
+	broadcastMutex.RLock()
+	defer broadcastMutex.RUnlock()
+	if broadcast == nil {
+		return nil
+	}
+	b := *broadcast
+	return &b
+}
+
+func setBroadcast(b *Broadcast) error {
+	broadcastMutex.Lock()
+	broadcast = b
+	broadcastMutex.Unlock()
+	touchConfig()
+
+	if b == nil {
+		err := os.Remove(broadcastPath())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(broadcastPath(), data, 0644)
+}
+
+var broadcastTemplate = template.Must(template.New("broadcast").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Announcement</title>
+<style>
+html,body{margin:0;height:100%;overflow:hidden;font-family:sans-serif}
+body{display:flex;flex-direction:column;align-items:center;justify-content:center;text-align:center;color:{{.Color}};background:{{.Background}}}
+h1{font-size:5vw;margin:.5em 1em;white-space:pre-wrap}
+img{max-width:60vw;max-height:50vh}
+</style>
+</head>
+<body>
+{{if .ImageURL}}<img src="{{.ImageURL}}" alt="">{{end}}
+<h1>{{.Text}}</h1>
+<script>
+const initialVersion = {{.Version}};
+setInterval(() => {
+    fetch('/api/version').then(res => res.json()).then(data => {
+        if (data.lastModified > initialVersion) window.location.reload();
+    }).catch(() => {});
+}, 2000);
+</script>
+</body>
+</html>
+`))
+
+func serveBroadcast(w http.ResponseWriter, b *Broadcast) {
+	color, background := b.Color, b.Background
+	if color == "" {
+		color = "#fff"
+	}
+	if background == "" {
+		background = "#b00020"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	broadcastTemplate.Execute(w, map[string]interface{}{
+		"Text":       b.Text,
+		"ImageURL":   localHref(b.ImageURL),
+		"Color":      template.CSS(color),
+		"Background": template.CSS(background),
+		"Version":    state.Snapshot().LastModified,
+	})
+}
+
+// broadcastPeers lists other instances (base URLs, comma-separated in
+// BROADCAST_PEERS) that receive the same broadcast.
+func broadcastPeers() []string {
+	var peers []string
+	for _, p := range strings.Split(setting("BROADCAST_PEERS"), ",") {
+		if p = strings.TrimRight(strings.TrimSpace(p), "/"); p != "" {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+func fanOutBroadcast(method string, body []byte) map[string]string {
+	peers := broadcastPeers()
+	results := make(map[string]string, len(peers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			req, err := http.NewRequest(method, peer+"/api/broadcast", bytes.NewReader(body))
+			result := "ok"
+			if err == nil {
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set(forwardedHeader, "1")
+				if token := setting("ADMIN_TOKEN"); token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				var resp *http.Response
+				resp, err = client.Do(req)
+				if err == nil {
+					resp.Body.Close()
+					if resp.StatusCode >= 300 {
+						result = resp.Status
+					}
+				}
+			}
+			if err != nil {
+				result = err.Error()
+				slog.Warn("broadcast relay failed", "peer", peer, "err", err)
+			}
+			mu.Lock()
+			results[peer] = result
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return results
+}
+
+// apiBroadcastHandler shows (POST), clears (DELETE) or reports (GET) the
+// emergency broadcast, relaying changes to BROADCAST_PEERS.
+func apiBroadcastHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"broadcast": GetBroadcast()})
+		return
+	}
+	requireAdminIfConfigured(updateBroadcast)(w, r)
+}
+
+func updateBroadcast(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	switch r.Method {
+	case http.MethodPost:
+		var b Broadcast
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil || strings.TrimSpace(b.Text) == "" {
+			http.Error(w, "text is required", http.StatusBadRequest)
+			return
+		}
+		if !cssColorRe.MatchString(b.Color) || !cssColorRe.MatchString(b.Background) {
+			http.Error(w, "Invalid color", http.StatusBadRequest)
+			return
+		}
+		b.Since = time.Now().UnixMilli()
+		if err := setBroadcast(&b); err != nil {
+			slog.Error("failed to save broadcast", "err", err)
+		}
+		recordAudit("broadcast_on", map[string]interface{}{"text": b.Text})
+		slog.Warn("emergency broadcast started", "text", b.Text)
+		body, _ = json.Marshal(b)
+	case http.MethodDelete:
+		if err := setBroadcast(nil); err != nil {
+			slog.Error("failed to clear broadcast", "err", err)
+		}
+		recordAudit("broadcast_off", nil)
+		slog.Info("emergency broadcast cleared")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := map[string]interface{}{"broadcast": GetBroadcast()}
+	if r.Header.Get(forwardedHeader) == "" {
+		if peers := fanOutBroadcast(r.Method, body); len(peers) > 0 {
+			result["peers"] = peers
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
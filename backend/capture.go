@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// SetCaptureSelector limits the display to a single element of the target
+// page. An empty selector shows the whole page again.
+func SetCaptureSelector(selector string) {
+	state.Update("", func(c *Config) { c.CaptureSelector = selector })
+}
+
+// apiConfigCaptureHandler returns (GET) or changes (POST {"selector": ...})
+// the capture selector.
+func apiConfigCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Selector string `json:"selector"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		selector := strings.TrimSpace(req.Selector)
+		SetCaptureSelector(selector)
+		slog.Info("capture selector changed", "selector", selector)
+		recordAudit("capture_selector", map[string]interface{}{"selector": selector})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"selector": state.Snapshot().CaptureSelector})
+}
+
+// captureScript scales the element matching config.captureSelector to fill
+// the screen and clips everything around it. The element is re-measured
+// periodically and on resize, and looked up again if the page replaces it.
+const captureScript = `
+<script>
+(() => {
+    const selector = config.captureSelector;
+    let last = '';
+    const measure = () => {
+        const el = document.querySelector(selector);
+        if (!el || !window.ctrlSetTransform) return;
+        // Measure in untransformed page coordinates, undoing whatever is
+        // currently applied to the root element.
+        const b = el.getBoundingClientRect();
+        const t = getComputedStyle(document.documentElement).transform;
+        const inv = t && t !== 'none' ? new DOMMatrix(t).inverse() : new DOMMatrix();
+        const p1 = inv.transformPoint(new DOMPoint(b.left, b.top)), p2 = inv.transformPoint(new DOMPoint(b.right, b.bottom));
+        const r = { width: p2.x - p1.x, height: p2.y - p1.y };
+        if (r.width <= 0 || r.height <= 0) return;
+        const left = p1.x + window.scrollX, top = p1.y + window.scrollY;
+        const key = [left, top, r.width, r.height, window.innerWidth, window.innerHeight, window.scrollX, window.scrollY].map(Math.round).join(',');
+        if (key === last) return;
+        last = key;
+        const scale = Math.min(window.innerWidth / r.width, window.innerHeight / r.height);
+        const doc = document.documentElement;
+        doc.style.overflow = 'hidden';
+        doc.style.clipPath = 'inset(' + top + 'px ' + (doc.scrollWidth - left - r.width) + 'px ' +
+            (doc.scrollHeight - top - r.height) + 'px ' + left + 'px)';
+        window.ctrlSetTransform('capture', 'scale(' + scale + ') translate(' + (window.scrollX - left) + 'px,' + (window.scrollY - top) + 'px)');
+    };
+    window.addEventListener('resize', measure);
+    window.addEventListener('load', measure);
+    document.addEventListener('DOMContentLoaded', measure);
+    setInterval(measure, 500);
+})();
+</script>
+`
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Displays are told apart by a cookie set on their first page load. The
+// cookie stays between the display and this server; it is never sent to
+// the target.
+const (
+	clientCookie     = "ctrl_client"
+	clientOnlineFor  = 30 * time.Second
+	clientForgetFor  = 24 * time.Hour
+	maxTrackedClient = 1000
+)
+
+type displayClient struct {
+	ID           string `json:"id"`
+	RemoteAddr   string `json:"remoteAddr"`
+	UserAgent    string `json:"userAgent"`
+	FirstSeen    int64  `json:"firstSeen"`
+	LastSeen     int64  `json:"lastSeen"`
+	Requests     int64  `json:"requests"`
+	BytesSent    int64  `json:"bytesSent"`
+	Disconnected bool   `json:"disconnected"`
+	ReadOnly     bool   `json:"readOnly"`
+	Online       bool   `json:"online"`
+
+	bucket *tokenBucket
+}
+
+var (
+	clients      = map[string]*displayClient{}
+	clientsMutex sync.Mutex
+)
+
+func newClientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clientFor returns the display making r, registering it when it loads a
+// page without a cookie. Other requests without one (API calls from admin
+// tools, for instance) are not tracked.
+func clientFor(w http.ResponseWriter, r *http.Request) *displayClient {
+	id := ""
+	if c, err := r.Cookie(clientCookie); err == nil {
+		id = c.Value
+	}
+	now := time.Now().UnixMilli()
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	c, ok := clients[id]
+	if !ok {
+		if !isNavigation(r) || strings.HasPrefix(r.URL.Path, "/api/") {
+			return nil
+		}
+		if id == "" {
+			id = newClientID()
+			http.SetCookie(w, &http.Cookie{Name: clientCookie, Value: id, Path: "/", MaxAge: 365 * 24 * 3600, HttpOnly: true, Secure: requestScheme(r) == "https", SameSite: http.SameSiteLaxMode})
+		}
+		pruneClients()
+		c = &displayClient{ID: id, FirstSeen: now, ReadOnly: setting("VIEWERS_READ_ONLY") == "true"}
+		if clientBandwidth > 0 {
+			c.bucket = newTokenBucket(clientBandwidth)
+		}
+		clients[id] = c
+	}
+	c.RemoteAddr = r.RemoteAddr
+	c.UserAgent = r.UserAgent()
+	c.LastSeen = now
+	c.Requests++
+	return c
+}
+
+// pruneClients forgets displays not seen for a day, and the oldest ones
+// when too many are tracked. Callers must hold clientsMutex.
+func pruneClients() {
+	cutoff := time.Now().Add(-clientForgetFor).UnixMilli()
+	for id, c := range clients {
+		if c.LastSeen < cutoff {
+			delete(clients, id)
+		}
+	}
+	for len(clients) >= maxTrackedClient {
+		var oldest *displayClient
+		for _, c := range clients {
+			if oldest == nil || c.LastSeen < oldest.LastSeen {
+				oldest = c
+			}
+		}
+		delete(clients, oldest.ID)
+	}
+}
+
+// stripClientCookie keeps the tracking cookie from reaching the target.
+func stripClientCookie(req *http.Request) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != clientCookie && c.Name != viewerCookie {
+			req.AddCookie(c)
+		}
+	}
+}
+
+const disconnectedPage = `<!doctype html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="30"><title>Disconnected</title>
+<style>html,body{margin:0;height:100%;background:#000;color:#888;font-family:sans-serif;display:flex;align-items:center;justify-content:center}</style>
+</head><body><p>This display has been disconnected by an administrator.</p></body></html>`
+
+// trackClients records requests and bytes per display, holds each display
+// to CLIENT_BANDWIDTH_LIMIT, keeps read-only viewers from changing anything
+// and turns away displays an administrator has disconnected.
+func trackClients(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		viewer := hasViewerToken(r)
+		if viewer && r.URL.Query().Has("viewer") {
+			http.SetCookie(w, &http.Cookie{Name: viewerCookie, Value: setting("VIEWER_TOKEN"), Path: "/", MaxAge: 365 * 24 * 3600, HttpOnly: true, Secure: requestScheme(r) == "https", SameSite: http.SameSiteLaxMode})
+		}
+		c := clientFor(w, r)
+		disconnected, readOnly := false, viewer
+		if c != nil {
+			clientsMutex.Lock()
+			if viewer {
+				c.ReadOnly = true
+			}
+			disconnected, readOnly = c.Disconnected, c.ReadOnly
+			clientsMutex.Unlock()
+		}
+		if readOnly {
+			if !readOnlyAllowed(r) {
+				http.Error(w, "Read-only viewer", http.StatusForbidden)
+				return
+			}
+			r = withReadOnly(r)
+		}
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if disconnected {
+			if isNavigation(r) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(disconnectedPage))
+			} else {
+				http.Error(w, "Display disconnected", http.StatusForbidden)
+			}
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		if c.bucket != nil {
+			rec.ResponseWriter = throttledWriter{ResponseWriter: w, bucket: c.bucket}
+		}
+		next.ServeHTTP(rec, r)
+		clientsMutex.Lock()
+		c.BytesSent += int64(rec.bytes)
+		clientsMutex.Unlock()
+	})
+}
+
+// listClients returns copies of the tracked displays, oldest first.
+func listClients() []displayClient {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	online := time.Now().Add(-clientOnlineFor).UnixMilli()
+	list := make([]displayClient, 0, len(clients))
+	for _, c := range clients {
+		entry := *c
+		entry.Online = c.LastSeen >= online
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FirstSeen < list[j].FirstSeen })
+	return list
+}
+
+func apiClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"clients": listClients()})
+}
+
+// clientDisconnectHandler disconnects (or lets back in) the display given
+// by ?id=.
+func clientDisconnectHandler(disconnect bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		clientsMutex.Lock()
+		c, ok := clients[id]
+		if ok {
+			c.Disconnected = disconnect
+		}
+		clientsMutex.Unlock()
+		if !ok {
+			http.Error(w, "Unknown client", http.StatusNotFound)
+			return
+		}
+		slog.Info("display client access changed", "id", id, "disconnected", disconnect)
+		recordAudit("client_access", map[string]interface{}{"id": id, "disconnected": disconnect})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "disconnected": disconnect})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The clipboard bridge moves text between the operator and the display:
+// copies made on the display are reported back, and text posted by the
+// operator is picked up by the display and typed into the focused field.
+type clipText struct {
+	Seq  int    `json:"seq"`
+	Text string `json:"text"`
+	Time int64  `json:"time"`
+}
+
+const maxClipboardBytes = 64 * 1024
+
+var (
+	clipCopied     clipText
+	clipPaste      clipText
+	clipboardMutex sync.RWMutex
+)
+
+func readClipText(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Text string `json:"text"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxClipboardBytes)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid clipboard payload", http.StatusBadRequest)
+		return "", false
+	}
+	return body.Text, true
+}
+
+// apiClipboardHandler returns the last copied text (GET) or queues text to
+// paste on the display (POST).
+func apiClipboardHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		clipboardMutex.RLock()
+		resp := map[string]interface{}{"copied": clipCopied, "paste": clipPaste}
+		clipboardMutex.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	case http.MethodPost:
+		text, ok := readClipText(w, r)
+		if !ok {
+			return
+		}
+		clipboardMutex.Lock()
+		clipPaste = clipText{Seq: clipPaste.Seq + 1, Text: text, Time: time.Now().UnixMilli()}
+		paste := clipPaste
+		clipboardMutex.Unlock()
+		recordAudit("clipboard_paste", map[string]interface{}{"size": len(text)})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(paste)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiClipboardCopiedHandler receives text copied on the display.
+func apiClipboardCopiedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	text, ok := readClipText(w, r)
+	if !ok {
+		return
+	}
+	clipboardMutex.Lock()
+	clipCopied = clipText{Seq: clipCopied.Seq + 1, Text: text, Time: time.Now().UnixMilli()}
+	clipboardMutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiClipboardPasteHandler lets the display fetch queued text newer than
+// ?since=.
+func apiClipboardPasteHandler(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	clipboardMutex.RLock()
+	paste := clipPaste
+	clipboardMutex.RUnlock()
+
+	if paste.Seq <= since {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paste)
+}
+
+// clipboardScript reports copies and applies queued pastes on the display.
+const clipboardScript = `
+<script>
+(() => {
+    document.addEventListener('copy', () => {
+        const text = String(window.getSelection() || '');
+        if (text) fetch('/api/clipboard/copied', { method: 'POST', body: JSON.stringify({ text }) }).catch(() => {});
+    }, true);
+    let seq = -1;
+    const poll = () => fetch('/api/clipboard/paste?since=' + Math.max(seq, 0), { cache: 'no-store' })
+        .then(res => res.status === 200 ? res.json() : null)
+        .then(data => {
+            if (!data) { if (seq < 0) seq = 0; return; }
+            const first = seq < 0;
+            seq = data.seq;
+            if (first) return;
+            const el = document.activeElement;
+            if (el && el !== document.body) {
+                el.focus();
+                if (!document.execCommand('insertText', false, data.text) && 'setRangeText' in el) {
+                    el.setRangeText(data.text, el.selectionStart, el.selectionEnd, 'end');
+                    el.dispatchEvent(new Event('input', { bubbles: true }));
+                }
+            }
+        })
+        .catch(() => {});
+    poll();
+    setInterval(poll, 2000);
+})();
+</script>
+`
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 (NTP epoch)
+// and 1970-01-01 (Unix epoch).
+const ntpEpochOffset = 2208988800
+
+const clockCheckInterval = 15 * time.Minute
+
+type clockStatus struct {
+	mu        sync.RWMutex
+	server    string
+	threshold time.Duration
+	offset    time.Duration
+	checkedAt time.Time
+	syncedAt  time.Time
+	err       string
+}
+
+var clock clockStatus
+
+func ntpTime(b []byte) time.Time {
+	secs := binary.BigEndian.Uint32(b[0:4])
+	frac := binary.BigEndian.Uint32(b[4:8])
+	nanos := (int64(frac) * 1e9) >> 32
+	return time.Unix(int64(secs)-ntpEpochOffset, nanos)
+}
+
+// queryNTP performs a single SNTP exchange and returns how far the local
+// clock is behind (positive) or ahead (negative) of the server.
+func queryNTP(server string) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(server, "123"), 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // LI=0, VN=4, Mode=3 (client)
+	t1 := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 {
+		return 0, errors.New("short NTP response")
+	}
+	if resp[1] == 0 {
+		return 0, errors.New("NTP server is unsynchronized")
+	}
+
+	t2 := ntpTime(resp[32:40])
+	t3 := ntpTime(resp[40:48])
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+// initClockCheck starts the periodic drift check. NTP_SERVER selects the
+// reference (set to "off" to disable) and CLOCK_DRIFT_THRESHOLD the drift in
+// seconds that triggers a warning.
+func initClockCheck() {
+	server := setting("NTP_SERVER")
+	if server == "off" {
+		return
+	}
+	if server == "" {
+		server = "pool.ntp.org"
+	}
+	threshold, _ := strconv.ParseFloat(setting("CLOCK_DRIFT_THRESHOLD"), 64)
+	if threshold <= 0 {
+		threshold = 30
+	}
+
+	clock.mu.Lock()
+	clock.server = server
+	clock.threshold = time.Duration(threshold * float64(time.Second))
+	clock.mu.Unlock()
+
+	go func() {
+		for {
+			checkClock()
+			time.Sleep(clockCheckInterval)
+		}
+	}()
+}
+
+func checkClock() {
+	clock.mu.RLock()
+	server, threshold := clock.server, clock.threshold
+	clock.mu.RUnlock()
+
+	offset, err := queryNTP(server)
+
+	clock.mu.Lock()
+	clock.checkedAt = time.Now()
+	if err != nil {
+		clock.err = err.Error()
+	} else {
+		clock.err = ""
+		clock.offset = offset
+		clock.syncedAt = clock.checkedAt
+	}
+	clock.mu.Unlock()
+
+	if err != nil {
+		slog.Warn("clock check failed", "server", server, "err", err)
+		return
+	}
+	if offset.Abs() > threshold {
+		slog.Warn("system clock drift exceeds threshold", "offset", offset, "threshold", threshold)
+		notify(EventClockDrift, "system clock is off by "+offset.Round(time.Second).String(), map[string]interface{}{
+			"offsetSec": offset.Seconds(),
+			"server":    server,
+		})
+	}
+}
+
+// clockReport summarises the last drift check for /api/status.
+func clockReport() map[string]interface{} {
+	clock.mu.RLock()
+	defer clock.mu.RUnlock()
+	if clock.server == "" {
+		return map[string]interface{}{"enabled": false}
+	}
+	report := map[string]interface{}{
+		"enabled":      true,
+		"server":       clock.server,
+		"thresholdSec": clock.threshold.Seconds(),
+	}
+	if !clock.checkedAt.IsZero() {
+		report["checkedAt"] = clock.checkedAt.UnixMilli()
+	}
+	if !clock.syncedAt.IsZero() {
+		report["syncedAt"] = clock.syncedAt.UnixMilli()
+		report["offsetSec"] = math.Round(clock.offset.Seconds()*1000) / 1000
+		report["drifting"] = clock.offset.Abs() > clock.threshold
+	}
+	if clock.err != "" {
+		report["error"] = clock.err
+	}
+	return report
+}
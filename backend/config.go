@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,22 +19,53 @@ type Cookie struct {
 	Path   string `json:"path"`
 }
 
+// Config is the runtime configuration shared by the proxy and the
+// displays. Fields start from the startup settings named next to them (see
+// settings.go) and can then be changed through /api/config and the
+// /api/config/* endpoints.
 type Config struct {
-	TargetURL       string   `json:"targetUrl"`
-	ScaleFactor     float64  `json:"scaleFactor"`
-	AutoScroll      bool     `json:"autoScroll"`
-	ScrollSpeed     int      `json:"scrollSpeed"`
-	ScrollSequence  string   `json:"scrollSequence"`
-	InterfaceLocked bool     `json:"interfaceLocked"`
-	LastModified    int64    `json:"lastModified"`
-	CookieJar       []Cookie `json:"cookieJar"`
+	TargetURL       string          `json:"targetUrl"`       // TARGET_URL
+	ScaleFactor     float64         `json:"scaleFactor"`     // SCALE_FACTOR, default 1
+	AutoScroll      bool            `json:"autoScroll"`      // AUTO_SCROLL
+	ScrollSpeed     int             `json:"scrollSpeed"`     // SCROLL_SPEED, default 50 px/s
+	ScrollSpeedX    int             `json:"scrollSpeedX"`    // SCROLL_SPEED_X, default ScrollSpeed
+	ScrollDirection string          `json:"scrollDirection"` // SCROLL_DIRECTION, default vertical
+	ScrollSequence  string          `json:"scrollSequence"`  // SCROLL_SEQUENCE
+	ScrollAnchors   string          `json:"scrollAnchors"`   // SCROLL_ANCHORS
+	Lock            string          `json:"lock"`            // LOCK (or INTERFACE_LOCKED), see lockLevels
+	KeyboardEnabled bool            `json:"keyboardEnabled"` // ON_SCREEN_KEYBOARD
+	CaptureSelector string          `json:"captureSelector"` // CAPTURE_SELECTOR
+	HideSelectors   []string        `json:"hideSelectors"`   // HIDE_SELECTORS
+	CustomCSS       string          `json:"customCss"`       // CUSTOM_CSS
+	Ready           ReadyWait       `json:"ready"`           // per page, see page rules
+	ReloadInterval  int             `json:"reloadInterval"`  // AUTO_RELOAD_INTERVAL, seconds
+	ReloadMode      string          `json:"reloadMode"`      // AUTO_RELOAD_MODE, default always
+	ReloadProbe     string          `json:"reloadProbe"`     // AUTO_RELOAD_PROBE
+	ReloadHard      bool            `json:"reloadHard"`      // AUTO_RELOAD_HARD
+	Emulation       Emulation       `json:"emulation"`       // GEOLOCATION, TIMEZONE, LOCALE
+	Device          DeviceEmulation `json:"device"`          // DEVICE, USER_AGENT
+	Resolution      Resolution      `json:"resolution"`      // RESOLUTION
+	Rotation        int             `json:"rotation"`        // ROTATION
+	Theme           Theme           `json:"theme"`           // THEME, THEME_DARK_FROM, THEME_DARK_UNTIL, THEME_INVERT
+	DisplayFilters  DisplayFilters  `json:"displayFilters"`  // DISPLAY_*, NIGHT_*
+	Identity        Identity        `json:"identity"`        // INSTANCE_NAME, INSTANCE_LOCATION, INSTANCE_TAGS
+	Power           Power           `json:"power"`           // POWER_OFF_FROM, POWER_OFF_UNTIL
+	LastModified    int64           `json:"lastModified"`
+	ReloadVersion   int64           `json:"reloadVersion"`
+	// HardReloadVersion is the last version that asked displays to clear
+	// their cache before reloading.
+	HardReloadVersion int64    `json:"hardReloadVersion"`
+	CookieJar         []Cookie `json:"cookieJar"`
+	// ReadOnlyViewer is set on the copy used to render a page for a
+	// read-only viewer; it is never stored.
+	ReadOnlyViewer bool `json:"-"`
 }
 
 var (
-	config      Config
-	configMutex sync.RWMutex
-	startTime   int64
-	dataDir     string
+	startTime int64
+	dataDir   string
+	// cookieMutex guards cookiePath and serializes writes to it.
+	cookieMutex sync.Mutex
 	cookiePath  string
 )
 
@@ -41,75 +73,125 @@ func initConfig() error {
 	startTime = time.Now().UnixMilli()
 
 	// Setup Data Directory
-	dataDir = os.Getenv("DATA_DIR")
+	dataDir = setting("DATA_DIR")
 	if dataDir == "" {
 		dataDir = "./data"
 	}
+	cookieMutex.Lock()
 	cookiePath = filepath.Join(dataDir, "cookies.json")
+	cookieMutex.Unlock()
 
 	// Ensure directory exists
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	targetURL := os.Getenv("TARGET_URL")
+	targetURL := setting("TARGET_URL")
 	if targetURL == "" {
 		targetURL = "https://github.com/leraptor65/centralizedtransmissionandremoteloading"
 	}
 
-	scaleFactor, _ := strconv.ParseFloat(os.Getenv("SCALE_FACTOR"), 64)
+	scaleFactor, _ := strconv.ParseFloat(setting("SCALE_FACTOR"), 64)
 	if scaleFactor <= 0 {
 		scaleFactor = 1.0
 	}
 
-	autoScroll := os.Getenv("AUTO_SCROLL") == "true"
-	scrollSpeed, _ := strconv.Atoi(os.Getenv("SCROLL_SPEED"))
+	autoScroll := setting("AUTO_SCROLL") == "true"
+	scrollSpeed, _ := strconv.Atoi(setting("SCROLL_SPEED"))
 	if scrollSpeed <= 0 {
 		scrollSpeed = 50
 	}
 
-	config = Config{
+	scrollSpeedX, _ := strconv.Atoi(setting("SCROLL_SPEED_X"))
+	if scrollSpeedX <= 0 {
+		scrollSpeedX = scrollSpeed
+	}
+	scrollDirection := setting("SCROLL_DIRECTION")
+	if !scrollDirections[scrollDirection] {
+		scrollDirection = "vertical"
+	}
+
+	reloadInterval, _ := strconv.Atoi(setting("AUTO_RELOAD_INTERVAL"))
+	reloadMode := setting("AUTO_RELOAD_MODE")
+	if reloadMode != "changed" {
+		reloadMode = "always"
+	}
+
+	config := Config{
 		TargetURL:       targetURL,
 		ScaleFactor:     scaleFactor,
 		AutoScroll:      autoScroll,
 		ScrollSpeed:     scrollSpeed,
-		ScrollSequence:  os.Getenv("SCROLL_SEQUENCE"),
-		InterfaceLocked: os.Getenv("INTERFACE_LOCKED") == "true",
+		ScrollSpeedX:    scrollSpeedX,
+		ScrollDirection: scrollDirection,
+		ScrollSequence:  setting("SCROLL_SEQUENCE"),
+		ScrollAnchors:   setting("SCROLL_ANCHORS"),
+		KeyboardEnabled: setting("ON_SCREEN_KEYBOARD") == "true",
+		CaptureSelector: setting("CAPTURE_SELECTOR"),
+		ReloadInterval:  max(reloadInterval, 0),
+		ReloadMode:      reloadMode,
+		ReloadProbe:     setting("AUTO_RELOAD_PROBE"),
+		ReloadHard:      setting("AUTO_RELOAD_HARD") == "true",
 		LastModified:    startTime,
+		ReloadVersion:   startTime,
 		CookieJar:       []Cookie{},
 	}
 
+	if _, err := parseScrollSequence(config.ScrollSequence); err != nil {
+		slog.Warn("ignoring invalid SCROLL_SEQUENCE", "err", err)
+		config.ScrollSequence = ""
+	}
+
+	if _, err := parseScrollAnchors(config.ScrollAnchors); err != nil {
+		slog.Warn("ignoring invalid SCROLL_ANCHORS", "err", err)
+		config.ScrollAnchors = ""
+	}
+
+	state.Modify(func(c *Config) { *c = config })
+
 	// Load persistent cookies
 	if err := loadCookies(); err != nil {
-		fmt.Printf("Warning: failed to load cookies: %v\n", err)
+		slog.Warn("failed to load cookies", "err", err)
 	}
 
 	return nil
 }
 
-func GetConfig() Config {
-	configMutex.RLock()
-	defer configMutex.RUnlock()
-	return config
+// touchConfig bumps LastModified so every connected display reloads on its
+// next version poll.
+func touchConfig() {
+	state.Update("", nil)
+}
+
+// SetTargetURL switches the proxied site and bumps LastModified so displays
+// navigate to it on their next version poll.
+func SetTargetURL(u string) {
+	state.Update("", func(c *Config) { c.TargetURL = u })
 }
 
 func loadCookies() error {
-	if _, err := os.Stat(cookiePath); os.IsNotExist(err) {
+	cookieMutex.Lock()
+	path := cookiePath
+	cookieMutex.Unlock()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil
 	}
-	data, err := os.ReadFile(cookiePath)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	configMutex.Lock()
-	defer configMutex.Unlock()
-	return json.Unmarshal(data, &config.CookieJar)
+	jar := []Cookie{}
+	if err := json.Unmarshal(data, &jar); err != nil {
+		return err
+	}
+	state.Modify(func(c *Config) { c.CookieJar = jar })
+	return nil
 }
 
 func saveCookies() error {
-	configMutex.RLock()
-	data, err := json.MarshalIndent(config.CookieJar, "", "  ")
-	configMutex.RUnlock()
+	cookieMutex.Lock()
+	defer cookieMutex.Unlock()
+	data, err := json.MarshalIndent(state.Snapshot().CookieJar, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -117,37 +199,37 @@ func saveCookies() error {
 }
 
 func UpdateCookies(cookies []*http.Cookie) {
-	configMutex.Lock()
 	updated := false
-	existingMap := make(map[string]int)
-	for i, c := range config.CookieJar {
-		existingMap[c.Name] = i
-	}
-
-	for _, c := range cookies {
-		nc := Cookie{
-			Name:   c.Name,
-			Value:  c.Value,
-			Domain: c.Domain,
-			Path:   c.Path,
+	state.Modify(func(config *Config) {
+		existingMap := make(map[string]int)
+		for i, c := range config.CookieJar {
+			existingMap[c.Name] = i
 		}
 
-		if idx, ok := existingMap[c.Name]; ok {
-			if config.CookieJar[idx].Value != c.Value {
-				config.CookieJar[idx] = nc
+		for _, c := range cookies {
+			nc := Cookie{
+				Name:   c.Name,
+				Value:  c.Value,
+				Domain: c.Domain,
+				Path:   c.Path,
+			}
+
+			if idx, ok := existingMap[c.Name]; ok {
+				if config.CookieJar[idx].Value != c.Value {
+					config.CookieJar[idx] = nc
+					updated = true
+				}
+			} else {
+				config.CookieJar = append(config.CookieJar, nc)
+				existingMap[c.Name] = len(config.CookieJar) - 1
 				updated = true
 			}
-		} else {
-			config.CookieJar = append(config.CookieJar, nc)
-			existingMap[c.Name] = len(config.CookieJar) - 1
-			updated = true
 		}
-	}
-	configMutex.Unlock()
+	})
 
 	if updated {
 		if err := saveCookies(); err != nil {
-			fmt.Printf("Error saving cookies: %v\n", err)
+			slog.Error("failed to save cookies", "err", err)
 		}
 	}
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var allowedSchemes = map[string]bool{"http": true, "https": true}
+
+// normalizeTargetURL validates a user-supplied target and returns it in
+// canonical form: scheme defaulted to https, lowercase scheme and host,
+// default ports dropped and an empty path turned into "/".
+func normalizeTargetURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, errors.New("url is required")
+	}
+	if strings.HasPrefix(raw, localScheme) {
+		if err := validateLocalTarget(raw); err != nil {
+			return nil, err
+		}
+		return &url.URL{Scheme: "local", Opaque: "//" + strings.TrimPrefix(raw, localScheme)}, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !allowedSchemes[u.Scheme] {
+		return nil, errors.New("scheme " + u.Scheme + " is not allowed")
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("url has no host")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.User = nil
+	return u, nil
+}
+
+// checkTarget applies the network guard to non-local targets.
+func checkTarget(u *url.URL) error {
+	if u.Scheme == "local" {
+		return nil
+	}
+	return checkTargetHost(u.Hostname())
+}
+
+// switchTarget makes u the new target and returns the previous one.
+func switchTarget(u *url.URL) string {
+	previous := state.Snapshot().TargetURL
+	SetTargetURL(u.String())
+	probe.reset()
+	slog.Info("target URL changed", "from", previous, "to", u.String())
+	notify(EventTargetChanged, "target changed to "+u.String(), map[string]interface{}{"from": previous, "to": u.String()})
+	return previous
+}
+
+// apiConfigURLHandler changes the target at runtime. Plain-HTTP targets must
+// be confirmed with confirm=true. The response carries the normalized URL
+// and the result of a reachability check against it.
+func apiConfigURLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		URL     string `json:"url"`
+		Confirm bool   `json:"confirm"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.URL = r.FormValue("url")
+		req.Confirm = r.FormValue("confirm") == "true"
+	}
+
+	u, err := normalizeTargetURL(req.URL)
+	if err != nil {
+		http.Error(w, "Invalid URL: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkTarget(u); err != nil {
+		http.Error(w, "Target not allowed: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	if u.Scheme == "http" && !req.Confirm {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"url":   u.String(),
+			"error": "non-HTTPS target requires confirm=true",
+		})
+		return
+	}
+
+	navigation := map[string]interface{}{"ok": true}
+	status, latency, err := probeURL(u.String())
+	navigation["latencyMs"] = latency.Milliseconds()
+	if err != nil {
+		navigation["ok"] = false
+		navigation["error"] = err.Error()
+	} else {
+		navigation["status"] = status
+		navigation["ok"] = status < 500
+	}
+
+	previous := switchTarget(u)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        u.String(),
+		"previous":   previous,
+		"navigation": navigation,
+	})
+}
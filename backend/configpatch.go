@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// /api/config edits the whole runtime configuration in one call. Changes
+// are sent as a JSON merge patch (RFC 7396): only the fields given change,
+// nested objects are merged and null resets a field. The result is
+// validated as a whole before anything is applied.
+
+const maxScaleFactor = 10
+
+// serverConfigFields are maintained by the server and cannot be patched.
+var serverConfigFields = map[string]bool{"lastModified": true, "reloadVersion": true, "hardReloadVersion": true, "cookieJar": true}
+
+// softConfigFields maps fields displays can apply in place to their
+// setting name, see softApply.
+var softConfigFields = map[string]string{
+	"scrollSpeed":    "scrollspeed",
+	"scrollSpeedX":   "scrollspeed",
+	"scrollSequence": "scrollsequence",
+	"customCss":      "css",
+	"hideSelectors":  "css",
+}
+
+// ConfigChange is one field changed by a patch.
+type ConfigChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+func configMap(c Config) map[string]interface{} {
+	data, _ := json.Marshal(c)
+	m := map[string]interface{}{}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+// mergePatch applies patch to target in place.
+func mergePatch(target, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+		if p, ok := v.(map[string]interface{}); ok {
+			t, ok := target[k].(map[string]interface{})
+			if !ok {
+				t = map[string]interface{}{}
+			}
+			mergePatch(t, p)
+			target[k] = t
+			continue
+		}
+		target[k] = v
+	}
+}
+
+func decodeConfig(m map[string]interface{}) (Config, error) {
+	data, _ := json.Marshal(m)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var c Config
+	err := dec.Decode(&c)
+	return c, err
+}
+
+// validateConfig checks every setting of c and reports all problems at
+// once. The target is only checked when checkTargetURL is set, since the one
+// from TARGET_URL is trusted as given.
+func validateConfig(c *Config, checkTargetURL bool) error {
+	var errs []error
+	add := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+	if checkTargetURL {
+		u, err := normalizeTargetURL(c.TargetURL)
+		if err == nil {
+			err = checkTarget(u)
+			c.TargetURL = u.String()
+		}
+		add("targetUrl", err)
+	}
+	if c.ScaleFactor <= 0 || c.ScaleFactor > maxScaleFactor {
+		add("scaleFactor", fmt.Errorf("must be greater than 0 and at most %d", maxScaleFactor))
+	}
+	if c.ScrollSpeed <= 0 || c.ScrollSpeedX <= 0 {
+		add("scrollSpeed", errors.New("speed must be positive"))
+	}
+	if !scrollDirections[c.ScrollDirection] {
+		add("scrollDirection", errors.New("must be vertical, horizontal or snake"))
+	}
+	_, err := parseScrollSequence(c.ScrollSequence)
+	add("scrollSequence", err)
+	_, err = parseScrollAnchors(c.ScrollAnchors)
+	add("scrollAnchors", err)
+	if c.CaptureSelector != "" {
+		add("captureSelector", validateSelectors([]string{c.CaptureSelector}))
+	}
+	add("hideSelectors", validateSelectors(c.HideSelectors))
+	add("ready", c.Ready.validate())
+	if c.ReloadInterval < 0 {
+		add("reloadInterval", errors.New("must not be negative"))
+	}
+	if c.ReloadMode != "always" && c.ReloadMode != "changed" {
+		add("reloadMode", errors.New("must be always or changed"))
+	}
+	add("emulation", c.Emulation.validate())
+	add("device", c.Device.validate())
+	add("resolution", c.Resolution.validate())
+	if !validRotation(c.Rotation) {
+		add("rotation", errInvalidRotation)
+	}
+	theme := c.Theme
+	add("theme", theme.validate())
+	add("displayFilters", c.DisplayFilters.validate())
+	add("identity", c.Identity.validate())
+	add("power", c.Power.validate())
+	if _, ok := lockLevels[c.Lock]; !ok {
+		add("lock", errInvalidLock)
+	}
+	return errors.Join(errs...)
+}
+
+// patchConfig returns current with patch applied and validated, along
+// with the fields that change.
+func patchConfig(current Config, patch map[string]interface{}) (Config, map[string]ConfigChange, error) {
+	for k := range patch {
+		if serverConfigFields[k] {
+			return current, nil, fmt.Errorf("%s cannot be changed", k)
+		}
+	}
+	before := configMap(current)
+	merged := configMap(current)
+	mergePatch(merged, patch)
+	next, err := decodeConfig(merged)
+	if err != nil {
+		return current, nil, err
+	}
+	next.CookieJar = current.CookieJar
+	targetPatched := patch["targetUrl"] != nil && patch["targetUrl"] != current.TargetURL
+	if err := validateConfig(&next, targetPatched); err != nil {
+		return current, nil, err
+	}
+
+	after := configMap(next)
+	changes := map[string]ConfigChange{}
+	for k, v := range after {
+		from, _ := json.Marshal(before[k])
+		to, _ := json.Marshal(v)
+		if !bytes.Equal(from, to) {
+			changes[k] = ConfigChange{From: before[k], To: v}
+		}
+	}
+	return next, changes, nil
+}
+
+// applyConfigChanges stores the changed fields of next. Only those fields
+// are copied, so settings changed meanwhile through other endpoints are
+// kept.
+func applyConfigChanges(next Config, changes map[string]ConfigChange) int64 {
+	setting := ""
+	for k := range changes {
+		s, ok := softConfigFields[k]
+		if !ok || (setting != "" && s != setting) {
+			setting = ""
+			break
+		}
+		setting = s
+	}
+	values := configMap(next)
+	v := state.Update(setting, func(c *Config) {
+		m := configMap(*c)
+		for k := range changes {
+			m[k] = values[k]
+		}
+		jar := c.CookieJar
+		updated, _ := decodeConfig(m)
+		updated.CookieJar = jar
+		*c = updated
+	})
+
+	if change, ok := changes["targetUrl"]; ok {
+		probe.reset()
+		notify(EventTargetChanged, "target changed to "+next.TargetURL, map[string]interface{}{"from": change.From, "to": next.TargetURL})
+	}
+	if _, ok := changes["identity"]; ok {
+		saveIdentity(next.Identity)
+	}
+	if _, ok := changes["resolution"]; ok {
+		saveResolution(next.Resolution)
+	}
+	return v
+}
+
+// configView is the configuration as shown to clients, without the cookie
+// jar.
+func configView(c Config) map[string]interface{} {
+	m := configMap(c)
+	delete(m, "cookieJar")
+	return m
+}
+
+// apiConfigHandler returns the configuration (GET) or changes the fields
+// given in a JSON merge patch (PATCH). With ?dryRun=true the patch is only
+// validated and the changes it would make are reported.
+func apiConfigHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(configView(state.Snapshot()))
+		return
+	case http.MethodPatch:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	next, changes, err := patchConfig(state.Snapshot(), patch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	resp := map[string]interface{}{"dryRun": dryRun, "changes": changes}
+	if !dryRun && len(changes) > 0 {
+		resp["version"] = applyConfigChanges(next, changes)
+		fields := make([]string, 0, len(changes))
+		for k := range changes {
+			fields = append(fields, k)
+		}
+		slog.Info("configuration patched", "fields", fields)
+		recordAudit("config_patch", map[string]interface{}{"changes": changes})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
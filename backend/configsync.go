@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The sync agent keeps the settings file in step with a copy kept
+// elsewhere, so a fleet's settings can live in version control.
+// CONFIG_SYNC_URL is fetched every CONFIG_SYNC_INTERVAL seconds (default
+// 300): a raw file URL on a Git host, an S3 object or presigned URL, or any
+// HTTP server. CONFIG_SYNC_OVERLAY_URL is an optional per-instance file laid
+// over it; "{instance}" in either URL is replaced by the instance name. The
+// result is validated as a whole, applied, and written over the settings
+// file so it also holds after a restart without network.
+
+const (
+	defaultSyncInterval = 300 * time.Second
+	maxSyncedFileSize   = 1 << 20
+)
+
+var (
+	syncClient = &http.Client{Timeout: 30 * time.Second}
+
+	syncStatus struct {
+		LastAttempt int64  `json:"lastAttempt,omitempty"`
+		LastSuccess int64  `json:"lastSuccess,omitempty"`
+		LastChange  int64  `json:"lastChange,omitempty"`
+		Revision    string `json:"revision,omitempty"`
+		Error       string `json:"error,omitempty"`
+	}
+	syncMutex sync.Mutex
+)
+
+// syncURL returns the named URL setting with the instance name filled in.
+func syncURL(name string) string {
+	u := setting(name)
+	return strings.ReplaceAll(u, "{instance}", url.PathEscape(state.Snapshot().Identity.Name))
+}
+
+// fetchSettings downloads a settings file. A missing file is reported as
+// nil without an error when optional is set.
+func fetchSettings(u string, optional bool) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := setting("CONFIG_SYNC_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := syncClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if optional && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSyncedFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSyncedFileSize {
+		return nil, fmt.Errorf("%s: file too large", u)
+	}
+	return data, nil
+}
+
+// syncSettings fetches, validates and applies the remote settings once.
+func syncSettings() error {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+	syncStatus.LastAttempt = time.Now().UnixMilli()
+	err := syncSettingsOnce()
+	syncStatus.Error = ""
+	if err != nil {
+		syncStatus.Error = err.Error()
+		slog.Error("config sync failed", "err", err)
+		return err
+	}
+	syncStatus.LastSuccess = syncStatus.LastAttempt
+	return nil
+}
+
+// syncSettingsOnce does the work of syncSettings. Callers must hold
+// syncMutex.
+func syncSettingsOnce() error {
+	base, err := fetchSettings(syncURL("CONFIG_SYNC_URL"), false)
+	if err != nil {
+		return err
+	}
+	var overlay []byte
+	if u := syncURL("CONFIG_SYNC_OVERLAY_URL"); u != "" {
+		if overlay, err = fetchSettings(u, true); err != nil {
+			return err
+		}
+	}
+	sum := sha256.Sum256(append(append([]byte{}, base...), overlay...))
+	revision := hex.EncodeToString(sum[:6])
+	if revision == syncStatus.Revision {
+		return nil
+	}
+
+	file, err := parseSettings(bytes.NewReader(base))
+	if err != nil {
+		return fmt.Errorf("base: %w", err)
+	}
+	over, err := parseSettings(bytes.NewReader(overlay))
+	if err != nil {
+		return fmt.Errorf("overlay: %w", err)
+	}
+	maps.Copy(file, over)
+	if file["CONFIG_SYNC_URL"] != "" || file["CONFIG_SYNC_OVERLAY_URL"] != "" {
+		return errors.New("synced settings must not change the sync source")
+	}
+	// Secrets set on this display stay unless the synced file sets them.
+	settingsMutex.Lock()
+	for name := range secretSettings {
+		if _, ok := file[name]; !ok && fileSettings[name] != "" {
+			file[name] = fileSettings[name]
+		}
+	}
+	settingsMutex.Unlock()
+
+	changes, err := applyFileSettings(file)
+	if err != nil {
+		return err
+	}
+	if err := writeSettingsFile(file, "Written by the config sync agent from CONFIG_SYNC_URL; local edits are overwritten."); err != nil {
+		slog.Warn("synced settings applied but not saved", "err", err)
+	}
+	syncStatus.Revision = revision
+	syncStatus.LastChange = time.Now().UnixMilli()
+	slog.Info("settings synced", "revision", revision, "changed", len(changes))
+	recordAudit("config_sync", map[string]interface{}{"revision": revision, "changes": changes})
+	return nil
+}
+
+// writeSettingsFile replaces the settings file in one rename, so the file
+// watcher never sees half of it. header becomes a comment at the top.
+func writeSettingsFile(file map[string]string, header string) error {
+	settingsMutex.Lock()
+	path := settingsPath
+	settingsMutex.Unlock()
+
+	var buf bytes.Buffer
+	buf.WriteString("# " + header + "\n")
+	for _, name := range slices.Sorted(maps.Keys(file)) {
+		buf.WriteString(name + ": " + strconv.Quote(file[name]) + "\n")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".settings-*.yml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// initConfigSync starts the sync agent when CONFIG_SYNC_URL is set.
+func initConfigSync() {
+	if setting("CONFIG_SYNC_URL") == "" {
+		return
+	}
+	interval := defaultSyncInterval
+	if secs, err := strconv.Atoi(setting("CONFIG_SYNC_INTERVAL")); err == nil && secs > 0 {
+		interval = time.Duration(secs) * time.Second
+	}
+	go func() {
+		for {
+			syncSettings()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// configSyncReport is the sync state shown in /api/status, or nil when
+// the agent is off.
+func configSyncReport() map[string]interface{} {
+	if setting("CONFIG_SYNC_URL") == "" {
+		return nil
+	}
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+	data, _ := json.Marshal(syncStatus)
+	report := map[string]interface{}{}
+	json.Unmarshal(data, &report)
+	return report
+}
+
+// apiConfigSyncHandler reports the sync state (GET) or syncs now (POST).
+func apiConfigSyncHandler(w http.ResponseWriter, r *http.Request) {
+	if setting("CONFIG_SYNC_URL") == "" {
+		http.Error(w, "Config sync not configured", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := syncSettings(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configSyncReport())
+}
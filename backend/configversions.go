@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConfigVersion is a copy of the configuration as it was after a change,
+// kept so a bad change can be rolled back. The last CONFIG_VERSIONS
+// (default 20) are saved in DATA_DIR/config-versions.json.
+type ConfigVersion struct {
+	Version int64                  `json:"version"`
+	Time    int64                  `json:"time"`
+	Setting string                 `json:"setting,omitempty"`
+	Changed []string               `json:"changed"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+}
+
+var (
+	configVersions      []ConfigVersion
+	configVersionsMutex sync.Mutex
+	configVersionsPath  string
+	maxConfigVersions   = 20
+)
+
+func initConfigVersions() error {
+	configVersionsMutex.Lock()
+	configVersionsPath = filepath.Join(dataDir, "config-versions.json")
+	configVersions = nil
+	if n, err := strconv.Atoi(setting("CONFIG_VERSIONS")); err == nil && n > 0 {
+		maxConfigVersions = n
+	}
+	data, err := os.ReadFile(configVersionsPath)
+	if err == nil {
+		err = json.Unmarshal(data, &configVersions)
+	}
+	configVersionsMutex.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// initConfigVersionEvents records the startup configuration and then a new
+// version after every change.
+func initConfigVersionEvents() {
+	config := state.Snapshot()
+	recordConfigVersion("startup", config.LastModified, configView(config))
+	ch := subscribeEventsReliable(EventConfigChanged)
+	go func() {
+		for e := range ch {
+			setting, _ := e.Data["setting"].(string)
+			version, _ := e.Data["version"].(int64)
+			view, _ := e.Data["config"].(map[string]interface{})
+			if view != nil {
+				recordConfigVersion(setting, version, view)
+			}
+		}
+	}()
+}
+
+// recordConfigVersion saves view, the configuration as it was right after
+// version, unless nothing but the version changed since the last one.
+func recordConfigVersion(setting string, version int64, view map[string]interface{}) {
+	view = maps.Clone(view)
+	for _, k := range []string{"lastModified", "reloadVersion", "hardReloadVersion"} {
+		delete(view, k)
+	}
+
+	configVersionsMutex.Lock()
+	defer configVersionsMutex.Unlock()
+	changed := []string{}
+	if n := len(configVersions); n > 0 {
+		prev := configVersions[n-1]
+		if prev.Version >= version {
+			return
+		}
+		changed = changedFields(prev.Config, view)
+		if len(changed) == 0 {
+			return
+		}
+	}
+	configVersions = append(configVersions, ConfigVersion{
+		Version: version,
+		Time:    time.Now().UnixMilli(),
+		Setting: setting,
+		Changed: changed,
+		Config:  view,
+	})
+	if n := len(configVersions) - maxConfigVersions; n > 0 {
+		configVersions = configVersions[n:]
+	}
+	data, _ := json.MarshalIndent(configVersions, "", "  ")
+	if err := os.WriteFile(configVersionsPath, data, 0644); err != nil {
+		slog.Error("failed to save config versions", "err", err)
+	}
+}
+
+// changedFields lists the top-level fields that differ between a and b.
+func changedFields(a, b map[string]interface{}) []string {
+	fields := []string{}
+	for k, v := range b {
+		x, _ := json.Marshal(a[k])
+		y, _ := json.Marshal(v)
+		if string(x) != string(y) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func findConfigVersion(version int64) (ConfigVersion, bool) {
+	configVersionsMutex.Lock()
+	defer configVersionsMutex.Unlock()
+	for _, v := range configVersions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return ConfigVersion{}, false
+}
+
+// apiConfigVersionsHandler lists the saved versions newest first, or with
+// ?version= returns that version and how it differs from the current
+// configuration.
+func apiConfigVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		version, _ := strconv.ParseInt(raw, 10, 64)
+		v, ok := findConfigVersion(version)
+		if !ok {
+			http.Error(w, "Unknown version", http.StatusNotFound)
+			return
+		}
+		_, diff, err := patchConfig(state.Snapshot(), v.Config)
+		resp := map[string]interface{}{"version": v, "diff": diff}
+		if err != nil {
+			resp["error"] = err.Error()
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	configVersionsMutex.Lock()
+	list := make([]ConfigVersion, 0, len(configVersions))
+	for i := len(configVersions) - 1; i >= 0; i-- {
+		v := configVersions[i]
+		v.Config = nil
+		list = append(list, v)
+	}
+	configVersionsMutex.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"versions": list})
+}
+
+// apiConfigRollbackHandler restores ?version=. The rollback is a change
+// like any other, so it is recorded as a new version and can itself be
+// undone. ?dryRun=true only reports what would change.
+func apiConfigRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	version, _ := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+	v, ok := findConfigVersion(version)
+	if !ok {
+		http.Error(w, "Unknown version", http.StatusNotFound)
+		return
+	}
+	next, changes, err := patchConfig(state.Snapshot(), v.Config)
+	if err != nil {
+		http.Error(w, "Version cannot be restored: "+err.Error(), http.StatusConflict)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	resp := map[string]interface{}{"dryRun": dryRun, "changes": changes}
+	if !dryRun && len(changes) > 0 {
+		resp["version"] = applyConfigChanges(next, changes)
+		slog.Info("configuration rolled back", "to", version)
+		recordAudit("config_rollback", map[string]interface{}{"version": version, "changes": changes})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
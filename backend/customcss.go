@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// maxCustomCSSBytes bounds the stylesheet accepted from /api/config/css.
+const maxCustomCSSBytes = 256 * 1024
+
+// splitSelectors parses HIDE_SELECTORS. Selectors are separated by "|" so
+// that grouped selectors containing commas can be given as one entry.
+func splitSelectors(raw string) []string {
+	selectors := []string{}
+	for _, s := range strings.Split(raw, "|") {
+		if s = strings.TrimSpace(s); s != "" {
+			selectors = append(selectors, s)
+		}
+	}
+	return selectors
+}
+
+func validateSelectors(selectors []string) error {
+	for _, s := range selectors {
+		if strings.TrimSpace(s) == "" || strings.ContainsAny(s, "{};<") {
+			return errors.New("invalid selector: " + s)
+		}
+	}
+	return nil
+}
+
+// SetCustomCSS replaces the hide list and custom stylesheet; displays
+// swap it in without reloading.
+func SetCustomCSS(hideSelectors []string, css string) {
+	state.Update("css", func(c *Config) {
+		c.HideSelectors = hideSelectors
+		c.CustomCSS = css
+	})
+}
+
+// customCSSText renders the hide list and custom CSS as one stylesheet.
+// Each selector gets its own rule so one the browser doesn't understand
+// can't void the others, and "<" is escaped so the CSS can't close the style
+// element.
+func customCSSText(config Config) string {
+	var b strings.Builder
+	for _, s := range config.HideSelectors {
+		b.WriteString(s + "{display:none!important}\n")
+	}
+	b.WriteString(strings.ReplaceAll(config.CustomCSS, "<", `\3c `))
+	return b.String()
+}
+
+// customStyle wraps customCSSText in the style element added to every page.
+func customStyle(config Config) string {
+	if len(config.HideSelectors) == 0 && config.CustomCSS == "" {
+		return ""
+	}
+	return `<style id="ctrl-custom-css">` + customCSSText(config) + "</style>"
+}
+
+// apiConfigCSSHandler returns (GET) or replaces (POST) the hide list and
+// custom CSS.
+func apiConfigCSSHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			HideSelectors []string `json:"hideSelectors"`
+			CustomCSS     string   `json:"customCss"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxCustomCSSBytes)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.HideSelectors == nil {
+			req.HideSelectors = []string{}
+		}
+		if err := validateSelectors(req.HideSelectors); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetCustomCSS(req.HideSelectors, req.CustomCSS)
+		slog.Info("custom CSS changed", "hideSelectors", len(req.HideSelectors), "cssBytes", len(req.CustomCSS))
+		recordAudit("custom_css", map[string]interface{}{"hideSelectors": req.HideSelectors, "cssBytes": len(req.CustomCSS)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	config := state.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hideSelectors": config.HideSelectors,
+		"customCss":     config.CustomCSS,
+	})
+}
+
+// initCustomCSS reads HIDE_SELECTORS and CUSTOM_CSS (inline CSS, or a path
+// to a stylesheet when prefixed with "@").
+func initCustomCSS() error {
+	selectors := splitSelectors(setting("HIDE_SELECTORS"))
+	if err := validateSelectors(selectors); err != nil {
+		return err
+	}
+	css := setting("CUSTOM_CSS")
+	if path, ok := strings.CutPrefix(css, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		css = string(data)
+	}
+	state.Modify(func(c *Config) {
+		c.HideSelectors = selectors
+		c.CustomCSS = css
+	})
+	return nil
+}
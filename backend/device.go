@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Recovery commands come only from RECOVERY_COMMANDS, formatted as
+// "name=command args;name2=command args". Requests pick a command by name;
+// nothing from the request ever reaches the command line.
+const recoveryCommandTimeout = 2 * time.Minute
+
+func recoveryCommands() map[string][]string {
+	commands := map[string][]string{}
+	for _, entry := range strings.Split(setting("RECOVERY_COMMANDS"), ";") {
+		name, cmd, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(cmd)
+		if !ok || name == "" || len(fields) == 0 {
+			continue
+		}
+		commands[name] = fields
+	}
+	return commands
+}
+
+func runRecoveryCommand(name string, argv []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryCommandTimeout)
+	defer cancel()
+
+	slog.Warn("running recovery command", "name", name, "command", strings.Join(argv, " "))
+	notify(EventRecoveryAction, "running recovery action "+name, map[string]interface{}{"action": name})
+	out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		slog.Error("recovery command failed", "name", name, "err", err, "output", string(out))
+		return
+	}
+	slog.Info("recovery command finished", "name", name, "output", string(out))
+}
+
+func apiDeviceActionsHandler(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range recoveryCommands() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"actions": names})
+}
+
+func apiDeviceRebootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("action")
+	if name == "" {
+		name = "reboot"
+	}
+	argv, ok := recoveryCommands()[name]
+	if !ok {
+		http.Error(w, "Unknown recovery action", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"action": name, "status": "scheduled"})
+
+	// Let the response reach the caller before a reboot takes us down.
+	go func() {
+		time.Sleep(time.Second)
+		runRecoveryCommand(name, argv)
+	}()
+}
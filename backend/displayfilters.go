@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DisplayFilters adjust the picture for screens without their own
+// controls. Brightness, Contrast and Gamma are factors where 1 (or 0,
+// unset) leaves the picture alone; a gamma above 1 brightens midtones.
+// NightTemperature, in kelvin, warms the picture between NightFrom and
+// NightUntil, or between sunset and sunrise when GEOLOCATION is set.
+type DisplayFilters struct {
+	Brightness       float64 `json:"brightness,omitempty"`
+	Contrast         float64 `json:"contrast,omitempty"`
+	Gamma            float64 `json:"gamma,omitempty"`
+	Grayscale        bool    `json:"grayscale,omitempty"`
+	NightTemperature int     `json:"nightTemperature,omitempty"`
+	NightFrom        string  `json:"nightFrom,omitempty"`
+	NightUntil       string  `json:"nightUntil,omitempty"`
+}
+
+func (f DisplayFilters) validate() error {
+	for _, v := range []float64{f.Brightness, f.Contrast, f.Gamma} {
+		if v < 0 || v > 10 {
+			return errors.New("brightness, contrast and gamma must be between 0 and 10")
+		}
+	}
+	if f.NightTemperature != 0 && (f.NightTemperature < 1000 || f.NightTemperature > 6500) {
+		return errors.New("nightTemperature must be between 1000 and 6500 kelvin")
+	}
+	for _, s := range []string{f.NightFrom, f.NightUntil} {
+		if s != "" {
+			if _, err := parseClock(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func factor(v float64) float64 {
+	if v == 0 {
+		return 1
+	}
+	return v
+}
+
+// nightActive reports whether the night color temperature applies at now.
+func nightActive(config Config, now time.Time) bool {
+	f := config.DisplayFilters
+	return f.NightTemperature != 0 && isNight(config.Emulation, now, f.NightFrom, f.NightUntil)
+}
+
+// kelvinRGB approximates the white point of a black body at k kelvin as
+// channel factors between 0 and 1.
+func kelvinRGB(k int) (r, g, b float64) {
+	t := float64(k) / 100
+	clamp := func(v float64) float64 { return math.Max(0, math.Min(255, v)) / 255 }
+	r, g, b = 1, 1, 1
+	if t > 66 {
+		r = clamp(329.698727446 * math.Pow(t-60, -0.1332047592))
+		g = clamp(288.1221695283 * math.Pow(t-60, -0.0755148492))
+	} else {
+		g = clamp(99.4708025861*math.Log(t) - 161.1195681661)
+		switch {
+		case t <= 19:
+			b = 0
+		case t < 66:
+			b = clamp(138.5177312231*math.Log(t-10) - 305.0447927307)
+		}
+	}
+	return r, g, b
+}
+
+// displayFilterMarkup renders the filters in force at now as a filter on
+// the root element. Gamma and color temperature need an SVG filter, which
+// is added to the document by a script. It also carries the theme's
+// inversion, since both use the root element's filter property.
+func displayFilterMarkup(config Config, now time.Time) string {
+	f := config.DisplayFilters
+	var parts []string
+	if colorScheme(config, now) == "dark" && config.Theme.Invert {
+		parts = append(parts, "invert(1) hue-rotate(180deg)")
+	}
+	if b := factor(f.Brightness); b != 1 {
+		parts = append(parts, fmt.Sprintf("brightness(%g)", b))
+	}
+	if c := factor(f.Contrast); c != 1 {
+		parts = append(parts, fmt.Sprintf("contrast(%g)", c))
+	}
+	if f.Grayscale {
+		parts = append(parts, "grayscale(1)")
+	}
+	gamma, night := factor(f.Gamma), nightActive(config, now)
+	svg := ""
+	if gamma != 1 || night {
+		r, g, b := 1.0, 1.0, 1.0
+		if night {
+			r, g, b = kelvinRGB(f.NightTemperature)
+		}
+		exp := 1 / gamma
+		svg = fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" style="position:absolute;width:0;height:0"><filter id="ctrl-display-filter" color-interpolation-filters="sRGB">`+
+			`<feComponentTransfer><feFuncR type="gamma" exponent="%.3f"/><feFuncG type="gamma" exponent="%.3f"/><feFuncB type="gamma" exponent="%.3f"/></feComponentTransfer>`+
+			`<feColorMatrix type="matrix" values="%.3f 0 0 0 0 0 %.3f 0 0 0 0 0 %.3f 0 0 0 0 0 1 0"/></filter></svg>`, exp, exp, exp, r, g, b)
+		parts = append(parts, "url(#ctrl-display-filter)")
+	}
+	if len(parts) == 0 || len(parts) == 1 && strings.HasPrefix(parts[0], "invert") {
+		return ""
+	}
+	out := "<style>html{filter:" + strings.Join(parts, " ") + "!important}</style>"
+	if svg != "" {
+		data, _ := json.Marshal(svg)
+		out += fmt.Sprintf(`<script>document.documentElement.insertAdjacentHTML('beforeend', %s);</script>`, data)
+	}
+	return out
+}
+
+func initDisplayFilters() error {
+	f := DisplayFilters{
+		Grayscale:  setting("DISPLAY_GRAYSCALE") == "true",
+		NightFrom:  setting("NIGHT_FROM"),
+		NightUntil: setting("NIGHT_UNTIL"),
+	}
+	for env, dst := range map[string]*float64{"DISPLAY_BRIGHTNESS": &f.Brightness, "DISPLAY_CONTRAST": &f.Contrast, "DISPLAY_GAMMA": &f.Gamma} {
+		if raw := setting(env); raw != "" {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", env, raw)
+			}
+			*dst = v
+		}
+	}
+	if raw := setting("NIGHT_TEMPERATURE"); raw != "" {
+		k, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid NIGHT_TEMPERATURE %q", raw)
+		}
+		f.NightTemperature = k
+	}
+	if err := f.validate(); err != nil {
+		return err
+	}
+	state.Modify(func(c *Config) { c.DisplayFilters = f })
+	return nil
+}
+
+// initNightSchedule reloads displays when the night color temperature
+// switches on or off.
+func initNightSchedule() {
+	reloadOnFlip("night color temperature", func(config Config, now time.Time) string {
+		return strconv.FormatBool(nightActive(config, now))
+	})
+}
+
+// apiConfigDisplayFiltersHandler returns (GET) or replaces (POST) the
+// display filters; an empty object removes them.
+func apiConfigDisplayFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var f DisplayFilters
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := f.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		state.Update("", func(c *Config) { c.DisplayFilters = f })
+		slog.Info("display filters changed", "brightness", f.Brightness, "contrast", f.Contrast, "gamma", f.Gamma, "nightTemperature", f.NightTemperature)
+		recordAudit("display_filters", map[string]interface{}{"brightness": f.Brightness, "contrast": f.Contrast, "gamma": f.Gamma, "grayscale": f.Grayscale, "nightTemperature": f.NightTemperature})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	config := state.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"filters": config.DisplayFilters,
+		"night":   nightActive(config, time.Now()),
+	})
+}
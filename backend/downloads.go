@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Downloads served by the target (responses with Content-Disposition:
+// attachment) are copied into DATA_DIR/downloads while they stream to the
+// display, so files triggered on a kiosk can be retrieved later. Set
+// CAPTURE_DOWNLOADS=false to turn this off. DOWNLOADS_MAX_SIZE caps the
+// folder (default 1GB); the oldest captures are deleted to stay below it.
+const (
+	maxCapturedDownload     = 100 << 20
+	defaultDownloadsMaxSize = 1 << 30
+)
+
+type DownloadInfo struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"`
+}
+
+func downloadsDir() string {
+	return filepath.Join(dataDir, "downloads")
+}
+
+func captureDownloadsEnabled() bool {
+	return setting("CAPTURE_DOWNLOADS") != "false"
+}
+
+// downloadName picks a safe file name from the Content-Disposition header,
+// falling back to the last path segment.
+func downloadName(resp *http.Response) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" && resp.Request != nil {
+		name = path.Base(resp.Request.URL.Path)
+	}
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "" || name == "." || name == "/" {
+		name = "download"
+	}
+	return time.Now().Format("20060102-150405") + "-" + name
+}
+
+// captureReader writes everything read through it to a file, discarding the
+// file if the download is larger than the cap, fails midway or is closed
+// before the end, as when the display goes away.
+type captureReader struct {
+	src     io.ReadCloser
+	file    *os.File
+	written int64
+	failed  bool
+	eof     bool
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.src.Read(p)
+	if n > 0 && !c.failed {
+		c.written += int64(n)
+		if c.written > maxCapturedDownload {
+			c.failed = true
+		} else if _, werr := c.file.Write(p[:n]); werr != nil {
+			c.failed = true
+		}
+	}
+	if err == io.EOF {
+		c.eof = true
+	} else if err != nil {
+		c.failed = true
+	}
+	return n, err
+}
+
+func (c *captureReader) Close() error {
+	err := c.src.Close()
+	c.file.Close()
+	if c.failed || !c.eof {
+		os.Remove(c.file.Name())
+	} else {
+		slog.Info("download captured", "file", filepath.Base(c.file.Name()), "bytes", c.written)
+		recordAudit("download", map[string]interface{}{"name": filepath.Base(c.file.Name()), "size": c.written})
+		pruneDownloads(filepath.Base(c.file.Name()))
+	}
+	return err
+}
+
+// createDownloadFile creates name in the downloads directory, adding -2,
+// -3, ... before the extension when it is taken, so two displays fetching
+// the same file at once don't write to the same one.
+func createDownloadFile(name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = stem + "-" + strconv.Itoa(i) + ext
+		}
+		f, err := os.OpenFile(filepath.Join(downloadsDir(), candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) || i >= 1000 {
+			return f, err
+		}
+	}
+}
+
+// pruneDownloads deletes the oldest captures, except keep, until the
+// folder fits DOWNLOADS_MAX_SIZE.
+func pruneDownloads(keep string) {
+	limit := int64(defaultDownloadsMaxSize)
+	if raw := setting("DOWNLOADS_MAX_SIZE"); raw != "" {
+		if n, err := parseByteSize(raw); err == nil {
+			limit = n
+		}
+	}
+	list := listDownloads()
+	var total int64
+	for _, d := range list {
+		total += d.Size
+	}
+	for i := len(list) - 1; i >= 0 && total > limit; i-- {
+		if list[i].Name == keep {
+			continue
+		}
+		if err := os.Remove(filepath.Join(downloadsDir(), list[i].Name)); err == nil {
+			total -= list[i].Size
+			slog.Info("old download deleted to stay under DOWNLOADS_MAX_SIZE", "file", list[i].Name)
+		}
+	}
+}
+
+// captureDownload wraps resp.Body when the response is a file download.
+func captureDownload(resp *http.Response) {
+	if !captureDownloadsEnabled() || resp.StatusCode != http.StatusOK {
+		return
+	}
+	if !strings.HasPrefix(strings.ToLower(resp.Header.Get("Content-Disposition")), "attachment") {
+		return
+	}
+	if err := os.MkdirAll(downloadsDir(), 0755); err != nil {
+		slog.Error("failed to create downloads directory", "err", err)
+		return
+	}
+	f, err := createDownloadFile(downloadName(resp))
+	if err != nil {
+		slog.Error("failed to capture download", "err", err)
+		return
+	}
+	resp.Body = &captureReader{src: resp.Body, file: f}
+}
+
+func listDownloads() []DownloadInfo {
+	entries, _ := os.ReadDir(downloadsDir())
+	list := []DownloadInfo{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() {
+			continue
+		}
+		list = append(list, DownloadInfo{Name: e.Name(), Size: info.Size(), Modified: info.ModTime().UnixMilli()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Modified > list[j].Modified })
+	return list
+}
+
+// downloadPath resolves a name from the API to a file in the downloads
+// directory, rejecting anything that isn't a plain file name.
+func downloadPath(name string) (string, bool) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	return filepath.Join(downloadsDir(), name), true
+}
+
+// apiDownloadsHandler lists captured downloads (GET), returns one with
+// ?name= (GET) or deletes one (DELETE ?name=).
+func apiDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"downloads": listDownloads()})
+	case r.Method == http.MethodGet:
+		p, ok := downloadPath(name)
+		if !ok {
+			http.Error(w, "Invalid name", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+		http.ServeFile(w, r, p)
+	case r.Method == http.MethodDelete:
+		p, ok := downloadPath(name)
+		if !ok {
+			http.Error(w, "Invalid name", http.StatusBadRequest)
+			return
+		}
+		if err := os.Remove(p); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyRewritesTargetLinks(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	resp, body := h.navigate("/")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	assertContains(t, body, `href="/about?x=1"`)
+	assertContains(t, body, `href="/style.css"`)
+	assertContains(t, body, `href="https://external.example/page"`)
+	assertContains(t, body, `srcset="/logo.png 1x, /logo@2x.png 2x"`)
+	assertNotContains(t, body, h.target.URL)
+	assertNotContains(t, body, "integrity=")
+	assertNotContains(t, body, "crossorigin")
+	assertContains(t, body, "let version = ")
+}
+
+func TestProxyRewritesStylesheetURLs(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	_, body := h.get("/style.css")
+	assertContains(t, body, `url('/bg.png')`)
+}
+
+func TestProxyMasksSameHostRedirects(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	resp, _ := h.get("/redirect")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/landing?from=redirect" {
+		t.Errorf("Location = %q", loc)
+	}
+}
+
+func TestCookiesPersistAcrossRestart(t *testing.T) {
+	h := newHarness(t, "", nil)
+	h.get("/cookie")
+
+	cookieFile := filepath.Join(h.dataDir, "cookies.json")
+	eventually(t, "cookie jar to be saved", func() bool {
+		data, err := os.ReadFile(cookieFile)
+		return err == nil && len(data) > 2
+	})
+
+	restarted := newHarness(t, h.dataDir, nil)
+	restarted.get("/")
+	req := restarted.lastTargetRequest("/")
+	if req == nil {
+		t.Fatal("target never saw the request")
+	}
+	if c, err := req.Cookie("session"); err != nil || c.Value != "abc123" {
+		t.Errorf("persisted cookie not sent upstream: %v %v", c, err)
+	}
+}
+
+func TestURLChangeTriggersReload(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	var before struct{ LastModified int64 }
+	_, body := h.get("/api/version")
+	h.decode(body, &before)
+
+	resp, body := h.postJSON("/api/config/url", map[string]interface{}{"url": h.target.URL + "/about", "confirm": true})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+
+	var after struct{ Changed bool }
+	_, body = h.get("/api/version?since=" + strconv.FormatInt(before.LastModified, 10))
+	h.decode(body, &after)
+	if !after.Changed {
+		t.Error("expected displays to be told to reload")
+	}
+	if got := state.Snapshot().TargetURL; got != h.target.URL+"/about" {
+		t.Errorf("TargetURL = %q", got)
+	}
+}
+
+func TestConfigURLRejectsUnsafeInput(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	for _, u := range []string{"file:///etc/passwd", "chrome://settings", "http://169.254.169.254/latest/"} {
+		resp, _ := h.postJSON("/api/config/url", map[string]interface{}{"url": u, "confirm": true})
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("%s was accepted", u)
+		}
+	}
+}
+
+func TestConfigPatchValidatesAndKeepsOtherFields(t *testing.T) {
+	h := newHarness(t, "", nil)
+	before := state.Snapshot()
+
+	resp, body := h.do(http.MethodPatch, "/api/config?dryRun=true", strings.NewReader(`{"scaleFactor":2}`), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	assertContains(t, body, `"scaleFactor":{"from":1,"to":2}`)
+	if got := state.Snapshot().ScaleFactor; got != before.ScaleFactor {
+		t.Errorf("dry run changed ScaleFactor to %v", got)
+	}
+
+	resp, _ = h.do(http.MethodPatch, "/api/config", strings.NewReader(`{"scaleFactor":2,"scrollSequence":"nonsense"}`), nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid patch status = %d", resp.StatusCode)
+	}
+
+	h.do(http.MethodPatch, "/api/config", strings.NewReader(`{"scaleFactor":2}`), nil)
+	after := state.Snapshot()
+	if after.ScaleFactor != 2 || after.TargetURL != before.TargetURL || after.ScrollSpeed != before.ScrollSpeed {
+		t.Errorf("after patch: scale %v, target %q, speed %d", after.ScaleFactor, after.TargetURL, after.ScrollSpeed)
+	}
+}
+
+func TestServerErrorShowsOfflinePage(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	resp, body := h.navigate("/broken")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	assertContains(t, body, "Retrying automatically")
+
+	// Load balancers answer in plain text; navigations still get the page.
+	resp, body = h.navigate("/bad-gateway")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("plain-text 502: status = %d", resp.StatusCode)
+	}
+	assertContains(t, body, "Retrying automatically")
+	if resp, _ := h.get("/bad-gateway"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("plain-text 502 to a script: status = %d", resp.StatusCode)
+	}
+}
+
+func TestOverlaysPersistAcrossRestart(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	resp, body := h.postJSON("/api/overlay", map[string]interface{}{"id": "news", "type": "ticker", "text": "Hello", "visible": true})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+
+	newHarness(t, h.dataDir, nil)
+	overlays := GetOverlays()
+	if len(overlays) != 1 || overlays[0].ID != "news" {
+		t.Errorf("overlays after restart = %+v", overlays)
+	}
+}
+
+func TestDisplayInputIsRecorded(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	resp, _ := h.postJSON("/api/heatmap/click", map[string]interface{}{"url": "/", "x": 0.5, "y": 0.5})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var heatmap struct{ Total int }
+	_, body := h.get("/api/heatmap?url=/")
+	h.decode(body, &heatmap)
+	if heatmap.Total == 0 {
+		t.Error("click was not recorded")
+	}
+}
+
+func TestUnknownAPIRouteIsNotProxied(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	resp, _ := h.get("/api/does-not-exist")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+	if h.lastTargetRequest("/api/does-not-exist") != nil {
+		t.Error("unknown API route reached the target")
+	}
+}
+
+func TestDownloadsAreCaptured(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	_, body := h.get("/report.csv")
+	if body != "a,b\n1,2\n" {
+		t.Fatalf("download body = %q", body)
+	}
+
+	var list struct{ Downloads []DownloadInfo }
+	eventually(t, "download to be listed", func() bool {
+		_, body := h.get("/api/downloads")
+		h.decode(body, &list)
+		return len(list.Downloads) == 1
+	})
+	name := list.Downloads[0].Name
+	if !strings.HasSuffix(name, "-report.csv") {
+		t.Errorf("name = %q", name)
+	}
+
+	_, body = h.get("/api/downloads?name=" + url.QueryEscape(name))
+	if body != "a,b\n1,2\n" {
+		t.Errorf("stored body = %q", body)
+	}
+	if resp, _ := h.do(http.MethodDelete, "/api/downloads?name="+url.QueryEscape(name), nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete status = %d", resp.StatusCode)
+	}
+	if resp, _ := h.get("/api/downloads?name=../cookies.json"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("path traversal status = %d", resp.StatusCode)
+	}
+}
+
+func TestLockLevels(t *testing.T) {
+	h := newHarness(t, "", nil)
+
+	h.postJSON("/api/lock", map[string]interface{}{"level": "input"})
+	if resp, _ := h.postJSON("/api/input", map[string]interface{}{"type": "key", "key": "a"}); resp.StatusCode != http.StatusLocked {
+		t.Errorf("input at lock input: status = %d", resp.StatusCode)
+	}
+	if resp, _ := h.postJSON("/api/config/autoscroll", map[string]interface{}{"enabled": true}); resp.StatusCode != http.StatusOK {
+		t.Errorf("config at lock input: status = %d", resp.StatusCode)
+	}
+
+	h.postJSON("/api/lock", map[string]interface{}{"level": "full"})
+	if resp, _ := h.postJSON("/api/config/autoscroll", map[string]interface{}{"enabled": false}); resp.StatusCode != http.StatusLocked {
+		t.Errorf("config at lock full: status = %d", resp.StatusCode)
+	}
+	if resp, _ := h.get("/api/status"); resp.StatusCode != http.StatusOK {
+		t.Errorf("status at lock full: status = %d", resp.StatusCode)
+	}
+	// Displays only read the overlay, so changing it is still locked.
+	if resp, _ := h.postJSON("/api/overlay", map[string]interface{}{"text": "x"}); resp.StatusCode != http.StatusLocked {
+		t.Errorf("overlay POST at lock full: status = %d", resp.StatusCode)
+	}
+	if resp, _ := h.postJSON("/api/lock", map[string]interface{}{"level": "none"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("unlock: status = %d", resp.StatusCode)
+	}
+}
+
+func TestUnlockPIN(t *testing.T) {
+	pin := hashPIN("2468", []byte("salt"))
+	h := newHarness(t, "", map[string]string{"UNLOCK_PIN": pin, "UNLOCK_PIN_DURATION": "0"})
+	pinMutex.Lock()
+	pinFailures, pinLockedUntil = 0, time.Time{}
+	pinMutex.Unlock()
+
+	h.postJSON("/api/lock", map[string]interface{}{"level": "full"})
+	if resp, _ := h.postJSON("/api/unlock", map[string]interface{}{"pin": "1357"}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong PIN: status = %d", resp.StatusCode)
+	}
+	if lock := state.Snapshot().Lock; lock != "full" {
+		t.Errorf("lock after wrong PIN = %q", lock)
+	}
+	if resp, _ := h.postJSON("/api/unlock", map[string]interface{}{"pin": "2468"}); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("correct PIN: status = %d", resp.StatusCode)
+	}
+	if lock := state.Snapshot().Lock; lock != "none" {
+		t.Errorf("lock after correct PIN = %q", lock)
+	}
+
+	for i := 0; i < maxPINFailures; i++ {
+		h.postJSON("/api/unlock", map[string]interface{}{"pin": "0000"})
+	}
+	if resp, _ := h.postJSON("/api/unlock", map[string]interface{}{"pin": "2468"}); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("correct PIN after lockout: status = %d", resp.StatusCode)
+	}
+	pinMutex.Lock()
+	pinFailures, pinLockedUntil = 0, time.Time{}
+	pinMutex.Unlock()
+}
+
+func TestTemporaryUnlockRelocks(t *testing.T) {
+	h := newHarness(t, "", nil)
+	h.postJSON("/api/lock", map[string]interface{}{"level": "input"})
+
+	unlockFor(100*time.Millisecond, false)
+	if lock := state.Snapshot().Lock; lock != "none" {
+		t.Fatalf("lock while unlocked = %q", lock)
+	}
+	var report map[string]interface{}
+	_, body := h.get("/api/lock")
+	h.decode(body, &report)
+	if report["relockLevel"] != "input" {
+		t.Errorf("relockLevel = %v", report["relockLevel"])
+	}
+	// Unlocking again extends the time but keeps the original level.
+	unlockFor(150*time.Millisecond, false)
+	eventually(t, "relock", func() bool { return state.Snapshot().Lock == "input" })
+
+	// Setting a level by hand cancels a pending relock.
+	unlockFor(50*time.Millisecond, false)
+	h.postJSON("/api/lock", map[string]interface{}{"level": "none"})
+	time.Sleep(150 * time.Millisecond)
+	if lock := state.Snapshot().Lock; lock != "none" {
+		t.Errorf("lock after cancelled relock = %q", lock)
+	}
+}
+
+func TestConfigVersionsKeepEveryChange(t *testing.T) {
+	newHarness(t, "", nil)
+	initConfigVersionEvents()
+
+	// Back-to-back changes each get a version holding their own state.
+	state.Update("scale", func(c *Config) { c.ScaleFactor = 2 })
+	state.Update("scrollspeed", func(c *Config) { c.ScrollSpeed = 77 })
+	var versions []ConfigVersion
+	eventually(t, "both versions", func() bool {
+		configVersionsMutex.Lock()
+		defer configVersionsMutex.Unlock()
+		versions = slices.Clone(configVersions)
+		return len(versions) == 3
+	})
+	first, second := versions[1], versions[2]
+	if first.Setting != "scale" || first.Config["scaleFactor"] != 2.0 || first.Config["scrollSpeed"] == 77.0 {
+		t.Errorf("first change: %q %v", first.Setting, first.Config)
+	}
+	if second.Setting != "scrollspeed" || second.Config["scrollSpeed"] != 77.0 {
+		t.Errorf("second change: %q %v", second.Setting, second.Config)
+	}
+}
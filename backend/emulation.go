@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+// Emulation makes pages believe they run somewhere else: a fixed
+// geolocation, a time zone for date formatting and a UI locale (also sent
+// upstream as Accept-Language so server-rendered pages follow it).
+type Emulation struct {
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Accuracy  float64  `json:"accuracy,omitempty"`
+	Timezone  string   `json:"timezone,omitempty"`
+	Locale    string   `json:"locale,omitempty"`
+}
+
+var localeRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+func (e Emulation) empty() bool {
+	return e.Latitude == nil && e.Timezone == "" && e.Locale == ""
+}
+
+func (e Emulation) validate() error {
+	if (e.Latitude == nil) != (e.Longitude == nil) {
+		return errors.New("latitude and longitude must be set together")
+	}
+	if e.Latitude != nil && (*e.Latitude < -90 || *e.Latitude > 90 || *e.Longitude < -180 || *e.Longitude > 180) {
+		return errors.New("coordinates out of range")
+	}
+	if e.Accuracy < 0 {
+		return errors.New("accuracy must not be negative")
+	}
+	if e.Timezone != "" {
+		if _, err := time.LoadLocation(e.Timezone); err != nil {
+			return errors.New("unknown timezone " + e.Timezone)
+		}
+	}
+	if e.Locale != "" && !localeRe.MatchString(e.Locale) {
+		return errors.New("invalid locale " + e.Locale)
+	}
+	return nil
+}
+
+// emulationFromEnv reads GEOLOCATION ("lat,lon[,accuracy]"), TIMEZONE and
+// LOCALE.
+func emulationFromEnv() (Emulation, error) {
+	e := Emulation{Timezone: setting("TIMEZONE"), Locale: setting("LOCALE")}
+	if geo := setting("GEOLOCATION"); geo != "" {
+		parts := strings.Split(geo, ",")
+		nums := make([]float64, len(parts))
+		for i, p := range parts {
+			n, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return e, fmt.Errorf("invalid GEOLOCATION %q", geo)
+			}
+			nums[i] = n
+		}
+		if len(nums) < 2 || len(nums) > 3 {
+			return e, fmt.Errorf("invalid GEOLOCATION %q", geo)
+		}
+		e.Latitude, e.Longitude = &nums[0], &nums[1]
+		if len(nums) == 3 {
+			e.Accuracy = nums[2]
+		}
+	}
+	return e, e.validate()
+}
+
+func initEmulation() error {
+	e, err := emulationFromEnv()
+	if err != nil {
+		return err
+	}
+	state.Modify(func(c *Config) { c.Emulation = e })
+	return nil
+}
+
+// SetEmulation applies new emulation settings; displays reload so the
+// overrides are in place before the page's own scripts run.
+func SetEmulation(e Emulation) {
+	state.Update("", func(c *Config) { c.Emulation = e })
+}
+
+// acceptLanguage builds an Accept-Language header preferring locale.
+func acceptLanguage(locale string) string {
+	lang, _, _ := strings.Cut(locale, "-")
+	if lang == locale {
+		return locale
+	}
+	return locale + "," + lang + ";q=0.9"
+}
+
+// emulationScript returns the overrides, which must run before any of the
+// page's scripts and are therefore inserted at the top of <head>. The time
+// zone applies to Intl and the toLocale* date methods; getHours() and
+// friends still use the system zone.
+func emulationScript(e Emulation) string {
+	if e.empty() {
+		return ""
+	}
+	data, _ := json.Marshal(e)
+	return fmt.Sprintf(emulationTemplate, data)
+}
+
+const emulationTemplate = `<script>
+(() => {
+    const emu = %s;
+    if (emu.latitude !== undefined && navigator.geolocation) {
+        const position = () => ({
+            coords: { latitude: emu.latitude, longitude: emu.longitude, accuracy: emu.accuracy || 10,
+                altitude: null, altitudeAccuracy: null, heading: null, speed: null },
+            timestamp: Date.now()
+        });
+        let watchId = 0;
+        navigator.geolocation.getCurrentPosition = (ok) => setTimeout(() => ok(position()), 0);
+        navigator.geolocation.watchPosition = (ok) => { setTimeout(() => ok(position()), 0); return ++watchId; };
+        navigator.geolocation.clearWatch = () => {};
+    }
+    if (emu.locale) {
+        Object.defineProperty(navigator, 'language', { get: () => emu.locale });
+        Object.defineProperty(navigator, 'languages', { get: () => [emu.locale] });
+    }
+    if (emu.timezone || emu.locale) {
+        const DTF = Intl.DateTimeFormat;
+        const withDefaults = (locales, options) => [locales === undefined && emu.locale ? emu.locale : locales,
+            Object.assign(emu.timezone ? { timeZone: emu.timezone } : {}, options)];
+        Intl.DateTimeFormat = function (locales, options) { return new DTF(...withDefaults(locales, options)); };
+        Intl.DateTimeFormat.prototype = DTF.prototype;
+        Intl.DateTimeFormat.supportedLocalesOf = DTF.supportedLocalesOf;
+        ['toLocaleString', 'toLocaleDateString', 'toLocaleTimeString'].forEach(fn => {
+            const orig = Date.prototype[fn];
+            Date.prototype[fn] = function (locales, options) { return orig.apply(this, withDefaults(locales, options)); };
+        });
+    }
+})();
+</script>`
+
+// apiConfigEmulationHandler returns (GET) or replaces (POST) the emulation
+// settings; an empty object turns emulation off.
+func apiConfigEmulationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var e Emulation
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := e.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetEmulation(e)
+		slog.Info("emulation changed", "timezone", e.Timezone, "locale", e.Locale, "geolocation", e.Latitude != nil)
+		recordAudit("emulation", map[string]interface{}{"timezone": e.Timezone, "locale": e.Locale, "geolocation": e.Latitude != nil})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state.Snapshot().Emulation)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JavaScript evaluation in the displays' pages, for /api/evaluate and the
+// evaluate step of automations. EVALUATE=off turns it off. EVALUATE_ALLOW
+// names a file of allowed expressions, one per line ("#" starts a
+// comment); when set, only those exact expressions run.
+var (
+	evaluateEnabled = true
+	evaluateAllow   map[string]bool
+)
+
+const (
+	defaultEvaluateTimeout = 5 * time.Second
+	maxEvaluateTimeout     = time.Minute
+)
+
+func initEvaluate() error {
+	evaluateEnabled = setting("EVALUATE") != "off"
+	evaluateAllow = nil
+	path := setting("EVALUATE_ALLOW")
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	evaluateAllow = map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			evaluateAllow[line] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// evaluateAllowed reports why expr may not run, if it may not. Without
+// ADMIN_TOKEN nothing may run, so automations can't stand in for the
+// guarded /api/evaluate.
+func evaluateAllowed(expr string) error {
+	if !evaluateEnabled {
+		return errors.New("evaluation is disabled")
+	}
+	if setting("ADMIN_TOKEN") == "" {
+		return errors.New("evaluation requires ADMIN_TOKEN")
+	}
+	if evaluateAllow != nil && !evaluateAllow[strings.TrimSpace(expr)] {
+		return errors.New("expression is not in the allowlist")
+	}
+	return nil
+}
+
+// apiEvaluateHandler runs {"expression": "...", "timeout": ms} in the page
+// and returns its JSON result. Promises are awaited.
+func apiEvaluateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Expression string `json:"expression"`
+		Timeout    int64  `json:"timeout"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAutomationBytes)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Expression) == "" {
+		http.Error(w, "Missing expression", http.StatusBadRequest)
+		return
+	}
+	if err := evaluateAllowed(req.Expression); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	timeout := defaultEvaluateTimeout
+	if req.Timeout > 0 {
+		timeout = min(time.Duration(req.Timeout)*time.Millisecond, maxEvaluateTimeout)
+	}
+
+	recordAudit("evaluate", map[string]interface{}{"expression": req.Expression})
+	v, err := askDisplay("evaluate", req.Expression, timeout, timeout+automationGrace)
+	if errors.Is(err, errNoDisplayAnswer) {
+		http.Error(w, "No display answered", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		slog.Info("evaluation failed", "err", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": v})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Events published on the internal bus. Subscribers (the admin event
+// stream, webhooks, the audit log, schedules) pick the topics they need
+// instead of polling shared state.
+const (
+	EventConfigChanged  = "config_changed"
+	EventTargetChanged  = "target_change"
+	EventNavigation     = "navigation"
+	EventReload         = "reload"
+	EventPageLoadFailed = "page_load_failed"
+	EventWatchdogTrip   = "watchdog_trip"
+	EventRecoveryAction = "recovery_action"
+	EventClockDrift     = "clock_drift"
+	EventMemoryPressure = "memory_pressure"
+	EventPower          = "power"
+	EventLock           = "lock"
+	EventTest           = "test"
+)
+
+// Event is a message on the internal bus, also pushed to connected admin
+// clients.
+type Event struct {
+	Event   string                 `json:"event"`
+	Message string                 `json:"message"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Time    int64                  `json:"time"`
+}
+
+type subscription struct {
+	topics map[string]bool // nil means every topic
+	// reliable subscribers make publishers wait rather than lose events;
+	// they must never publish themselves.
+	reliable bool
+}
+
+var (
+	eventSubscribers = map[chan Event]subscription{}
+	eventsMutex      sync.Mutex
+)
+
+func subscribe(buffer int, reliable bool, topics []string) chan Event {
+	sub := subscription{reliable: reliable}
+	if len(topics) > 0 {
+		sub.topics = map[string]bool{}
+		for _, t := range topics {
+			sub.topics[t] = true
+		}
+	}
+	ch := make(chan Event, buffer)
+	eventsMutex.Lock()
+	eventSubscribers[ch] = sub
+	eventsMutex.Unlock()
+	return ch
+}
+
+// subscribeEvents subscribes to topics (every topic when none are given).
+// Events are dropped for a subscriber that isn't keeping up.
+func subscribeEvents(topics ...string) chan Event {
+	return subscribe(16, false, topics)
+}
+
+// subscribeEventsReliable is like subscribeEvents but never drops events.
+func subscribeEventsReliable(topics ...string) chan Event {
+	return subscribe(256, true, topics)
+}
+
+func unsubscribeEvents(ch chan Event) {
+	eventsMutex.Lock()
+	delete(eventSubscribers, ch)
+	eventsMutex.Unlock()
+}
+
+// publishEvent delivers e to every subscriber of its topic.
+func publishEvent(e Event) {
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+	for ch, sub := range eventSubscribers {
+		if sub.topics != nil && !sub.topics[e.Event] {
+			continue
+		}
+		if sub.reliable {
+			ch <- e
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// notify publishes an event on the bus.
+func notify(event, message string, data map[string]interface{}) {
+	publishEvent(Event{Event: event, Message: message, Data: data, Time: time.Now().UnixMilli()})
+}
+
+// apiEventsHandler streams events as Server-Sent Events until the client
+// disconnects.
+func apiEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	ch := subscribeEvents()
+	defer unsubscribeEvents(ch)
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event, data)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// harness runs the full router against a local fixture site, with its own
+// data directory and environment, so tests exercise the same code paths a
+// display does.
+type harness struct {
+	t       *testing.T
+	target  *httptest.Server
+	server  *httptest.Server
+	dataDir string
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+// fixtureSite is the target the proxy mirrors in tests.
+func (h *harness) fixtureSite() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, `<html><head>`+
+			`<link rel="stylesheet" href="`+h.target.URL+`/style.css" integrity="sha384-abc" crossorigin="anonymous">`+
+			`</head><body>`+
+			`<a href="`+h.target.URL+`/about?x=1">About</a>`+
+			`<a href="https://external.example/page">External</a>`+
+			`<img src="/logo.png" srcset="`+h.target.URL+`/logo.png 1x, /logo@2x.png 2x">`+
+			`</body></html>`)
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		io.WriteString(w, `body{background:url("`+h.target.URL+`/bg.png")}`)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, h.target.URL+"/landing?from=redirect", http.StatusFound)
+	})
+	mux.HandleFunc("/cookie", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123", Path: "/"})
+		io.WriteString(w, "ok")
+	})
+	mux.HandleFunc("/report.csv", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+		io.WriteString(w, "a,b\n1,2\n")
+	})
+	mux.HandleFunc("/bad-gateway", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "502 Bad Gateway")
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "<html><head></head><body>boom</body></html>")
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.requests = append(h.requests, r.Clone(r.Context()))
+		h.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// newHarness starts a fixture site and a server wired to it. env entries
+// override the defaults; dataDir may be reused to simulate a restart.
+func newHarness(t *testing.T, dataDir string, env map[string]string) *harness {
+	t.Helper()
+	if dataDir == "" {
+		dataDir = t.TempDir()
+	}
+	h := &harness{t: t, dataDir: dataDir}
+	h.target = httptest.NewServer(h.fixtureSite())
+	t.Cleanup(h.target.Close)
+
+	defaults := map[string]string{
+		"DATA_DIR":      dataDir,
+		"TARGET_URL":    h.target.URL,
+		"ADMIN_TOKEN":   "",
+		"AUTO_SCROLL":   "",
+		"REWRITE_DEBUG": "",
+		"AUDIT_LOG":     "",
+		"FALLBACK_URL":  "",
+	}
+	for k, v := range env {
+		defaults[k] = v
+	}
+	for k, v := range defaults {
+		t.Setenv(k, v)
+	}
+
+	if err := initServices(); err != nil {
+		t.Fatalf("initServices: %v", err)
+	}
+	h.server = httptest.NewServer(newRouter())
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+func (h *harness) do(method, path string, body io.Reader, header http.Header) (*http.Response, string) {
+	h.t.Helper()
+	req, err := http.NewRequest(method, h.server.URL+path, body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func (h *harness) get(path string) (*http.Response, string) {
+	return h.do(http.MethodGet, path, nil, nil)
+}
+
+// navigate requests path the way a browser loads a page.
+func (h *harness) navigate(path string) (*http.Response, string) {
+	return h.do(http.MethodGet, path, nil, http.Header{"Accept": {"text/html"}})
+}
+
+func (h *harness) postJSON(path string, v interface{}) (*http.Response, string) {
+	data, _ := json.Marshal(v)
+	return h.do(http.MethodPost, path, bytes.NewReader(data), http.Header{"Content-Type": {"application/json"}})
+}
+
+func (h *harness) decode(body string, v interface{}) {
+	h.t.Helper()
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		h.t.Fatalf("decode %q: %v", body, err)
+	}
+}
+
+// lastTargetRequest returns the most recent request the fixture site saw
+// for path.
+func (h *harness) lastTargetRequest(path string) *http.Request {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.requests) - 1; i >= 0; i-- {
+		if h.requests[i].URL.Path == path {
+			return h.requests[i]
+		}
+	}
+	return nil
+}
+
+// eventually polls cond until it holds or the deadline passes.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func assertContains(t *testing.T, body, want string) {
+	t.Helper()
+	if !strings.Contains(body, want) {
+		t.Errorf("expected body to contain %q", want)
+	}
+}
+
+func assertNotContains(t *testing.T, body, unwanted string) {
+	t.Helper()
+	if strings.Contains(body, unwanted) {
+		t.Errorf("expected body not to contain %q", unwanted)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// targetProbe caches the result of the last reachability check so frequent
+// readiness polls don't hammer the upstream site.
+type targetProbe struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	ok        bool
+	status    int
+	latency   time.Duration
+	err       string
+}
+
+var (
+	probe         targetProbe
+	lastPageLoad  int64
+	pageLoadMutex sync.RWMutex
+)
+
+const probeCacheTTL = 5 * time.Second
+
+func markPageLoaded() {
+	pageLoadMutex.Lock()
+	lastPageLoad = time.Now().UnixMilli()
+	pageLoadMutex.Unlock()
+}
+
+func getLastPageLoad() int64 {
+	pageLoadMutex.RLock()
+	defer pageLoadMutex.RUnlock()
+	return lastPageLoad
+}
+
+// readyTimeout is how long the readiness probe waits for the target,
+// configurable via READY_TIMEOUT (seconds).
+func readyTimeout() time.Duration {
+	secs, _ := strconv.Atoi(setting("READY_TIMEOUT"))
+	if secs <= 0 {
+		secs = 5
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// probeURL issues a HEAD request against target without following redirects.
+func probeURL(target string) (int, time.Duration, error) {
+	if _, ok := localTarget(target); ok {
+		if err := validateLocalTarget(target); err != nil {
+			return 0, 0, err
+		}
+		return http.StatusOK, 0, nil
+	}
+	client := &http.Client{
+		Timeout:   readyTimeout(),
+		Transport: &http.Transport{DialContext: guardedDialContext, DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	start := time.Now()
+	resp, err := client.Head(target)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, latency, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, latency, nil
+}
+
+// reset drops the cached result, e.g. after the target URL changed.
+func (p *targetProbe) reset() {
+	p.mu.Lock()
+	p.checkedAt = time.Time{}
+	p.mu.Unlock()
+}
+
+func (p *targetProbe) check(target string) map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.checkedAt) > probeCacheTTL {
+		status, latency, err := probeURL(target)
+		p.latency = latency
+		p.checkedAt = time.Now()
+		if err != nil {
+			p.ok, p.status, p.err = false, 0, err.Error()
+		} else {
+			p.ok, p.status, p.err = status < 500, status, ""
+		}
+	}
+
+	result := map[string]interface{}{
+		"ok":        p.ok,
+		"status":    p.status,
+		"latencyMs": p.latency.Milliseconds(),
+		"checkedAt": p.checkedAt.UnixMilli(),
+	}
+	if p.err != "" {
+		result["error"] = p.err
+	}
+	return result
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "ok",
+		"startTime": startTime,
+		"uptimeSec": (time.Now().UnixMilli() - startTime) / 1000,
+	})
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	config := state.Snapshot()
+	target := probe.check(config.TargetURL)
+	ready := target["ok"].(bool)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":        ready,
+		"targetUrl":    config.TargetURL,
+		"target":       target,
+		"lastPageLoad": getLastPageLoad(),
+	})
+}
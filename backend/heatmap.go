@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"sync"
+)
+
+// Clicks are stored only as counts in a coarse grid over the normalized
+// document area; no timestamps or client details are kept. At most
+// maxTrackedURLs pages get a grid.
+const (
+	heatmapCols     = 50
+	heatmapRows     = 50
+	heatmapCellSize = 8
+)
+
+type heatmapGrid [heatmapRows][heatmapCols]int
+
+var (
+	heatmaps      = map[string]*heatmapGrid{}
+	heatmapsMutex sync.Mutex
+)
+
+func recordClick(u string, x, y float64) bool {
+	if x < 0 || x > 1 || y < 0 || y > 1 {
+		return false
+	}
+	col := int(x * heatmapCols)
+	row := int(y * heatmapRows)
+	if col == heatmapCols {
+		col--
+	}
+	if row == heatmapRows {
+		row--
+	}
+
+	heatmapsMutex.Lock()
+	defer heatmapsMutex.Unlock()
+	grid, ok := heatmaps[u]
+	if !ok {
+		if len(heatmaps) >= maxTrackedURLs {
+			return true
+		}
+		grid = &heatmapGrid{}
+		heatmaps[u] = grid
+	}
+	grid[row][col]++
+	return true
+}
+
+func getHeatmap(u string) (heatmapGrid, bool) {
+	heatmapsMutex.Lock()
+	defer heatmapsMutex.Unlock()
+	grid, ok := heatmaps[u]
+	if !ok {
+		return heatmapGrid{}, false
+	}
+	return *grid, true
+}
+
+// heatColor maps an intensity in [0,1] onto a blue→red ramp.
+func heatColor(v float64) color.RGBA {
+	if v <= 0 {
+		return color.RGBA{0, 0, 0, 0}
+	}
+	r := uint8(255 * v)
+	b := uint8(255 * (1 - v))
+	return color.RGBA{r, 64, b, uint8(96 + 159*v)}
+}
+
+func apiHeatmapHandler(w http.ResponseWriter, r *http.Request) {
+	u := r.URL.Query().Get("url")
+	if u == "" {
+		heatmapsMutex.Lock()
+		urls := make([]string, 0, len(heatmaps))
+		for k := range heatmaps {
+			urls = append(urls, k)
+		}
+		heatmapsMutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"urls": urls})
+		return
+	}
+
+	grid, _ := getHeatmap(u)
+	total := 0
+	cells := make([][]int, heatmapRows)
+	for i := range grid {
+		cells[i] = grid[i][:]
+		for _, c := range grid[i] {
+			total += c
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":   u,
+		"cols":  heatmapCols,
+		"rows":  heatmapRows,
+		"total": total,
+		"cells": cells,
+	})
+}
+
+func apiHeatmapImageHandler(w http.ResponseWriter, r *http.Request) {
+	u := r.URL.Query().Get("url")
+	if u == "" {
+		http.Error(w, "Missing url", http.StatusBadRequest)
+		return
+	}
+	grid, _ := getHeatmap(u)
+
+	peak := 0
+	for i := range grid {
+		for _, c := range grid[i] {
+			peak = max(peak, c)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, heatmapCols*heatmapCellSize, heatmapRows*heatmapCellSize))
+	for row := range grid {
+		for col, c := range grid[row] {
+			if c == 0 {
+				continue
+			}
+			clr := heatColor(float64(c) / float64(peak))
+			for y := row * heatmapCellSize; y < (row+1)*heatmapCellSize; y++ {
+				for x := col * heatmapCellSize; x < (col+1)*heatmapCellSize; x++ {
+					img.SetRGBA(x, y, clr)
+				}
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, img)
+}
+
+func apiHeatmapClickHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var click struct {
+		URL string  `json:"url"`
+		X   float64 `json:"x"`
+		Y   float64 `json:"y"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&click); err != nil || click.URL == "" {
+		http.Error(w, "Invalid click", http.StatusBadRequest)
+		return
+	}
+	if !recordClick(click.URL, click.X, click.Y) {
+		http.Error(w, "Coordinates out of range", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
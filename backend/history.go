@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// History keeps what the displays showed and what went wrong: navigations,
+// target changes, reloads and errors, taken from the event bus. Entries are
+// appended to DATA_DIR/history.jsonl and kept for HISTORY_DAYS days
+// (default 30). Favorites are kept regardless of age and the cap, and can
+// be given a name to show as one-click destinations.
+type HistoryEntry struct {
+	Seq      int64                  `json:"seq"`
+	Time     int64                  `json:"time"`
+	Event    string                 `json:"event"`
+	URL      string                 `json:"url,omitempty"`
+	Message  string                 `json:"message,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Favorite bool                   `json:"favorite,omitempty"`
+	Name     string                 `json:"name,omitempty"`
+}
+
+const (
+	maxHistoryEntries = 100000
+	// maxDwell caps the time a page counts as shown when no later
+	// navigation from the same display closes it.
+	maxDwell = time.Hour
+)
+
+var historyEvents = []string{EventNavigation, EventTargetChanged, EventReload, EventPageLoadFailed, EventWatchdogTrip}
+
+var (
+	history      []HistoryEntry
+	historyMutex sync.RWMutex
+	historyPath  string
+	historyDays  = 30
+)
+
+func initHistory() error {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	historyPath = filepath.Join(dataDir, "history.jsonl")
+	history = nil
+	if days, err := strconv.Atoi(setting("HISTORY_DAYS")); err == nil && days > 0 {
+		historyDays = days
+	}
+
+	f, err := os.Open(historyPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			history = append(history, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if pruneHistory() {
+		return saveHistory()
+	}
+	return nil
+}
+
+// pruneHistory drops expired entries and the oldest ones beyond the cap,
+// sparing favorites, and reports whether anything was removed. Callers must
+// hold historyMutex.
+func pruneHistory() bool {
+	cutoff := time.Now().AddDate(0, 0, -historyDays).UnixMilli()
+	n := 0
+	for _, e := range history {
+		if e.Time >= cutoff && !e.Favorite {
+			n++
+		}
+	}
+	excess := n - maxHistoryEntries
+	kept := history[:0]
+	for _, e := range history {
+		switch {
+		case e.Favorite:
+		case e.Time < cutoff:
+			continue
+		case excess > 0:
+			excess--
+			continue
+		}
+		kept = append(kept, e)
+	}
+	removed := len(kept) != len(history)
+	history = kept
+	return removed
+}
+
+// saveHistory replaces the file with the entries in memory. Callers must
+// hold historyMutex.
+func saveHistory() error {
+	tmp := historyPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range history {
+		line, _ := json.Marshal(e)
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, historyPath)
+}
+
+func appendHistory(e Event) {
+	entry := HistoryEntry{Time: e.Time, Event: e.Event, Message: e.Message, Data: e.Data}
+	for _, key := range []string{"url", "to"} {
+		if u, ok := e.Data[key].(string); ok {
+			entry.URL = u
+			break
+		}
+	}
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	if n := len(history); n > 0 {
+		entry.Seq = history[n-1].Seq + 1
+	} else {
+		entry.Seq = 1
+	}
+	history = append(history, entry)
+	if len(history) > maxHistoryEntries+maxHistoryEntries/10 && pruneHistory() {
+		if err := saveHistory(); err != nil {
+			slog.Error("history: failed to compact", "err", err)
+		}
+		return
+	}
+
+	f, err := os.OpenFile(historyPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		slog.Error("history: failed to open", "err", err)
+		return
+	}
+	defer f.Close()
+	line, _ := json.Marshal(entry)
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		slog.Error("history: failed to write", "err", err)
+	}
+}
+
+// initHistoryEvents records history events from the bus.
+func initHistoryEvents() {
+	ch := subscribeEventsReliable(historyEvents...)
+	go func() {
+		for e := range ch {
+			appendHistory(e)
+		}
+	}()
+}
+
+// historyQuery filters entries: q matches URL or message (case-insensitive),
+// event is a comma-separated list, since/until are Unix milliseconds.
+type historyQuery struct {
+	q            string
+	events       map[string]bool
+	since, until int64
+	favorites    bool
+}
+
+func (hq historyQuery) match(e HistoryEntry) bool {
+	if hq.favorites && !e.Favorite {
+		return false
+	}
+	if hq.events != nil && !hq.events[e.Event] {
+		return false
+	}
+	if (hq.since > 0 && e.Time < hq.since) || (hq.until > 0 && e.Time > hq.until) {
+		return false
+	}
+	if hq.q != "" && !strings.Contains(strings.ToLower(e.URL+" "+e.Name+" "+e.Message), hq.q) {
+		return false
+	}
+	return true
+}
+
+func parseHistoryQuery(r *http.Request) historyQuery {
+	q := r.URL.Query()
+	hq := historyQuery{q: strings.ToLower(q.Get("q")), favorites: q.Get("favorites") == "true"}
+	if ev := q.Get("event"); ev != "" {
+		hq.events = map[string]bool{}
+		for _, e := range strings.Split(ev, ",") {
+			hq.events[strings.TrimSpace(e)] = true
+		}
+	}
+	hq.since, _ = strconv.ParseInt(q.Get("since"), 10, 64)
+	hq.until, _ = strconv.ParseInt(q.Get("until"), 10, 64)
+	return hq
+}
+
+// apiHistoryHandler lists matching entries newest first, paginated with
+// offset and limit (default 50, at most 500). DELETE removes them instead.
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		deleteHistoryHandler(w, r)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hq := parseHistoryQuery(r)
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+	limit = min(limit, 500)
+	offset = max(offset, 0)
+
+	entries := []HistoryEntry{}
+	total := 0
+	historyMutex.RLock()
+	for i := len(history) - 1; i >= 0; i-- {
+		if !hq.match(history[i]) {
+			continue
+		}
+		if total >= offset && len(entries) < limit {
+			entries = append(entries, history[i])
+		}
+		total++
+	}
+	historyMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+		"entries": entries,
+	})
+}
+
+// deleteHistoryHandler removes the entries listed in ?seq= (comma-separated),
+// or else every entry matching the query filters except favorites.
+func deleteHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var seqs map[int64]bool
+	if raw := r.URL.Query().Get("seq"); raw != "" {
+		seqs = map[int64]bool{}
+		for _, s := range strings.Split(raw, ",") {
+			seq, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid seq", http.StatusBadRequest)
+				return
+			}
+			seqs[seq] = true
+		}
+	}
+	hq := parseHistoryQuery(r)
+
+	historyMutex.Lock()
+	kept := history[:0]
+	for _, e := range history {
+		if seqs != nil && seqs[e.Seq] || seqs == nil && !e.Favorite && hq.match(e) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	removed := len(history) - len(kept)
+	history = kept
+	var err error
+	if removed > 0 {
+		err = saveHistory()
+	}
+	historyMutex.Unlock()
+	if err != nil {
+		slog.Error("history: failed to save", "err", err)
+		http.Error(w, "Failed to save history", http.StatusInternalServerError)
+		return
+	}
+	recordAudit("history_delete", map[string]interface{}{"removed": removed})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"removed": removed})
+}
+
+// updateHistoryEntry applies fn to the entry with the given seq and saves
+// the history, returning the updated entry.
+func updateHistoryEntry(seq int64, fn func(e *HistoryEntry)) (HistoryEntry, bool, error) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	for i := range history {
+		if history[i].Seq == seq {
+			fn(&history[i])
+			return history[i], true, saveHistory()
+		}
+	}
+	return HistoryEntry{}, false, nil
+}
+
+// favoriteHistory returns the favorites, newest first.
+func favoriteHistory() []HistoryEntry {
+	historyMutex.RLock()
+	defer historyMutex.RUnlock()
+	out := []HistoryEntry{}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Favorite {
+			out = append(out, history[i])
+		}
+	}
+	return out
+}
+
+// apiHistoryFavoriteHandler pins (favorite=true, the default) or unpins the
+// entry ?seq= and, when name= is given, renames it.
+func apiHistoryFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	seq, err := strconv.ParseInt(q.Get("seq"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid seq", http.StatusBadRequest)
+		return
+	}
+	favorite := q.Get("favorite") != "false"
+	name, rename := q["name"]
+	entry, ok, err := updateHistoryEntry(seq, func(e *HistoryEntry) {
+		e.Favorite = favorite
+		if rename {
+			e.Name = strings.TrimSpace(name[0])
+		}
+	})
+	if !ok {
+		http.Error(w, "Unknown history entry", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("history: failed to save", "err", err)
+		http.Error(w, "Failed to save history", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// historyTarget resolves the URL of entry seq against the current target,
+// since navigations are recorded as paths.
+func historyTarget(seq int64) (*url.URL, error) {
+	historyMutex.RLock()
+	raw := ""
+	for _, e := range history {
+		if e.Seq == seq {
+			raw = e.URL
+			break
+		}
+	}
+	historyMutex.RUnlock()
+	if raw == "" {
+		return nil, errors.New("unknown history entry")
+	}
+	base, err := url.Parse(state.Snapshot().TargetURL)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	u, err := normalizeTargetURL(base.ResolveReference(ref).String())
+	if err != nil {
+		return nil, err
+	}
+	return u, checkTarget(u)
+}
+
+// apiHistoryOpenHandler sends the displays to the URL of entry ?seq=.
+func apiHistoryOpenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	seq, err := strconv.ParseInt(r.URL.Query().Get("seq"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid seq", http.StatusBadRequest)
+		return
+	}
+	u, err := historyTarget(seq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	previous := switchTarget(u)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"url": u.String(), "previous": previous})
+}
+
+// URLHistoryStats summarises the history of one URL. A page counts as
+// shown on a display from its navigation until that display's next one
+// (at most an hour).
+type URLHistoryStats struct {
+	URL          string  `json:"url"`
+	Loads        int     `json:"loads"`
+	Failures     int     `json:"failures"`
+	DwellSeconds float64 `json:"dwellSeconds"`
+	LastSeen     int64   `json:"lastSeen"`
+}
+
+func historyStats(hq historyQuery) []URLHistoryStats {
+	byURL := map[string]*URLHistoryStats{}
+	statsFor := func(u string) *URLHistoryStats {
+		s, ok := byURL[u]
+		if !ok {
+			s = &URLHistoryStats{URL: u}
+			byURL[u] = s
+		}
+		return s
+	}
+	// open holds the navigation each display is currently showing.
+	open := map[string]HistoryEntry{}
+	closeDwell := func(client string, until int64) {
+		if prev, ok := open[client]; ok {
+			statsFor(prev.URL).DwellSeconds += min(time.Duration(until-prev.Time)*time.Millisecond, maxDwell).Seconds()
+			delete(open, client)
+		}
+	}
+
+	historyMutex.RLock()
+	for _, e := range history {
+		if e.URL == "" || !hq.match(e) {
+			continue
+		}
+		switch e.Event {
+		case EventNavigation:
+			client, _ := e.Data["client"].(string)
+			closeDwell(client, e.Time)
+			open[client] = e
+			s := statsFor(e.URL)
+			s.Loads++
+			s.LastSeen = e.Time
+		case EventPageLoadFailed:
+			statsFor(e.URL).Failures++
+		}
+	}
+	historyMutex.RUnlock()
+	now := time.Now().UnixMilli()
+	for client := range open {
+		closeDwell(client, now)
+	}
+
+	out := make([]URLHistoryStats, 0, len(byURL))
+	for _, s := range byURL {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DwellSeconds > out[j].DwellSeconds })
+	return out
+}
+
+// apiHistoryStatsHandler returns per-URL loads, failures and dwell time
+// for the entries matching the same filters as /api/history.
+func apiHistoryStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"urls": historyStats(parseHistoryQuery(r))})
+}
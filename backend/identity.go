@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Identity tells the displays of a fleet apart in the status API, alerts,
+// watermarks and error cards. Name defaults to DEVICE_ID or the host name.
+type Identity struct {
+	Name     string   `json:"name"`
+	Location string   `json:"location,omitempty"`
+	Tags     []string `json:"tags"`
+}
+
+var identityPath string
+
+func (id *Identity) validate() error {
+	id.Name = strings.TrimSpace(id.Name)
+	if id.Name == "" || len(id.Name) > 64 {
+		return errors.New("name must be 1 to 64 characters")
+	}
+	id.Location = strings.TrimSpace(id.Location)
+	tags := []string{}
+	for _, t := range id.Tags {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	id.Tags = tags
+	return nil
+}
+
+// initIdentity reads INSTANCE_NAME, INSTANCE_LOCATION and INSTANCE_TAGS
+// (comma-separated); an identity set through the API and saved in
+// DATA_DIR/identity.json takes precedence.
+func initIdentity() error {
+	identityPath = filepath.Join(dataDir, "identity.json")
+	id := Identity{
+		Name:     setting("INSTANCE_NAME"),
+		Location: setting("INSTANCE_LOCATION"),
+		Tags:     strings.Split(setting("INSTANCE_TAGS"), ","),
+	}
+	if id.Name == "" {
+		id.Name = setting("DEVICE_ID")
+	}
+	if id.Name == "" {
+		id.Name, _ = os.Hostname()
+	}
+	if err := id.validate(); err != nil {
+		return err
+	}
+	if data, err := os.ReadFile(identityPath); err == nil {
+		var saved Identity
+		if err := json.Unmarshal(data, &saved); err != nil || saved.validate() != nil {
+			slog.Warn("ignoring invalid saved identity", "path", identityPath)
+		} else {
+			id = saved
+		}
+	}
+	state.Modify(func(c *Config) { c.Identity = id })
+	return nil
+}
+
+// SetIdentity changes the identity, saves it so it survives a restart, and
+// reloads the displays so watermarks pick it up.
+func SetIdentity(id Identity) {
+	state.Update("", func(c *Config) { c.Identity = id })
+	saveIdentity(id)
+}
+
+func saveIdentity(id Identity) {
+	data, _ := json.Marshal(id)
+	if err := os.WriteFile(identityPath, data, 0644); err != nil {
+		slog.Error("failed to save identity", "err", err)
+	}
+}
+
+// apiConfigIdentityHandler returns (GET) or replaces (POST
+// {"name":"lobby-1","location":"HQ lobby","tags":["lobby"]}) the identity.
+func apiConfigIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var id Identity
+		if err := json.NewDecoder(r.Body).Decode(&id); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := id.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetIdentity(id)
+		slog.Info("identity changed", "name", id.Name, "location", id.Location)
+		recordAudit("identity", map[string]interface{}{"name": id.Name, "location": id.Location, "tags": id.Tags})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state.Snapshot().Identity)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Settings for running behind a reverse proxy such as nginx or Traefik.
+//
+// TRUST_PROXY=true honours X-Forwarded-For, X-Forwarded-Proto and
+// X-Forwarded-Host; only enable it when every request comes through the
+// proxy, since clients can send these headers themselves.
+//
+// BASE_PATH mounts the whole app below a prefix such as /displays/lobby.
+// The proxy in front forwards the prefix unchanged; it is stripped from
+// requests and added to the root-relative URLs in pages, stylesheets,
+// redirects and the requests scripts make.
+//
+// CORS_ORIGINS lists the origins (or "*") allowed to call the paths listed
+// in CORS_PATHS (default /api/) from a browser.
+var (
+	trustProxy  bool
+	basePath    string
+	corsOrigins map[string]bool
+	corsPaths   []string
+)
+
+func initIngress() error {
+	trustProxy = setting("TRUST_PROXY") == "true"
+
+	basePath = strings.TrimRight(setting("BASE_PATH"), "/")
+	if basePath != "" && (!strings.HasPrefix(basePath, "/") || strings.ContainsAny(basePath, `"'<>?# `)) {
+		return errors.New("BASE_PATH must be a path such as /displays/lobby")
+	}
+
+	corsOrigins = nil
+	for _, o := range strings.Split(setting("CORS_ORIGINS"), ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			if corsOrigins == nil {
+				corsOrigins = map[string]bool{}
+			}
+			corsOrigins[o] = true
+		}
+	}
+	corsPaths = []string{"/api/"}
+	if raw := setting("CORS_PATHS"); raw != "" {
+		corsPaths = nil
+		for _, p := range strings.Split(raw, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				corsPaths = append(corsPaths, p)
+			}
+		}
+	}
+	return nil
+}
+
+// requestScheme is "https" when the display reached us over TLS, directly
+// or through a trusted proxy.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	return "http"
+}
+
+// forwarded applies the X-Forwarded-* headers of a trusted proxy to r, so
+// logs and the client list show the real address.
+func forwarded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !trustProxy {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-1])); ip != nil {
+				r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+			}
+		}
+		if proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			r.URL.Scheme = proto
+		}
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			r.Host = strings.TrimSpace(strings.Split(host, ",")[0])
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors answers preflight requests and adds CORS headers for allowed
+// origins on the CORS_PATHS.
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || corsOrigins == nil || !corsPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !corsOrigins["*"] && !corsOrigins[origin] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		if corsOrigins[origin] {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			// Any origin may read, but browsers then leave cookies out.
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Admin-Token")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func corsPath(path string) bool {
+	for _, p := range corsPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// withBasePath serves next below BASE_PATH.
+func withBasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if basePath == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, basePath)
+		r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, basePath)
+		bw := &basePathWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+		bw.finish()
+	})
+}
+
+// basePathWriter prefixes redirects and buffers HTML and CSS so their
+// root-relative URLs can be prefixed; everything else streams through.
+type basePathWriter struct {
+	http.ResponseWriter
+	status    int
+	buffering bool
+	html      bool
+	buf       bytes.Buffer
+}
+
+func (b *basePathWriter) WriteHeader(code int) {
+	if b.status != 0 {
+		return
+	}
+	b.status = code
+	h := b.Header()
+	if loc := h.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		h.Set("Location", basePath+loc)
+	}
+	ct := h.Get("Content-Type")
+	b.html = strings.HasPrefix(ct, "text/html")
+	b.buffering = (b.html || strings.HasPrefix(ct, "text/css")) && h.Get("Content-Encoding") == ""
+	if b.buffering {
+		h.Del("Content-Length")
+		return
+	}
+	b.ResponseWriter.WriteHeader(code)
+}
+
+func (b *basePathWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		if b.Header().Get("Content-Type") == "" {
+			b.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		b.WriteHeader(http.StatusOK)
+	}
+	if b.buffering {
+		return b.buf.Write(p)
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+func (b *basePathWriter) Flush() {
+	if b.buffering {
+		return
+	}
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (b *basePathWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+func (b *basePathWriter) finish() {
+	if !b.buffering {
+		return
+	}
+	body := string(prefixRootURLs(b.buf.Bytes()))
+	if b.html {
+		body = insertAtHeadStart(body, basePathScript())
+	}
+	b.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write([]byte(body))
+}
+
+var (
+	rootAttrRe   = regexp.MustCompile(`(\s(?:href|src|action|poster)=["'])/([^/])`)
+	rootSrcsetRe = regexp.MustCompile(`(\ssrcset=["'])([^"']*)`)
+	rootCSSURLRe = regexp.MustCompile(`(url\(\s*["']?)/([^/])`)
+)
+
+// prefixRootURLs adds BASE_PATH to root-relative URLs in HTML attributes
+// and CSS url() references.
+func prefixRootURLs(body []byte) []byte {
+	body = rootAttrRe.ReplaceAll(body, []byte("${1}"+basePath+"/${2}"))
+	body = rootCSSURLRe.ReplaceAll(body, []byte("${1}"+basePath+"/${2}"))
+	return rootSrcsetRe.ReplaceAllFunc(body, func(m []byte) []byte {
+		sub := rootSrcsetRe.FindSubmatch(m)
+		parts := strings.Split(string(sub[2]), ",")
+		for i, p := range parts {
+			trimmed := strings.TrimLeft(p, " ")
+			if strings.HasPrefix(trimmed, "/") && !strings.HasPrefix(trimmed, "//") {
+				parts[i] = p[:len(p)-len(trimmed)] + basePath + trimmed
+			}
+		}
+		return append(append([]byte{}, sub[1]...), strings.Join(parts, ",")...)
+	})
+}
+
+// basePathScript prefixes the root-relative URLs that scripts, including
+// our own, request at runtime.
+func basePathScript() string {
+	return fmt.Sprintf(basePathTemplate, strconv.Quote(basePath))
+}
+
+const basePathTemplate = `<script>
+(() => {
+    const base = %s;
+    const fix = (u) => (typeof u === 'string' && u.startsWith('/') && !u.startsWith('//') && u !== base && !u.startsWith(base + '/')) ? base + u : u;
+    const fetch = window.fetch;
+    window.fetch = (input, init) => fetch.call(window, fix(input), init);
+    const open = XMLHttpRequest.prototype.open;
+    XMLHttpRequest.prototype.open = function (method, u, ...rest) { return open.call(this, method, fix(u), ...rest); };
+    if (window.EventSource) {
+        const ES = window.EventSource;
+        window.EventSource = function (u, opts) { return new ES(fix(u), opts); };
+        window.EventSource.prototype = ES.prototype;
+    }
+    for (const name of ['pushState', 'replaceState']) {
+        const orig = history[name];
+        history[name] = function (state, title, u) { return orig.call(this, state, title, fix(u)); };
+    }
+    const beacon = navigator.sendBeacon && navigator.sendBeacon.bind(navigator);
+    if (beacon) navigator.sendBeacon = (u, data) => beacon(fix(u), data);
+})();
+</script>`
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Remote input typed by the operator is queued here and replayed on the
+// display. "insert" events put a whole string into the focused field in one
+// go; "key" events press a single named key (Enter, Backspace, Tab, …) or a
+// single character; "compose" events deliver text the way an IME does, for
+// pages that only react to composition events (CJK inputs, dead keys).
+type inputEvent struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	Key  string `json:"key,omitempty"`
+	Time int64  `json:"time"`
+}
+
+const (
+	maxInputQueue = 200
+	// inputBatchWindow is how long an undelivered insert stays open for
+	// rapid follow-up keystrokes to be appended to it.
+	inputBatchWindow = 300 * time.Millisecond
+)
+
+var (
+	inputQueue     []inputEvent
+	inputSeq       int
+	inputDelivered int
+	inputMutex     sync.Mutex
+)
+
+var inputKeys = map[string]bool{
+	"Enter": true, "Backspace": true, "Delete": true, "Tab": true, "Escape": true,
+	"ArrowUp": true, "ArrowDown": true, "ArrowLeft": true, "ArrowRight": true,
+	"Home": true, "End": true, "PageUp": true, "PageDown": true,
+}
+
+// queueInput appends an event, merging consecutive inserts that arrive
+// before the display has picked them up.
+func queueInput(ev inputEvent) inputEvent {
+	inputMutex.Lock()
+	defer inputMutex.Unlock()
+
+	now := time.Now()
+	if n := len(inputQueue); ev.Type == "insert" && n > 0 {
+		last := &inputQueue[n-1]
+		if last.Type == "insert" && last.Seq > inputDelivered && now.Sub(time.UnixMilli(last.Time)) < inputBatchWindow {
+			last.Text += ev.Text
+			last.Time = now.UnixMilli()
+			return *last
+		}
+	}
+	inputSeq++
+	ev.Seq = inputSeq
+	ev.Time = now.UnixMilli()
+	inputQueue = append(inputQueue, ev)
+	if len(inputQueue) > maxInputQueue {
+		inputQueue = inputQueue[len(inputQueue)-maxInputQueue:]
+	}
+	return ev
+}
+
+// pendingInput returns queued events newer than since and marks them
+// delivered so they are no longer extended.
+func pendingInput(since int) []inputEvent {
+	inputMutex.Lock()
+	defer inputMutex.Unlock()
+	out := []inputEvent{}
+	for _, ev := range inputQueue {
+		if ev.Seq > since {
+			out = append(out, ev)
+		}
+	}
+	if len(out) > 0 {
+		inputDelivered = max(inputDelivered, out[len(out)-1].Seq)
+	}
+	return out
+}
+
+// apiInputHandler queues an input event from the operator.
+func apiInputHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ev inputEvent
+	r.Body = http.MaxBytesReader(w, r.Body, maxClipboardBytes)
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		http.Error(w, "Invalid input payload", http.StatusBadRequest)
+		return
+	}
+	switch ev.Type {
+	case "insert", "compose":
+		if ev.Text == "" {
+			http.Error(w, "Missing text", http.StatusBadRequest)
+			return
+		}
+		ev.Key = ""
+	case "key":
+		if !inputKeys[ev.Key] && utf8.RuneCountInString(ev.Key) != 1 {
+			http.Error(w, "Unsupported key", http.StatusBadRequest)
+			return
+		}
+		ev.Text = ""
+	default:
+		http.Error(w, "Unknown input type", http.StatusBadRequest)
+		return
+	}
+	queued := queueInput(ev)
+	recordMacroStep(MacroStep{Input: &ev})
+	// Named keys are logged but typed text and single characters only by
+	// length, so secrets stay out of the log.
+	audit := map[string]interface{}{"type": ev.Type, "length": len(ev.Text)}
+	if inputKeys[ev.Key] {
+		audit["key"] = ev.Key
+	} else if ev.Key != "" {
+		audit["length"] = len(ev.Key)
+	}
+	recordAudit("input", audit)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queued)
+}
+
+// apiInputPendingHandler lets the display fetch events newer than ?since=.
+func apiInputPendingHandler(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	events := pendingInput(since)
+	inputMutex.Lock()
+	seq := inputSeq
+	inputMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"seq": seq, "events": events})
+}
+
+// inputScript replays queued operator input into the focused element. On
+// first load it only learns the current sequence so old input isn't replayed.
+const inputScript = `
+<script>
+(() => {
+    let seq = -1;
+    const insert = (el, text) => {
+        if (!document.execCommand('insertText', false, text) && 'setRangeText' in el) {
+            el.setRangeText(text, el.selectionStart, el.selectionEnd, 'end');
+            el.dispatchEvent(new Event('input', { bubbles: true }));
+        }
+    };
+    const compose = (el, text) => {
+        el.dispatchEvent(new CompositionEvent('compositionstart', { data: '', bubbles: true }));
+        el.dispatchEvent(new CompositionEvent('compositionupdate', { data: text, bubbles: true }));
+        insert(el, text);
+        el.dispatchEvent(new CompositionEvent('compositionend', { data: text, bubbles: true }));
+    };
+    const press = (el, key) => {
+        const char = [...key].length === 1;
+        const init = { key, code: char ? '' : key, bubbles: true, cancelable: true };
+        const go = el.dispatchEvent(new KeyboardEvent('keydown', init));
+        if (go) {
+            if (char) insert(el, key);
+            else if (key === 'Backspace') document.execCommand('delete');
+            else if (key === 'Delete') document.execCommand('forwardDelete');
+            else if (key === 'Enter') {
+                if (el.tagName === 'TEXTAREA' || el.isContentEditable) insert(el, '\n');
+                else if (el.form) el.form.requestSubmit ? el.form.requestSubmit() : el.form.submit();
+            }
+        }
+        el.dispatchEvent(new KeyboardEvent('keyup', init));
+    };
+    const poll = () => fetch('/api/input/pending?since=' + Math.max(seq, 0), { cache: 'no-store' })
+        .then(res => res.json())
+        .then(data => {
+            if (seq < 0) { seq = data.seq; return; }
+            const el = document.activeElement || document.body;
+            data.events.forEach(ev => {
+                seq = Math.max(seq, ev.seq);
+                if (ev.type === 'insert') insert(el, ev.text);
+                else if (ev.type === 'compose') compose(el, ev.text);
+                else if (ev.type === 'key') press(el, ev.key);
+            });
+        })
+        .catch(() => {});
+    poll();
+    setInterval(poll, 500);
+})();
+</script>
+`
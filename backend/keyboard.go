@@ -0,0 +1,85 @@
+package main
+
+// keyboardScript adds an on-screen keyboard for touch-only kiosks. It slides
+// in whenever an editable element gains focus and types straight into it.
+// Injected only when ON_SCREEN_KEYBOARD=true.
+const keyboardScript = `
+<script>
+(() => {
+    const rows = ['1234567890', 'qwertyuiop', 'asdfghjkl', 'zxcvbnm'];
+    let shift = false, target = null;
+    const editable = (el) => el && (el.isContentEditable || el.tagName === 'TEXTAREA' ||
+        (el.tagName === 'INPUT' && !['button', 'checkbox', 'radio', 'submit', 'reset', 'file', 'image', 'range', 'color'].includes(el.type)));
+
+    const kb = document.createElement('div');
+    kb.id = 'ctrl-keyboard';
+    kb.style.cssText = 'position:fixed;left:0;right:0;bottom:0;z-index:2147483647;background:#222;padding:6px;display:none;font-family:sans-serif;user-select:none;';
+    const insert = (text) => {
+        if (!target) return;
+        target.focus();
+        if (!document.execCommand('insertText', false, text) && 'setRangeText' in target) {
+            target.setRangeText(text, target.selectionStart, target.selectionEnd, 'end');
+            target.dispatchEvent(new Event('input', { bubbles: true }));
+        }
+    };
+    const press = (key) => {
+        if (!target) return;
+        target.focus();
+        if (key === 'Backspace') {
+            document.execCommand('delete');
+        } else if (key === 'Enter') {
+            if (target.tagName === 'TEXTAREA' || target.isContentEditable) insert('\n');
+            else {
+                target.dispatchEvent(new KeyboardEvent('keydown', { key: 'Enter', code: 'Enter', keyCode: 13, bubbles: true }));
+                if (target.form) target.form.requestSubmit ? target.form.requestSubmit() : target.form.submit();
+            }
+        }
+    };
+    const button = (label, action, grow) => {
+        const b = document.createElement('button');
+        b.textContent = label;
+        b.style.cssText = 'flex:' + (grow || 1) + ';margin:3px;padding:14px 0;font-size:20px;border:0;border-radius:6px;background:#444;color:#fff;';
+        b.addEventListener('mousedown', e => e.preventDefault());
+        b.addEventListener('touchstart', e => e.preventDefault(), { passive: false });
+        b.addEventListener('touchend', e => { e.preventDefault(); action(); });
+        b.addEventListener('click', action);
+        return b;
+    };
+    const render = () => {
+        kb.replaceChildren();
+        rows.forEach((row, i) => {
+            const line = document.createElement('div');
+            line.style.display = 'flex';
+            if (i === 3) line.appendChild(button(shift ? '⇪' : '⇧', () => { shift = !shift; render(); }, 1.5));
+            [...row].forEach(ch => {
+                const c = shift ? ch.toUpperCase() : ch;
+                line.appendChild(button(c, () => { insert(c); if (shift) { shift = false; render(); } }));
+            });
+            if (i === 3) line.appendChild(button('⌫', () => press('Backspace'), 1.5));
+            kb.appendChild(line);
+        });
+        const last = document.createElement('div');
+        last.style.display = 'flex';
+        ['.', ',', '@', '-'].forEach(ch => last.appendChild(button(ch, () => insert(ch))));
+        last.appendChild(button('space', () => insert(' '), 5));
+        last.appendChild(button('⏎', () => press('Enter'), 1.5));
+        last.appendChild(button('✕', () => { kb.style.display = 'none'; if (target) target.blur(); }, 1.5));
+        kb.appendChild(last);
+    };
+    render();
+
+    document.addEventListener('focusin', (e) => {
+        if (editable(e.target)) {
+            target = e.target;
+            kb.style.display = 'block';
+        }
+    });
+    document.addEventListener('focusout', () => setTimeout(() => {
+        if (!editable(document.activeElement)) kb.style.display = 'none';
+    }, 100));
+    const mount = () => document.documentElement.appendChild(kb);
+    if (document.readyState === 'loading') document.addEventListener('DOMContentLoaded', mount);
+    else mount();
+})();
+</script>
+`
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The server listens on TCP port PORT (default 1337; "off" disables it)
+// or the addresses in LISTEN_ADDRS, on the Unix socket LISTEN_SOCKET if
+// set, and on any sockets passed in by systemd socket activation. With a
+// socket, a local nginx can be the only process facing the network.
+
+// systemdListenFdsStart is the first file descriptor systemd passes.
+const systemdListenFdsStart = 3
+
+// systemdListeners returns the sockets passed by systemd (LISTEN_PID and
+// LISTEN_FDS, see sd_listen_fds(3)).
+func systemdListeners() ([]net.Listener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, nil
+	}
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	var listeners []net.Listener
+	for fd := systemdListenFdsStart; fd < systemdListenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// unixListener listens on path, replacing a socket left by an earlier run.
+// LISTEN_SOCKET_MODE sets its permissions (octal, default 0660).
+func unixListener(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0660)
+	if raw := setting("LISTEN_SOCKET_MODE"); raw != "" {
+		m, err := strconv.ParseUint(raw, 8, 32)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("invalid LISTEN_SOCKET_MODE %q", raw)
+		}
+		mode = os.FileMode(m)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// tcpAddrs returns the TCP addresses to listen on: LISTEN_ADDRS, a
+// comma-separated list such as "127.0.0.1:1337,[::1]:1337,10.0.5.2:8080",
+// or else every address on PORT.
+func tcpAddrs() []string {
+	var addrs []string
+	for _, a := range strings.Split(setting("LISTEN_ADDRS"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs
+	}
+	port := setting("PORT")
+	if port == "" {
+		port = "1337"
+	}
+	if port == "off" {
+		return nil
+	}
+	return []string{":" + port}
+}
+
+// openListeners opens every configured listener.
+func openListeners() ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if path := setting("LISTEN_SOCKET"); path != "" {
+		l, err := unixListener(path)
+		if err != nil {
+			return nil, fmt.Errorf("unix socket: %w", err)
+		}
+		listeners = append(listeners, l)
+	}
+	for _, addr := range tcpAddrs() {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("nothing to listen on: PORT is off and no address or socket is configured")
+	}
+	return listeners, nil
+}
+
+// listenerGroup is a set of listeners sharing a handler.
+type listenerGroup struct {
+	listeners []net.Listener
+	handler   http.Handler
+}
+
+// serve runs every group until one of its listeners fails.
+func serve(groups ...listenerGroup) error {
+	errs := make(chan error)
+	for _, g := range groups {
+		srv := &http.Server{Handler: g.handler}
+		for _, l := range g.listeners {
+			slog.Info("server listening", "network", l.Addr().Network(), "addr", l.Addr().String())
+			go func(l net.Listener) { errs <- srv.Serve(l) }(l)
+		}
+	}
+	return <-errs
+}
+
+// A separate control plane is set up with CONTROL_ADDRS (comma-separated
+// TCP addresses) and/or CONTROL_SOCKET (a Unix socket). Those listeners
+// serve everything; the others then only serve the displays: pages, local
+// content and the API calls made by the scripts injected into them.
+
+// openControlListeners opens the control plane listeners, if any.
+func openControlListeners() ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, a := range strings.Split(setting("CONTROL_ADDRS"), ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		l, err := net.Listen("tcp", a)
+		if err != nil {
+			return nil, fmt.Errorf("control: %w", err)
+		}
+		listeners = append(listeners, l)
+	}
+	if path := setting("CONTROL_SOCKET"); path != "" {
+		l, err := unixListener(path)
+		if err != nil {
+			return nil, fmt.Errorf("control socket: %w", err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// displayAPI lists the API endpoints display pages call, with the method
+// they use ("" for any).
+var displayAPI = map[string]string{
+	"/api/version":                 http.MethodGet,
+	"/api/client-config":           http.MethodGet,
+	"/api/report-height":           "",
+	"/api/heartbeat":               "",
+	"/api/analytics/beacon":        "",
+	"/api/heatmap/click":           "",
+	"/api/profile/clear-site-data": http.MethodGet,
+	"/api/reload/clear-cache":      "",
+	"/api/overlay":                 http.MethodGet,
+	"/api/viewport":                http.MethodGet,
+	"/api/config/rotation":         http.MethodGet,
+	"/api/clipboard/copied":        "",
+	"/api/clipboard/paste":         http.MethodGet,
+	"/api/input/pending":           http.MethodGet,
+	"/api/automation/pending":      http.MethodGet,
+	"/api/automation/result":       http.MethodPost,
+	"/api/page-logs/report":        http.MethodPost,
+	"/api/page-perf/report":        http.MethodPost,
+	"/api/upload/pending":          http.MethodGet,
+	"/api/upload/file":             http.MethodGet,
+	"/api/zones/view":              http.MethodGet,
+	"/api/unlock":                  http.MethodPost,
+	"/api/setup":                   "",
+}
+
+// viewerOnly turns away control requests on the display listeners.
+func viewerOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/metrics" {
+			method, ok := displayAPI[r.URL.Path]
+			if !ok || (method != "" && r.Method != method && r.Method != http.MethodHead) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestTCPAddrs(t *testing.T) {
+	tests := []struct {
+		port, addrs string
+		want        []string
+	}{
+		{"", "", []string{":1337"}},
+		{"8080", "", []string{":8080"}},
+		{"off", "", nil},
+		{"8080", "127.0.0.1:1337, [::1]:1337,,", []string{"127.0.0.1:1337", "[::1]:1337"}},
+		{"off", "10.0.5.2:8080", []string{"10.0.5.2:8080"}},
+	}
+	for _, tt := range tests {
+		t.Setenv("PORT", tt.port)
+		t.Setenv("LISTEN_ADDRS", tt.addrs)
+		if got := tcpAddrs(); !slices.Equal(got, tt.want) {
+			t.Errorf("PORT=%q LISTEN_ADDRS=%q: got %q, want %q", tt.port, tt.addrs, got, tt.want)
+		}
+	}
+}
+
+func TestOpenListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_ADDRS", "")
+	t.Setenv("LISTEN_SOCKET", "")
+	t.Setenv("PORT", "off")
+	if _, err := openListeners(); err == nil {
+		t.Error("PORT=off with nothing else: expected an error")
+	}
+
+	sock := filepath.Join(t.TempDir(), "ctrl.sock")
+	t.Setenv("LISTEN_ADDRS", "127.0.0.1:0")
+	t.Setenv("LISTEN_SOCKET", sock)
+	listeners, err := openListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var networks []string
+	for _, l := range listeners {
+		networks = append(networks, l.Addr().Network())
+		l.Close()
+	}
+	if !slices.Equal(networks, []string{"unix", "tcp"}) {
+		t.Errorf("listeners = %v", networks)
+	}
+
+	t.Setenv("LISTEN_ADDRS", "127.0.0.1:not-a-port")
+	t.Setenv("LISTEN_SOCKET", "")
+	if _, err := openListeners(); err == nil {
+		t.Error("bad LISTEN_ADDRS: expected an error")
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "ctrl.sock")
+	t.Setenv("LISTEN_SOCKET_MODE", "")
+	l, err := unixListener(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(sock); err != nil || fi.Mode().Perm() != 0660 {
+		t.Errorf("mode = %v, %v", fi.Mode().Perm(), err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})}
+	go srv.Serve(l)
+	client := &http.Client{Transport: &http.Transport{Dial: func(string, string) (net.Conn, error) {
+		return net.Dial("unix", sock)
+	}}}
+	resp, err := client.Get("http://ctrl/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("status over the socket = %d", resp.StatusCode)
+	}
+	srv.Close()
+
+	// A socket left by an earlier run is replaced; LISTEN_SOCKET_MODE applies.
+	stale, err := net.Listen("unix", filepath.Join(t.TempDir(), "stale.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalePath := stale.Addr().String()
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	t.Setenv("LISTEN_SOCKET_MODE", "600")
+	l, err = unixListener(stalePath)
+	if err != nil {
+		t.Fatalf("replacing a stale socket: %v", err)
+	}
+	if fi, err := os.Stat(stalePath); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, %v", fi.Mode().Perm(), err)
+	}
+	l.Close()
+
+	t.Setenv("LISTEN_SOCKET_MODE", "rw")
+	if _, err := unixListener(filepath.Join(t.TempDir(), "bad.sock")); err == nil {
+		t.Error("bad LISTEN_SOCKET_MODE: expected an error")
+	}
+
+	// Anything other than a socket is left alone.
+	file := filepath.Join(t.TempDir(), "file.sock")
+	os.WriteFile(file, []byte("keep"), 0644)
+	t.Setenv("LISTEN_SOCKET_MODE", "")
+	if _, err := unixListener(file); err == nil {
+		t.Error("listening over a regular file: expected an error")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "keep" {
+		t.Error("regular file was replaced")
+	}
+}
+
+func TestSystemdListenersIgnoredForOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "2")
+	if listeners, err := systemdListeners(); err != nil || listeners != nil {
+		t.Errorf("got %v, %v", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "2" {
+		t.Error("LISTEN_FDS cleared for another process")
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if listeners, err := systemdListeners(); err != nil || listeners != nil {
+		t.Errorf("got %v, %v", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_PID"); ok {
+		t.Error("LISTEN_PID not cleared")
+	}
+}
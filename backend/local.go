@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local content lives in DATA_DIR/local and is served under /local/. A
+// TargetURL (or FALLBACK_URL) of the form local://welcome.html points at it,
+// so branded content stays on screen when the network is down.
+const localScheme = "local://"
+
+func localDir() string {
+	return filepath.Join(dataDir, "local")
+}
+
+// localTarget reports whether target refers to local content and returns
+// the file path relative to the local directory.
+func localTarget(target string) (string, bool) {
+	if !strings.HasPrefix(target, localScheme) {
+		return "", false
+	}
+	return "/" + strings.TrimPrefix(target, localScheme), true
+}
+
+// localHref converts a local:// reference into a browser-loadable URL and
+// leaves anything else untouched.
+func localHref(target string) string {
+	if file, ok := localTarget(target); ok {
+		return "/local" + file
+	}
+	return target
+}
+
+func validateLocalTarget(target string) error {
+	file, _ := localTarget(target)
+	clean := path.Clean(file)
+	if clean == "/" || strings.Contains(file, "..") {
+		return errors.New("invalid local path")
+	}
+	info, err := os.Stat(filepath.Join(localDir(), filepath.FromSlash(clean)))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("local path is a directory")
+	}
+	return nil
+}
+
+// serveLocal serves a file from the local directory. HTML documents get the
+// same client-side injections as proxied pages.
+func serveLocal(w http.ResponseWriter, r *http.Request, name string) {
+	clean := path.Clean("/" + name)
+	full := filepath.Join(localDir(), filepath.FromSlash(clean))
+
+	info, err := os.Stat(full)
+	if err == nil && info.IsDir() {
+		full = filepath.Join(full, "index.html")
+	}
+
+	ext := strings.ToLower(filepath.Ext(full))
+	if ext != ".html" && ext != ".htm" {
+		http.ServeFile(w, r, full)
+		return
+	}
+
+	data, err := os.ReadFile(full)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	page := injectInventions(string(data), viewerConfig(r, applyPageRules(state.Snapshot(), r.URL.Path)))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(page))
+}
+
+func localContentHandler(w http.ResponseWriter, r *http.Request) {
+	serveLocal(w, r, strings.TrimPrefix(r.URL.Path, "/local"))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lock levels, from least to most restrictive. At "input" the displays
+// ignore touch and keyboard and remote input is refused, while navigation
+// and configuration still work. At "full" every change is refused except
+// unlocking. Reading state is never locked.
+var lockLevels = map[string]int{"none": 0, "input": 1, "full": 2}
+
+// inputEndpoints drive the page the way a person at the screen would, so
+// they are refused from the "input" level up. Every other changing request
+// is refused at "full".
+var inputEndpoints = map[string]bool{
+	"/api/input":          true,
+	"/api/clipboard":      true,
+	"/api/upload":         true,
+	"/api/macros/play":    true,
+	"/api/automation/run": true,
+	"/api/evaluate":       true,
+}
+
+// defaultUnlockSeconds is how long a temporary unlock lasts unless told
+// otherwise.
+const defaultUnlockSeconds = 300
+
+var errInvalidLock = errors.New("lock must be none, input or full")
+
+// initLock reads LOCK. The older INTERFACE_LOCKED=true means "input".
+func initLock() error {
+	level := setting("LOCK")
+	if level == "" {
+		level = "none"
+		if setting("INTERFACE_LOCKED") == "true" {
+			level = "input"
+		}
+	}
+	if _, ok := lockLevels[level]; !ok {
+		return errInvalidLock
+	}
+	state.Modify(func(c *Config) { c.Lock = level })
+	return nil
+}
+
+func parseLegacyLock(s string) (interface{}, error) {
+	if s == "true" {
+		return "input", nil
+	}
+	return "none", nil
+}
+
+// lockedFor returns the lock level at which a request is refused, or 0 if
+// it never is.
+func lockedFor(r *http.Request) int {
+	path := r.URL.Path
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions ||
+		!strings.HasPrefix(path, "/api/") || path == "/api/lock" || path == "/api/lock/unlock" {
+		return 0
+	}
+	if method, ok := displayAPI[path]; ok && (method == "" || method == r.Method) {
+		return 0
+	}
+	if inputEndpoints[path] {
+		return lockLevels["input"]
+	}
+	return lockLevels["full"]
+}
+
+// lockGuard refuses requests the current lock level doesn't allow with
+// 423 Locked.
+func lockGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		level := state.Snapshot().Lock
+		if at := lockedFor(r); at > 0 && lockLevels[level] >= at {
+			http.Error(w, "Locked ("+level+")", http.StatusLocked)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// A temporary unlock restores relockLevel at relockAt.
+var (
+	relockTimer  *time.Timer
+	relockAt     time.Time
+	relockLevel  string
+	relockReload bool
+	relockMutex  sync.Mutex
+)
+
+// SetLock changes the lock level, cancelling a pending relock. Displays
+// reload to pick up the input blocker.
+func SetLock(level string) error {
+	if _, ok := lockLevels[level]; !ok {
+		return errInvalidLock
+	}
+	relockMutex.Lock()
+	cancelRelock()
+	relockMutex.Unlock()
+	state.Update("", func(c *Config) { c.Lock = level })
+	notify(EventLock, "lock set to "+level, map[string]interface{}{"level": level})
+	return nil
+}
+
+// cancelRelock stops a pending relock. Callers must hold relockMutex.
+func cancelRelock() {
+	if relockTimer != nil {
+		relockTimer.Stop()
+		relockTimer = nil
+	}
+}
+
+// unlockFor unlocks the displays and puts the current lock back after d.
+// With reload set the displays also clear their cache on relock, so
+// nothing a visitor left behind on the page survives. Unlocking again
+// while unlocked extends the time but keeps the original level.
+func unlockFor(d time.Duration, reload bool) {
+	relockMutex.Lock()
+	defer relockMutex.Unlock()
+	level := state.Snapshot().Lock
+	if relockTimer != nil {
+		level = relockLevel
+	}
+	cancelRelock()
+	if level != "none" {
+		state.Update("", func(c *Config) { c.Lock = "none" })
+	}
+	if d <= 0 || level == "none" {
+		return
+	}
+	relockAt, relockLevel, relockReload = time.Now().Add(d), level, reload
+	relockTimer = time.AfterFunc(d, relock)
+	notify(EventLock, "unlocked until "+relockAt.Format(time.TimeOnly), map[string]interface{}{
+		"level":    "none",
+		"relockAt": relockAt.UnixMilli(),
+	})
+}
+
+func relock() {
+	relockMutex.Lock()
+	defer relockMutex.Unlock()
+	if relockTimer == nil {
+		return
+	}
+	relockTimer = nil
+	state.Update("", func(c *Config) { c.Lock = relockLevel })
+	if relockReload {
+		HardReload()
+	}
+	slog.Info("display relocked", "level", relockLevel)
+	notify(EventLock, "relocked at "+relockLevel, map[string]interface{}{"level": relockLevel})
+}
+
+// lockReport is the lock state shown in /api/status.
+func lockReport() map[string]interface{} {
+	relockMutex.Lock()
+	defer relockMutex.Unlock()
+	report := map[string]interface{}{"level": state.Snapshot().Lock}
+	if relockTimer != nil {
+		report["relockLevel"] = relockLevel
+		report["relockAt"] = relockAt.UnixMilli()
+		report["remaining"] = int(time.Until(relockAt).Seconds())
+	}
+	return report
+}
+
+// apiTemporaryUnlockHandler unlocks for ?duration= seconds (default 300),
+// then relocks; ?reload=true also hard-reloads the displays on relock.
+func apiTemporaryUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	seconds := defaultUnlockSeconds
+	if v := r.URL.Query().Get("duration"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "duration must be a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+		seconds = n
+	}
+	reload := r.URL.Query().Get("reload") == "true"
+	unlockFor(time.Duration(seconds)*time.Second, reload)
+	slog.Info("display unlocked temporarily", "seconds", seconds)
+	recordAudit("unlock", map[string]interface{}{"seconds": seconds, "reload": reload})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockReport())
+}
+
+// apiLockHandler reports (GET) or sets (POST {"level":"input"}) the lock
+// level.
+func apiLockHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := SetLock(req.Level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("lock level changed", "level", req.Level)
+		recordAudit("lock", map[string]interface{}{"level": req.Level})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockReport())
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logBufferSize = 500
+
+// logRing keeps the most recent log lines in memory so they can be served
+// to the admin UI without shelling into the container.
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+var recentLogs = &logRing{lines: make([]string, logBufferSize)}
+
+func (l *logRing) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		l.lines[l.next] = string(line)
+		l.next = (l.next + 1) % len(l.lines)
+		if l.next == 0 {
+			l.full = true
+		}
+	}
+	return len(p), nil
+}
+
+// Tail returns up to n of the most recent lines, oldest first.
+func (l *logRing) Tail(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	out := make([]string, 0, n)
+	for i := n; i > 0; i-- {
+		idx := (l.next - i + len(l.lines)) % len(l.lines)
+		out = append(out, l.lines[idx])
+	}
+	return out
+}
+
+// initLogging installs the default slog logger. LOG_LEVEL selects the
+// minimum level (debug, info, warn, error) and LOG_FORMAT=json switches
+// from text to JSON output.
+func initLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(setting("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	out := io.MultiWriter(os.Stdout, recentLogs)
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(setting("LOG_FORMAT"), "json") {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// accessLog wraps a handler and logs one line per request with its latency.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+func apiLogsTailHandler(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+	if n <= 0 {
+		n = 100
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lines": recentLogs.Tail(n),
+	})
+}
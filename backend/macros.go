@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A macro is a recorded sequence of operator input and navigation, replayed
+// with the original timing. Recording captures what is sent to /api/input
+// and every target change until it is stopped. A macro with At set
+// ("03:00") also plays every day at that time.
+type MacroStep struct {
+	// Delay is the time since the previous step in milliseconds.
+	Delay int64       `json:"delay"`
+	Input *inputEvent `json:"input,omitempty"`
+	URL   string      `json:"url,omitempty"`
+}
+
+type Macro struct {
+	Name  string      `json:"name"`
+	At    string      `json:"at,omitempty"`
+	Steps []MacroStep `json:"steps"`
+}
+
+// maxMacroDelay caps a single pause so a recording left running over
+// lunch doesn't make playback stall.
+const maxMacroDelay = time.Minute
+
+var (
+	macros      []Macro
+	macrosMutex sync.Mutex
+	macrosPath  string
+
+	// recording is the macro being recorded, if any; lastStep is when its
+	// last step was taken.
+	recording *Macro
+	lastStep  time.Time
+	playing   bool
+)
+
+func (m *Macro) validate() error {
+	if !scriptNameRe.MatchString(m.Name) {
+		return errors.New("invalid macro name")
+	}
+	if m.At != "" {
+		if _, err := parseClock(m.At); err != nil {
+			return err
+		}
+	}
+	for _, s := range m.Steps {
+		if (s.Input == nil) == (s.URL == "") {
+			return errors.New("each step needs either input or url")
+		}
+		if s.Delay < 0 {
+			return errors.New("delay must not be negative")
+		}
+	}
+	return nil
+}
+
+func initMacros() error {
+	macrosMutex.Lock()
+	defer macrosMutex.Unlock()
+	macrosPath = filepath.Join(dataDir, "macros.json")
+	macros = nil
+	recording = nil
+
+	data, err := os.ReadFile(macrosPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &macros)
+}
+
+// saveMacros writes the macros to disk. Callers must hold macrosMutex.
+func saveMacros() {
+	data, err := json.MarshalIndent(macros, "", "  ")
+	if err == nil {
+		err = os.WriteFile(macrosPath, data, 0644)
+	}
+	if err != nil {
+		slog.Error("failed to save macros", "err", err)
+	}
+}
+
+// upsertMacro stores m. Callers must hold macrosMutex.
+func upsertMacro(m Macro) {
+	for i := range macros {
+		if macros[i].Name == m.Name {
+			macros[i] = m
+			saveMacros()
+			return
+		}
+	}
+	macros = append(macros, m)
+	saveMacros()
+}
+
+func findMacro(name string) (Macro, bool) {
+	macrosMutex.Lock()
+	defer macrosMutex.Unlock()
+	for _, m := range macros {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Macro{}, false
+}
+
+// recordMacroStep adds a step to the macro being recorded, if any.
+func recordMacroStep(step MacroStep) {
+	macrosMutex.Lock()
+	defer macrosMutex.Unlock()
+	if recording == nil || playing {
+		return
+	}
+	now := time.Now()
+	step.Delay = min(now.Sub(lastStep), maxMacroDelay).Milliseconds()
+	lastStep = now
+	recording.Steps = append(recording.Steps, step)
+}
+
+// initMacroEvents records target changes into the macro being recorded
+// and plays scheduled macros.
+func initMacroEvents() {
+	changes := subscribeEvents(EventTargetChanged)
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for {
+			select {
+			case e := <-changes:
+				if u, ok := e.Data["to"].(string); ok {
+					recordMacroStep(MacroStep{URL: u})
+				}
+			case now := <-ticker.C:
+				if inMaintenance() {
+					continue
+				}
+				clock := now.Format("15:04")
+				macrosMutex.Lock()
+				var due []string
+				for _, m := range macros {
+					if m.At == clock {
+						due = append(due, m.Name)
+					}
+				}
+				macrosMutex.Unlock()
+				for _, name := range due {
+					if err := playMacro(name); err != nil {
+						slog.Error("scheduled macro failed", "name", name, "err", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// playMacro replays the named macro in the background.
+func playMacro(name string) error {
+	m, ok := findMacro(name)
+	if !ok {
+		return errors.New("macro not found")
+	}
+	macrosMutex.Lock()
+	if playing {
+		macrosMutex.Unlock()
+		return errors.New("another macro is playing")
+	}
+	playing = true
+	macrosMutex.Unlock()
+
+	slog.Info("playing macro", "name", name, "steps", len(m.Steps))
+	go func() {
+		defer func() {
+			macrosMutex.Lock()
+			playing = false
+			macrosMutex.Unlock()
+		}()
+		for _, s := range m.Steps {
+			time.Sleep(time.Duration(s.Delay) * time.Millisecond)
+			if s.Input != nil {
+				queueInput(*s.Input)
+				continue
+			}
+			u, err := normalizeTargetURL(s.URL)
+			if err == nil {
+				err = checkTarget(u)
+			}
+			if err != nil {
+				slog.Error("macro stopped", "name", name, "err", err)
+				return
+			}
+			switchTarget(u)
+		}
+	}()
+	return nil
+}
+
+// apiMacrosHandler lists macros (GET), creates or replaces one (POST with
+// a Macro body) or removes one (DELETE ?name=).
+func apiMacrosHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		macrosMutex.Lock()
+		list := append([]Macro{}, macros...)
+		macrosMutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"macros": list})
+	case http.MethodPost:
+		var m Macro
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := m.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		macrosMutex.Lock()
+		upsertMacro(m)
+		macrosMutex.Unlock()
+		recordAudit("macro_set", map[string]interface{}{"name": m.Name})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		macrosMutex.Lock()
+		found := false
+		for i := range macros {
+			if macros[i].Name == name {
+				macros = append(macros[:i], macros[i+1:]...)
+				saveMacros()
+				found = true
+				break
+			}
+		}
+		macrosMutex.Unlock()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		recordAudit("macro_delete", map[string]interface{}{"name": name})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiMacroRecordHandler starts (start=true) or stops recording. Starting
+// again discards the recording in progress; stopping saves it.
+func apiMacroRecordHandler(start bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		macrosMutex.Lock()
+		defer macrosMutex.Unlock()
+		if start {
+			m := Macro{Name: r.URL.Query().Get("name"), Steps: []MacroStep{}}
+			if err := m.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			recording = &m
+			lastStep = time.Now()
+			slog.Info("macro recording started", "name", m.Name)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"recording": m.Name})
+			return
+		}
+		if recording == nil {
+			http.Error(w, "Not recording", http.StatusConflict)
+			return
+		}
+		m := *recording
+		recording = nil
+		if existing, ok := macroAt(m.Name); ok {
+			m.At = existing
+		}
+		upsertMacro(m)
+		slog.Info("macro recorded", "name", m.Name, "steps", len(m.Steps))
+		recordAudit("macro_recorded", map[string]interface{}{"name": m.Name, "steps": len(m.Steps)})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+// macroAt returns the schedule of an existing macro. Callers must hold
+// macrosMutex.
+func macroAt(name string) (string, bool) {
+	for _, m := range macros {
+		if m.Name == name {
+			return m.At, true
+		}
+	}
+	return "", false
+}
+
+// apiMacroPlayHandler replays ?name= on the displays.
+func apiMacroPlayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if err := playMacro(name); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	recordAudit("macro_play", map[string]interface{}{"name": name})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"playing": name})
+}
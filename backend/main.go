@@ -2,27 +2,279 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 )
 
 func main() {
-	// 1. Initialize Config
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		os.Exit(runDiscover(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "hash-pin" {
+		os.Exit(runHashPIN(os.Args[2:]))
+	}
+	if err := initSettings(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	initLogging()
+
+	if err := initServices(); err != nil {
+		slog.Error("failed to initialize", "err", err)
+		os.Exit(1)
+	}
+	initWebhooks()
+	initAuditEvents()
+	initHistoryEvents()
+	initConfigVersionEvents()
+	watchSettingsFile()
+	initConfigSync()
+	initWatchdog()
+	initClockCheck()
+	initThemeSchedule()
+	initNightSchedule()
+	initPowerSchedule()
+	initMacroEvents()
+	initAutomationEvents()
+
+	listeners, err := openListeners()
+	if err != nil {
+		slog.Error("failed to listen", "err", err)
+		os.Exit(1)
+	}
+	control, err := openControlListeners()
+	if err != nil {
+		slog.Error("failed to listen", "err", err)
+		os.Exit(1)
+	}
+	advertiseMDNS(listeners)
+	router := newRouter()
+	handler := func(h http.Handler) http.Handler {
+		return forwarded(accessLog(withBasePath(cors(trackClients(h)))))
+	}
+	var viewer http.Handler = router
+	if len(control) > 0 {
+		viewer = viewerOnly(router)
+	}
+	if err := serve(listenerGroup{listeners, handler(viewer)}, listenerGroup{control, handler(router)}); err != nil {
+		slog.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
+}
+
+// initServices loads configuration and persisted state. Background loops
+// are started separately by main.
+func initServices() error {
 	if err := initConfig(); err != nil {
-		log.Fatalf("Failed to initialize config: %v", err)
+		return fmt.Errorf("config: %w", err)
+	}
+	slog.Info("configuration loaded")
+	if err := initProfiles(); err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	if err := initIngress(); err != nil {
+		return err
+	}
+	if err := initNetGuard(); err != nil {
+		return fmt.Errorf("network guard: %w", err)
+	}
+	if err := initOverlays(); err != nil {
+		slog.Warn("failed to load overlays", "err", err)
+	}
+	if err := initBroadcast(); err != nil {
+		slog.Warn("failed to load broadcast", "err", err)
+	}
+	if err := initMaintenance(); err != nil {
+		slog.Warn("failed to load maintenance mode", "err", err)
+	}
+	initSetup()
+	if err := initAudit(); err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	if err := initBandwidth(); err != nil {
+		return fmt.Errorf("invalid bandwidth limit: %w", err)
+	}
+	if err := initCustomCSS(); err != nil {
+		return fmt.Errorf("custom CSS: %w", err)
+	}
+	if err := initEmulation(); err != nil {
+		return fmt.Errorf("emulation: %w", err)
+	}
+	if err := initDevice(); err != nil {
+		return fmt.Errorf("device emulation: %w", err)
+	}
+	if err := initResolution(); err != nil {
+		return fmt.Errorf("resolution: %w", err)
+	}
+	if err := initIdentity(); err != nil {
+		return fmt.Errorf("identity: %w", err)
+	}
+	if err := initRotation(); err != nil {
+		return fmt.Errorf("invalid ROTATION: %w", err)
+	}
+	if err := initTheme(); err != nil {
+		return fmt.Errorf("theme: %w", err)
+	}
+	if err := initDisplayFilters(); err != nil {
+		return fmt.Errorf("display filters: %w", err)
 	}
-	log.Println("Configuration loaded from environment.")
+	if err := initLock(); err != nil {
+		return err
+	}
+	if err := initPower(); err != nil {
+		return fmt.Errorf("power: %w", err)
+	}
+	if err := initScripts(); err != nil {
+		return fmt.Errorf("custom scripts: %w", err)
+	}
+	if err := initPageRules(); err != nil {
+		return fmt.Errorf("page rules: %w", err)
+	}
+	if err := initConfigVersions(); err != nil {
+		slog.Warn("failed to load config versions", "err", err)
+	}
+	if err := initBookmarks(); err != nil {
+		return fmt.Errorf("bookmarks: %w", err)
+	}
+	if err := initMemoryGuard(); err != nil {
+		return err
+	}
+	if err := initEvaluate(); err != nil {
+		return fmt.Errorf("evaluate allowlist: %w", err)
+	}
+	if err := initMacros(); err != nil {
+		return fmt.Errorf("macros: %w", err)
+	}
+	if err := initHistory(); err != nil {
+		slog.Warn("failed to load history", "err", err)
+	}
+	return nil
+}
 
-	// 2. Setup Router
+func newRouter() http.Handler {
 	mux := http.NewServeMux()
 
 	// API Routes (keeping internal coordination ones)
 	mux.HandleFunc("/api/report-height", apiReportHeightHandler)
 	mux.HandleFunc("/api/version", apiVersionHandler)
+	mux.HandleFunc("/api/status", apiStatusHandler)
+	mux.HandleFunc("/api/client-config", apiClientConfigHandler)
+	mux.HandleFunc("/api/reload", requireAdminIfConfigured(apiReloadHandler))
+	mux.HandleFunc("/api/reload/clear-cache", apiReloadClearCacheHandler)
+	mux.HandleFunc("/api/profile/clear-cookies", requireAdminIfConfigured(profileClearHandler("cookies")))
+	mux.HandleFunc("/api/profile/clear-storage", requireAdminIfConfigured(profileClearHandler("storage")))
+	mux.HandleFunc("/api/profile/clear-cache", requireAdminIfConfigured(profileClearHandler("cache")))
+	mux.HandleFunc("/api/profile/wipe", requireAdminIfConfigured(profileClearHandler("cookies", "storage", "cache")))
+	mux.HandleFunc("/api/profile/clear-site-data", apiProfileClearSiteDataHandler)
+	mux.HandleFunc("/api/config", requireAdminIfConfigured(apiConfigHandler))
+	mux.HandleFunc("/api/config/effective", requireAdminIfConfigured(apiConfigEffectiveHandler))
+	mux.HandleFunc("/api/config/versions", requireAdminIfConfigured(apiConfigVersionsHandler))
+	mux.HandleFunc("/api/config/rollback", requireAdminIfConfigured(apiConfigRollbackHandler))
+	mux.HandleFunc("/api/config/sync", requireAdminIfConfigured(apiConfigSyncHandler))
+	mux.HandleFunc("/api/setup", apiSetupHandler)
+	mux.HandleFunc("/api/backup", requireAdmin(apiBackupHandler))
+	mux.HandleFunc("/api/restore", requireAdmin(apiRestoreHandler))
+	mux.HandleFunc("/api/config/emulation", requireAdminIfConfigured(apiConfigEmulationHandler))
+	mux.HandleFunc("/api/config/device", requireAdminIfConfigured(apiConfigDeviceHandler))
+	mux.HandleFunc("/api/config/resolution", requireAdminIfConfigured(apiConfigResolutionHandler))
+	mux.HandleFunc("/api/discover", requireAdminIfConfigured(apiDiscoverHandler))
+	mux.HandleFunc("/api/config/identity", requireAdminIfConfigured(apiConfigIdentityHandler))
+	mux.HandleFunc("/api/config/rotation", apiConfigRotationHandler)
+	mux.HandleFunc("/api/config/theme", requireAdminIfConfigured(apiConfigThemeHandler))
+	mux.HandleFunc("/api/config/display-filters", requireAdminIfConfigured(apiConfigDisplayFiltersHandler))
+	mux.HandleFunc("/api/config/profile", requireAdminIfConfigured(apiConfigProfileHandler))
+	mux.HandleFunc("/api/config/url", requireAdminIfConfigured(apiConfigURLHandler))
+	mux.HandleFunc("/api/config/capture", requireAdminIfConfigured(apiConfigCaptureHandler))
+	mux.HandleFunc("/api/config/css", requireAdminIfConfigured(apiConfigCSSHandler))
+	mux.HandleFunc("/api/config/autoscroll", requireAdminIfConfigured(apiConfigAutoscrollHandler))
+	mux.HandleFunc("/api/config/scrollsequence", requireAdminIfConfigured(apiConfigScrollSequenceHandler))
+	mux.HandleFunc("/api/pagerules", requireAdminIfConfigured(apiPageRulesHandler))
+	mux.HandleFunc("/api/bookmarks", requireAdminIfConfigured(apiBookmarksHandler))
+	mux.HandleFunc("/api/bookmarks/open", requireAdminIfConfigured(apiBookmarkOpenHandler))
+	mux.HandleFunc("/api/scripts", requireAdmin(apiScriptsHandler))
+	mux.HandleFunc("/api/overlay", apiOverlayHandler)
+	mux.HandleFunc("/api/overlay/show", requireAdminIfConfigured(overlayVisibilityHandler(true)))
+	mux.HandleFunc("/api/overlay/hide", requireAdminIfConfigured(overlayVisibilityHandler(false)))
+	mux.HandleFunc("/api/clients", requireAdmin(apiClientsHandler))
+	mux.HandleFunc("/api/clients/disconnect", requireAdmin(clientDisconnectHandler(true)))
+	mux.HandleFunc("/api/clients/reconnect", requireAdmin(clientDisconnectHandler(false)))
+	mux.HandleFunc("/api/clients/readonly", requireAdmin(apiClientReadOnlyHandler))
+	mux.HandleFunc("/api/history", requireAdminIfConfigured(apiHistoryHandler))
+	mux.HandleFunc("/api/history/stats", requireAdminIfConfigured(apiHistoryStatsHandler))
+	mux.HandleFunc("/api/history/favorite", requireAdminIfConfigured(apiHistoryFavoriteHandler))
+	mux.HandleFunc("/api/history/open", requireAdminIfConfigured(apiHistoryOpenHandler))
+	mux.HandleFunc("/api/audit", requireAdmin(apiAuditHandler))
+	mux.HandleFunc("/api/audit/export", requireAdmin(apiAuditExportHandler))
+	mux.HandleFunc("/api/audit/verify", requireAdmin(apiAuditVerifyHandler))
+	mux.HandleFunc("/api/broadcast", apiBroadcastHandler)
+	mux.HandleFunc("/api/clipboard", requireAdminIfConfigured(apiClipboardHandler))
+	mux.HandleFunc("/api/clipboard/copied", apiClipboardCopiedHandler)
+	mux.HandleFunc("/api/clipboard/paste", apiClipboardPasteHandler)
+	mux.HandleFunc("/api/input", requireAdminIfConfigured(apiInputHandler))
+	mux.HandleFunc("/api/input/pending", apiInputPendingHandler)
+	mux.HandleFunc("/api/macros", requireAdminIfConfigured(apiMacrosHandler))
+	mux.HandleFunc("/api/macros/record/start", requireAdminIfConfigured(apiMacroRecordHandler(true)))
+	mux.HandleFunc("/api/macros/record/stop", requireAdminIfConfigured(apiMacroRecordHandler(false)))
+	mux.HandleFunc("/api/macros/play", requireAdminIfConfigured(apiMacroPlayHandler))
+	mux.HandleFunc("/api/automation", requireAdminIfConfigured(apiAutomationHandler))
+	mux.HandleFunc("/api/automation/run", requireAdminIfConfigured(apiAutomationRunHandler))
+	mux.HandleFunc("/api/automation/pending", apiAutomationPendingHandler)
+	mux.HandleFunc("/api/automation/result", apiAutomationResultHandler)
+	mux.HandleFunc("/api/evaluate", requireAdmin(apiEvaluateHandler))
+	mux.HandleFunc("/api/page-logs", requireAdminIfConfigured(apiPageLogsHandler))
+	mux.HandleFunc("/api/page-logs/report", apiPageLogsReportHandler)
+	mux.HandleFunc("/api/page-perf", requireAdminIfConfigured(apiPagePerfHandler))
+	mux.HandleFunc("/api/page-perf/report", apiPagePerfReportHandler)
+	mux.HandleFunc("/api/dom/query", requireAdminIfConfigured(apiDOMQueryHandler))
+	mux.HandleFunc("/api/page/title", requireAdminIfConfigured(apiPageHandler("title")))
+	mux.HandleFunc("/api/page/url", requireAdminIfConfigured(apiPageHandler("url")))
+	mux.HandleFunc("/api/page/metrics", requireAdminIfConfigured(apiPageHandler()))
+	mux.HandleFunc("/api/events", requireAdminIfConfigured(apiEventsHandler))
+	mux.HandleFunc("/api/mobile/summary", requireAdminIfConfigured(apiMobileSummaryHandler))
+	mux.HandleFunc("/api/mobile/batch", requireAdminIfConfigured(apiMobileBatchHandler))
+	mux.HandleFunc("/api/proxy/rewrites", apiProxyRewritesHandler)
+	mux.HandleFunc("/api/upload", requireAdminIfConfigured(apiUploadHandler))
+	mux.HandleFunc("/api/upload/pending", apiUploadPendingHandler)
+	mux.HandleFunc("/api/upload/file", apiUploadFileHandler)
+	mux.HandleFunc("/api/downloads", requireAdminIfConfigured(apiDownloadsHandler))
+	mux.HandleFunc("/api/viewport", apiViewportHandler)
+	mux.HandleFunc("/api/zones", apiZonesHandler)
+	mux.HandleFunc("/api/zones/view", apiZonesViewHandler)
+	mux.HandleFunc("/api/logs/tail", requireAdminIfConfigured(apiLogsTailHandler))
+	mux.HandleFunc("/api/analytics", requireAdminIfConfigured(apiAnalyticsHandler))
+	mux.HandleFunc("/api/analytics/beacon", apiAnalyticsBeaconHandler)
+	mux.HandleFunc("/api/heatmap", requireAdminIfConfigured(apiHeatmapHandler))
+	mux.HandleFunc("/api/heatmap.png", requireAdminIfConfigured(apiHeatmapImageHandler))
+	mux.HandleFunc("/api/heatmap/click", apiHeatmapClickHandler)
+	mux.HandleFunc("/api/heartbeat", apiHeartbeatHandler)
+	mux.HandleFunc("/api/watchdog", apiWatchdogHandler)
+	mux.HandleFunc("/api/device/actions", requireAdmin(apiDeviceActionsHandler))
+	mux.HandleFunc("/api/device/reboot", requireAdmin(apiDeviceRebootHandler))
+	mux.HandleFunc("/api/lock", requireAdminIfConfigured(apiLockHandler))
+	mux.HandleFunc("/api/lock/unlock", requireAdminIfConfigured(apiTemporaryUnlockHandler))
+	mux.HandleFunc("/api/unlock", apiUnlockHandler)
+	mux.HandleFunc("/api/maintenance", apiMaintenanceStatusHandler)
+	mux.HandleFunc("/api/maintenance/on", requireAdminIfConfigured(apiMaintenanceHandler(true)))
+	mux.HandleFunc("/api/maintenance/off", requireAdminIfConfigured(apiMaintenanceHandler(false)))
+	mux.HandleFunc("/api/power/status", apiPowerStatusHandler)
+	mux.HandleFunc("/api/power/on", requireAdminIfConfigured(apiPowerHandler("on")))
+	mux.HandleFunc("/api/power/off", requireAdminIfConfigured(apiPowerHandler("off")))
+	mux.HandleFunc("/api/power/auto", requireAdminIfConfigured(apiPowerHandler("auto")))
+	mux.HandleFunc("/api/webhooks/test", requireAdmin(apiWebhooksTestHandler))
+
+	// Probes
+	mux.HandleFunc("/metrics", requireAdminIfConfigured(metricsHandler))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+
+	// Local content
+	mux.HandleFunc("/local/", rotated(throttle(localContentHandler)))
 
 	// Proxy Handler
-	proxy := newProxyHandler()
+	proxy := rotated(throttle(newProxyHandler()))
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
@@ -41,15 +293,7 @@ func main() {
 		proxy(w, r)
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "1337"
-	}
-
-	log.Printf("Server listening on :%s", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		log.Fatal(err)
-	}
+	return lockGuard(mux)
 }
 
 func apiReportHeightHandler(w http.ResponseWriter, r *http.Request) {
@@ -61,10 +305,53 @@ func apiReportHeightHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// apiVersionHandler reports the config version. Clients pass the version
+// they rendered as ?since= and get back whether they need to reload.
 func apiVersionHandler(w http.ResponseWriter, r *http.Request) {
-	config := GetConfig()
+	config := state.Snapshot()
+	resp := map[string]interface{}{
+		"lastModified": config.LastModified,
+	}
+	if since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64); err == nil {
+		resp["changed"] = config.LastModified > since
+		resp["reload"] = config.ReloadVersion > since
+		resp["hard"] = config.HardReloadVersion > since
+		if clear := pendingSiteDataClear(since); len(clear) > 0 {
+			resp["clear"] = clear
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// apiClientConfigHandler returns the settings a display on ?path= should
+// use, for applying soft changes without a reload.
+func apiClientConfigHandler(w http.ResponseWriter, r *http.Request) {
+	config := applyPageRules(state.Snapshot(), r.URL.Query().Get("path"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"config": clientConfig(config),
+		"css":    customCSSText(config),
+	})
+}
+
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	config := state.Snapshot()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
+		"identity":     config.Identity,
+		"targetUrl":    config.TargetURL,
 		"lastModified": config.LastModified,
+		"startTime":    startTime,
+		"lastPageLoad": getLastPageLoad(),
+		"clock":        clockReport(),
+		"bandwidth":    bandwidthReport(),
+		"pageErrors":   pageErrorCount(),
+		"memoryGuard":  memoryGuardReport(),
+		"lock":         lockReport(),
+		"maintenance":  GetMaintenance(),
+		"configSync":   configSyncReport(),
 	})
 }
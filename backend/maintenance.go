@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Maintenance mode shows a placeholder page on every display instead of the
+// target. The configuration is left alone, so turning it off brings back
+// exactly what was shown before. Scheduled macros and automations are held
+// while it is on. DATA_DIR/maintenance.html replaces the built-in page.
+type Maintenance struct {
+	Message string `json:"message,omitempty"`
+	Since   int64  `json:"since"`
+}
+
+var (
+	maintenance      *Maintenance
+	maintenanceMutex sync.RWMutex
+)
+
+func maintenancePath() string {
+	return filepath.Join(dataDir, "maintenance.json")
+}
+
+func initMaintenance() error {
+	maintenanceMutex.Lock()
+	maintenance = nil
+	maintenanceMutex.Unlock()
+
+	data, err := os.ReadFile(maintenancePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m Maintenance
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	maintenanceMutex.Lock()
+	maintenance = &m
+	maintenanceMutex.Unlock()
+	return nil
+}
+
+func GetMaintenance() *Maintenance {
+	maintenanceMutex.RLock()
+	defer maintenanceMutex.RUnlock()
+	if maintenance == nil {
+		return nil
+	}
+	m := *maintenance
+	return &m
+}
+
+func inMaintenance() bool {
+	return GetMaintenance() != nil
+}
+
+func setMaintenance(m *Maintenance) error {
+	maintenanceMutex.Lock()
+	maintenance = m
+	maintenanceMutex.Unlock()
+	touchConfig()
+
+	if m == nil {
+		err := os.Remove(maintenancePath())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(maintenancePath(), data, 0644)
+}
+
+var maintenanceTemplate = template.Must(template.New("maintenance").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} – Under maintenance</title>
+<style>
+html,body{margin:0;height:100%;overflow:hidden;background:#1b1f24;color:#eee;font-family:sans-serif}
+body{display:flex;flex-direction:column;align-items:center;justify-content:center;text-align:center}
+h1{font-weight:300;font-size:4vw;margin:0 0 .5em}
+p{font-size:1.6vw;opacity:.7;white-space:pre-wrap}
+</style>
+</head>
+<body>
+<h1>Under maintenance</h1>
+<p>{{if .Message}}{{.Message}}{{else}}This screen will be back shortly.{{end}}</p>
+</body>
+</html>
+`))
+
+// maintenanceReloadScript sends the display back to its page once
+// maintenance ends.
+const maintenanceReloadScript = `<script>
+const initialVersion = %d;
+setInterval(() => {
+    fetch('/api/version').then(res => res.json()).then(data => {
+        if (data.lastModified > initialVersion) window.location.reload();
+    }).catch(() => {});
+}, 2000);
+</script>
+`
+
+func serveMaintenance(w http.ResponseWriter, m *Maintenance) {
+	page, err := os.ReadFile(filepath.Join(dataDir, "maintenance.html"))
+	if err != nil {
+		var buf bytes.Buffer
+		maintenanceTemplate.Execute(&buf, map[string]interface{}{
+			"Name":    state.Snapshot().Identity.Name,
+			"Message": m.Message,
+		})
+		page = buf.Bytes()
+	}
+	script := fmt.Sprintf(maintenanceReloadScript, state.Snapshot().LastModified)
+	if i := bytes.LastIndex(page, []byte("</body>")); i >= 0 {
+		page = append(page[:i:i], append([]byte(script), page[i:]...)...)
+	} else {
+		page = append(page, script...)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(page)
+}
+
+// apiMaintenanceHandler turns maintenance mode on (POST
+// /api/maintenance/on, optionally {"message":"..."}) or off (POST
+// /api/maintenance/off). GET /api/maintenance reports it.
+func apiMaintenanceHandler(on bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var m *Maintenance
+		if on {
+			m = &Maintenance{}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(m); err != nil {
+					http.Error(w, "Invalid JSON", http.StatusBadRequest)
+					return
+				}
+			}
+			m.Since = time.Now().UnixMilli()
+		}
+		if err := setMaintenance(m); err != nil {
+			slog.Error("failed to save maintenance mode", "err", err)
+		}
+		if on {
+			slog.Warn("maintenance mode on")
+			recordAudit("maintenance_on", map[string]interface{}{"message": m.Message})
+		} else {
+			slog.Info("maintenance mode off")
+			recordAudit("maintenance_off", nil)
+		}
+		apiMaintenanceStatusHandler(w, r)
+	}
+}
+
+func apiMaintenanceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"maintenance": GetMaintenance()})
+}
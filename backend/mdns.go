@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Every instance advertises itself on the LAN with multicast DNS as a
+// _ctrl._tcp service (DNS-SD, RFC 6763) named after its identity, with
+// the location and tags in its TXT record. /api/discover and the
+// "discover" command list the instances that answer. MDNS=off turns the
+// advertising off.
+const (
+	mdnsService = "_ctrl._tcp.local."
+	mdnsTTL     = 120
+
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+	dnsClassIN = 1
+	// dnsClassTopBit is the cache-flush bit in records and the
+	// unicast-response bit in questions.
+	dnsClassTopBit  = 0x8000
+	dnsFlagResponse = 0x8400
+
+	defaultDiscoverTimeout = 2 * time.Second
+	maxDiscoverTimeout     = 10 * time.Second
+)
+
+var (
+	mdnsGroup   = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	errDNSShort = errors.New("dns: message too short")
+)
+
+type dnsQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// dnsRecord is a resource record. Names in Data may be compressed, so the
+// message and Data's offset in it are kept for decoding.
+type dnsRecord struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+
+	msg []byte
+	off int
+}
+
+type dnsMessage struct {
+	ID        uint16
+	Flags     uint16
+	Questions []dnsQuestion
+	Records   []dnsRecord
+}
+
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// readDNSName decodes the possibly compressed name at off and returns it
+// with the offset just past it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end, jumps := -1, 0
+	for {
+		if off >= len(msg) {
+			return "", 0, errDNSShort
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errDNSShort
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("dns: compression loop")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+func parseDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < 12 {
+		return nil, errDNSShort
+	}
+	m := &dnsMessage{ID: binary.BigEndian.Uint16(msg), Flags: binary.BigEndian.Uint16(msg[2:])}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rr := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errDNSShort
+		}
+		m.Questions = append(m.Questions, dnsQuestion{name, binary.BigEndian.Uint16(msg[next:]), binary.BigEndian.Uint16(msg[next+2:])})
+		off = next + 4
+	}
+	for i := 0; i < rr; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errDNSShort
+		}
+		size := int(binary.BigEndian.Uint16(msg[next+8:]))
+		if next+10+size > len(msg) {
+			return nil, errDNSShort
+		}
+		m.Records = append(m.Records, dnsRecord{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(msg[next:]),
+			Class: binary.BigEndian.Uint16(msg[next+2:]),
+			TTL:   binary.BigEndian.Uint32(msg[next+4:]),
+			Data:  msg[next+10 : next+10+size],
+			msg:   msg,
+			off:   next + 10,
+		})
+		off = next + 10 + size
+	}
+	return m, nil
+}
+
+// pack encodes m without name compression. Every record goes in the
+// answer section.
+func (m *dnsMessage) pack() []byte {
+	b := binary.BigEndian.AppendUint16(nil, m.ID)
+	b = binary.BigEndian.AppendUint16(b, m.Flags)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.Questions)))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.Records)))
+	b = append(b, 0, 0, 0, 0)
+	for _, q := range m.Questions {
+		b = appendDNSName(b, q.Name)
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+	for _, r := range m.Records {
+		b = appendDNSName(b, r.Name)
+		b = binary.BigEndian.AppendUint16(b, r.Type)
+		b = binary.BigEndian.AppendUint16(b, r.Class)
+		b = binary.BigEndian.AppendUint32(b, r.TTL)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(r.Data)))
+		b = append(b, r.Data...)
+	}
+	return b
+}
+
+// mdnsNames returns this instance's service instance name and host name.
+func mdnsNames() (string, string) {
+	instance := strings.NewReplacer(".", "-").Replace(deviceID())
+	host, _ := os.Hostname()
+	host, _, _ = strings.Cut(host, ".")
+	if host == "" {
+		host = "ctrl"
+	}
+	return instance + "." + mdnsService, host + ".local."
+}
+
+// mdnsRecords describes this instance: the service pointer, where to reach
+// it, its identity and its IPv4 addresses.
+func mdnsRecords(port int) []dnsRecord {
+	instance, host := mdnsNames()
+	id := state.Snapshot().Identity
+	unique := uint16(dnsClassIN | dnsClassTopBit)
+
+	srv := binary.BigEndian.AppendUint16([]byte{0, 0, 0, 0}, uint16(port))
+	var txt []byte
+	for _, kv := range []string{"name=" + id.Name, "location=" + id.Location, "tags=" + strings.Join(id.Tags, ",")} {
+		if len(kv) > 255 {
+			kv = kv[:255]
+		}
+		txt = append(append(txt, byte(len(kv))), kv...)
+	}
+	records := []dnsRecord{
+		{Name: mdnsService, Type: dnsTypePTR, Class: dnsClassIN, TTL: mdnsTTL, Data: appendDNSName(nil, instance)},
+		{Name: instance, Type: dnsTypeSRV, Class: unique, TTL: mdnsTTL, Data: appendDNSName(srv, host)},
+		{Name: instance, Type: dnsTypeTXT, Class: unique, TTL: mdnsTTL, Data: txt},
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			records = append(records, dnsRecord{Name: host, Type: dnsTypeA, Class: unique, TTL: mdnsTTL, Data: ipnet.IP.To4()})
+		}
+	}
+	return records
+}
+
+// listenerPort is the port of the first TCP listener, or 0.
+func listenerPort(listeners []net.Listener) int {
+	for _, l := range listeners {
+		if addr, ok := l.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
+	return 0
+}
+
+// advertiseMDNS answers queries for this instance and announces it at
+// startup. It only logs a warning when multicast isn't available, as in
+// most container networks.
+func advertiseMDNS(listeners []net.Listener) {
+	port := listenerPort(listeners)
+	if setting("MDNS") == "off" || port == 0 {
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		slog.Warn("mDNS advertising unavailable", "err", err)
+		return
+	}
+	instance, _ := mdnsNames()
+	slog.Info("advertising over mDNS", "instance", instance, "port", port)
+	go func() {
+		for i := 0; i < 2; i++ {
+			announce := dnsMessage{Flags: dnsFlagResponse, Records: mdnsRecords(port)}
+			conn.WriteToUDP(announce.pack(), mdnsGroup)
+			time.Sleep(time.Second)
+		}
+	}()
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				slog.Warn("mDNS responder stopped", "err", err)
+				return
+			}
+			if resp, unicast := mdnsAnswer(buf[:n], src, port); resp != nil {
+				if unicast {
+					conn.WriteToUDP(resp, src)
+				} else {
+					conn.WriteToUDP(resp, mdnsGroup)
+				}
+			}
+		}
+	}()
+}
+
+// mdnsAnswer builds the response to a query about this instance, if it is
+// one. Queries from a port other than 5353 (RFC 6762 section 6.7) or with
+// the unicast-response bit are answered directly.
+func mdnsAnswer(packet []byte, src *net.UDPAddr, port int) ([]byte, bool) {
+	query, err := parseDNSMessage(packet)
+	if err != nil || query.Flags&0x8000 != 0 {
+		return nil, false
+	}
+	instance, host := mdnsNames()
+	wanted, unicast := false, src.Port != mdnsGroup.Port
+	for _, q := range query.Questions {
+		name := strings.ToLower(q.Name)
+		switch {
+		case name == mdnsService && (q.Type == dnsTypePTR || q.Type == dnsTypeANY),
+			name == strings.ToLower(instance) && (q.Type == dnsTypeSRV || q.Type == dnsTypeTXT || q.Type == dnsTypeANY),
+			name == strings.ToLower(host) && (q.Type == dnsTypeA || q.Type == dnsTypeANY):
+			wanted = true
+			unicast = unicast || q.Class&dnsClassTopBit != 0
+		}
+	}
+	if !wanted {
+		return nil, false
+	}
+	resp := dnsMessage{Flags: dnsFlagResponse, Records: mdnsRecords(port)}
+	if src.Port != mdnsGroup.Port {
+		resp.ID = query.ID
+		resp.Questions = query.Questions
+	}
+	return resp.pack(), unicast
+}
+
+// MDNSInstance is an instance found on the LAN.
+type MDNSInstance struct {
+	Instance  string   `json:"instance"`
+	Name      string   `json:"name"`
+	Location  string   `json:"location,omitempty"`
+	Tags      []string `json:"tags"`
+	Host      string   `json:"host"`
+	Port      int      `json:"port"`
+	Addresses []string `json:"addresses"`
+}
+
+// discoverMDNS asks the LAN for _ctrl._tcp instances and collects the
+// answers that arrive within timeout.
+func discoverMDNS(timeout time.Duration) ([]MDNSInstance, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := dnsMessage{Questions: []dnsQuestion{{mdnsService, dnsTypePTR, dnsClassIN}}}
+	if _, err := conn.WriteToUDP(query.pack(), mdnsGroup); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	found := map[string]*MDNSInstance{}
+	hosts := map[string][]string{}
+	senders := map[string]string{}
+	instance := func(name string) *MDNSInstance {
+		key := strings.ToLower(name)
+		if found[key] == nil {
+			found[key] = &MDNSInstance{Instance: name, Tags: []string{}, Addresses: []string{}}
+		}
+		return found[key]
+	}
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		msg, err := parseDNSMessage(append([]byte(nil), buf[:n]...))
+		if err != nil {
+			continue
+		}
+		for _, r := range msg.Records {
+			switch r.Type {
+			case dnsTypePTR:
+				if strings.EqualFold(r.Name, mdnsService) {
+					if target, _, err := readDNSName(r.msg, r.off); err == nil {
+						instance(target)
+						senders[strings.ToLower(target)] = src.IP.String()
+					}
+				}
+			case dnsTypeSRV:
+				if len(r.Data) < 7 || !strings.HasSuffix(strings.ToLower(r.Name), mdnsService) {
+					continue
+				}
+				inst := instance(r.Name)
+				inst.Port = int(binary.BigEndian.Uint16(r.Data[4:]))
+				inst.Host, _, _ = readDNSName(r.msg, r.off+6)
+			case dnsTypeTXT:
+				if !strings.HasSuffix(strings.ToLower(r.Name), mdnsService) {
+					continue
+				}
+				inst := instance(r.Name)
+				for data := r.Data; len(data) > 0 && int(data[0]) < len(data); data = data[1+int(data[0]):] {
+					k, v, _ := strings.Cut(string(data[1:1+int(data[0])]), "=")
+					switch k {
+					case "name":
+						inst.Name = v
+					case "location":
+						inst.Location = v
+					case "tags":
+						if v != "" {
+							inst.Tags = strings.Split(v, ",")
+						}
+					}
+				}
+			case dnsTypeA:
+				if len(r.Data) == 4 {
+					key := strings.ToLower(r.Name)
+					ip := net.IP(r.Data).String()
+					if !slices.Contains(hosts[key], ip) {
+						hosts[key] = append(hosts[key], ip)
+					}
+				}
+			}
+		}
+	}
+
+	list := []MDNSInstance{}
+	for key, inst := range found {
+		inst.Addresses = append(inst.Addresses, hosts[strings.ToLower(inst.Host)]...)
+		if len(inst.Addresses) == 0 && senders[key] != "" {
+			inst.Addresses = []string{senders[key]}
+		}
+		list = append(list, *inst)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Instance < list[j].Instance })
+	return list, nil
+}
+
+// apiDiscoverHandler lists the instances on the LAN, waiting ?timeout=
+// seconds (default 2) for answers.
+func apiDiscoverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	timeout := defaultDiscoverTimeout
+	if secs, err := strconv.ParseFloat(r.URL.Query().Get("timeout"), 64); err == nil && secs > 0 {
+		timeout = min(time.Duration(secs*float64(time.Second)), maxDiscoverTimeout)
+	}
+	list, err := discoverMDNS(timeout)
+	if err != nil {
+		http.Error(w, "Discovery failed: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"instances": list})
+}
+
+// runDiscover implements the "discover" command: it prints the instances
+// on the LAN and returns the exit code.
+func runDiscover(args []string) int {
+	timeout := defaultDiscoverTimeout
+	if len(args) > 0 {
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "usage: discover [timeout, e.g. 5s]")
+			return 2
+		}
+		timeout = d
+	}
+	list, err := discoverMDNS(timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "discovery failed:", err)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tLOCATION\tADDRESS\tTAGS")
+	for _, inst := range list {
+		addr := inst.Host
+		if len(inst.Addresses) > 0 {
+			addr = inst.Addresses[0]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", inst.Name, inst.Location, net.JoinHostPort(addr, strconv.Itoa(inst.Port)), strings.Join(inst.Tags, ","))
+	}
+	tw.Flush()
+	return 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"slices"
+	"testing"
+)
+
+func testDNSMessage() dnsMessage {
+	srv := binary.BigEndian.AppendUint16([]byte{0, 0, 0, 0}, 1337)
+	return dnsMessage{
+		ID:        42,
+		Flags:     dnsFlagResponse,
+		Questions: []dnsQuestion{{mdnsService, dnsTypePTR, dnsClassIN | dnsClassTopBit}},
+		Records: []dnsRecord{
+			{Name: mdnsService, Type: dnsTypePTR, Class: dnsClassIN, TTL: mdnsTTL, Data: appendDNSName(nil, "lobby."+mdnsService)},
+			{Name: "lobby." + mdnsService, Type: dnsTypeSRV, Class: dnsClassIN | dnsClassTopBit, TTL: mdnsTTL, Data: appendDNSName(srv, "kiosk.local.")},
+			{Name: "lobby." + mdnsService, Type: dnsTypeTXT, Class: dnsClassIN, TTL: mdnsTTL, Data: []byte("\x0aname=lobby")},
+			{Name: "kiosk.local.", Type: dnsTypeA, Class: dnsClassIN, TTL: mdnsTTL, Data: net.IPv4(10, 0, 0, 7).To4()},
+		},
+	}
+}
+
+func TestDNSMessageRoundTrip(t *testing.T) {
+	want := testDNSMessage()
+	got, err := parseDNSMessage(want.pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != want.ID || got.Flags != want.Flags {
+		t.Errorf("header = %d/%#x, want %d/%#x", got.ID, got.Flags, want.ID, want.Flags)
+	}
+	if !slices.Equal(got.Questions, want.Questions) {
+		t.Errorf("questions = %v, want %v", got.Questions, want.Questions)
+	}
+	if len(got.Records) != len(want.Records) {
+		t.Fatalf("records = %d, want %d", len(got.Records), len(want.Records))
+	}
+	for i, r := range got.Records {
+		w := want.Records[i]
+		if r.Name != w.Name || r.Type != w.Type || r.Class != w.Class || r.TTL != w.TTL || !bytes.Equal(r.Data, w.Data) {
+			t.Errorf("record %d = %+v, want %+v", i, r, w)
+		}
+	}
+	srv := got.Records[1]
+	if target, _, err := readDNSName(srv.msg, srv.off+6); err != nil || target != "kiosk.local." {
+		t.Errorf("SRV target = %q, %v", target, err)
+	}
+}
+
+func TestReadDNSNameCompressed(t *testing.T) {
+	// "local." at 12, then "kiosk" pointing back to it.
+	msg := make([]byte, 12)
+	msg = appendDNSName(msg, "local.")
+	ptr := len(msg)
+	msg = append(msg, 5, 'k', 'i', 'o', 's', 'k', 0xC0, 12)
+	name, next, err := readDNSName(msg, ptr)
+	if err != nil || name != "kiosk.local." || next != len(msg) {
+		t.Errorf("got %q, %d, %v", name, next, err)
+	}
+}
+
+func TestParseDNSMessageTruncated(t *testing.T) {
+	m := testDNSMessage()
+	packet := m.pack()
+	for n := 0; n < len(packet); n++ {
+		if _, err := parseDNSMessage(packet[:n]); err == nil {
+			t.Errorf("%d of %d bytes parsed without error", n, len(packet))
+		}
+	}
+}
+
+func TestParseDNSMessageMalformed(t *testing.T) {
+	header := func(qd, an uint16) []byte {
+		b := binary.BigEndian.AppendUint16(make([]byte, 4), qd)
+		return append(binary.BigEndian.AppendUint16(b, an), 0, 0, 0, 0)
+	}
+	tests := map[string][]byte{
+		"compression loop":     append(header(1, 0), 0xC0, 12, 0, 1, 0, 1),
+		"pointer past the end": append(header(1, 0), 0xC0, 0xFF, 0, 1, 0, 1),
+		"half a pointer":       append(header(1, 0), 0xC0),
+		"label past the end":   append(header(1, 0), 60, 'a', 'b'),
+		"counts too high":      header(0xFFFF, 0xFFFF),
+		"record data too long": append(append(header(0, 1), 0), 0, 1, 0, 1, 0, 0, 0, 0, 0xFF, 0xFF, 1, 2),
+	}
+	for name, packet := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseDNSMessage(packet); err == nil {
+				t.Error("parsed without error")
+			}
+			if resp, _ := mdnsAnswer(packet, &net.UDPAddr{Port: 5353}, 1337); resp != nil {
+				t.Error("answered a malformed query")
+			}
+		})
+	}
+}
+
+func TestParseDNSMessageRandomInput(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	m := testDNSMessage()
+	packet := m.pack()
+	for i := 0; i < 5000; i++ {
+		mangled := slices.Clone(packet)
+		for j := rng.Intn(8); j >= 0; j-- {
+			mangled[rng.Intn(len(mangled))] = byte(rng.Intn(256))
+		}
+		parseDNSMessage(mangled[:rng.Intn(len(mangled)+1)])
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The memory guard reloads a display whose page grows past
+// MEMORY_RELOAD_HEAP (a byte size such as 800MB) of JavaScript heap or
+// MEMORY_RELOAD_DOM_NODES DOM nodes, as seen in its performance samples.
+// MEMORY_RELOAD_ACTION is "reload" (default) for a hard reload of that
+// display, or the name of a RECOVERY_COMMANDS entry, e.g. one restarting
+// the browser. After acting, the guard waits until the page is back under
+// memoryRearmRatio of the threshold and MEMORY_RELOAD_COOLDOWN seconds
+// (default 600) have passed, so a page that is heavy from the start isn't
+// reloaded in a loop.
+const memoryRearmRatio = 0.8
+
+type memoryGuardState struct {
+	mu       sync.Mutex
+	heap     int64
+	domNodes int
+	action   string
+	cooldown time.Duration
+	// tripped holds when each display last tripped; a display is removed
+	// once it is back under the rearm level.
+	tripped map[string]time.Time
+	trips   int
+}
+
+var memoryGuard = memoryGuardState{tripped: map[string]time.Time{}}
+
+func initMemoryGuard() error {
+	var heap int64
+	if raw := setting("MEMORY_RELOAD_HEAP"); raw != "" {
+		n, err := parseByteSize(raw)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid MEMORY_RELOAD_HEAP %q", raw)
+		}
+		heap = n
+	}
+	var nodes int
+	if raw := setting("MEMORY_RELOAD_DOM_NODES"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid MEMORY_RELOAD_DOM_NODES %q", raw)
+		}
+		nodes = n
+	}
+	action := setting("MEMORY_RELOAD_ACTION")
+	if action == "" {
+		action = "reload"
+	}
+	if _, ok := recoveryCommands()[action]; action != "reload" && !ok {
+		return fmt.Errorf("MEMORY_RELOAD_ACTION %q is neither reload nor a RECOVERY_COMMANDS name", action)
+	}
+	cooldown := 600
+	if n, err := strconv.Atoi(setting("MEMORY_RELOAD_COOLDOWN")); err == nil && n >= 0 {
+		cooldown = n
+	}
+
+	memoryGuard.mu.Lock()
+	memoryGuard.heap = heap
+	memoryGuard.domNodes = nodes
+	memoryGuard.action = action
+	memoryGuard.cooldown = time.Duration(cooldown) * time.Second
+	memoryGuard.tripped = map[string]time.Time{}
+	memoryGuard.mu.Unlock()
+	return nil
+}
+
+// check looks at a display's sample and reports whether that display
+// should hard-reload itself.
+func (g *memoryGuardState) check(p PagePerf) bool {
+	g.mu.Lock()
+	over := (g.heap > 0 && p.JSHeapUsed > g.heap) || (g.domNodes > 0 && p.DOMNodes > g.domNodes)
+	calm := (g.heap == 0 || float64(p.JSHeapUsed) < float64(g.heap)*memoryRearmRatio) &&
+		(g.domNodes == 0 || float64(p.DOMNodes) < float64(g.domNodes)*memoryRearmRatio)
+	last, waiting := g.tripped[p.Client]
+	if waiting && calm && time.Since(last) >= g.cooldown {
+		delete(g.tripped, p.Client)
+		waiting = false
+	}
+	if !over || waiting {
+		g.mu.Unlock()
+		return false
+	}
+	g.tripped[p.Client] = time.Now()
+	g.trips++
+	action := g.action
+	g.mu.Unlock()
+
+	slog.Warn("memory guard: page over its memory limit", "client", p.Client, "heap", p.JSHeapUsed, "domNodes", p.DOMNodes, "action", action)
+	notify(EventMemoryPressure, "page over its memory limit on display "+p.Client, map[string]interface{}{
+		"client":   p.Client,
+		"url":      p.URL,
+		"heap":     p.JSHeapUsed,
+		"domNodes": p.DOMNodes,
+		"action":   action,
+	})
+	if action == "reload" {
+		return true
+	}
+	go runRecoveryCommand(action, recoveryCommands()[action])
+	return false
+}
+
+// memoryGuardReport summarises the guard for /api/status.
+func memoryGuardReport() map[string]interface{} {
+	memoryGuard.mu.Lock()
+	defer memoryGuard.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":  memoryGuard.heap > 0 || memoryGuard.domNodes > 0,
+		"heap":     memoryGuard.heap,
+		"domNodes": memoryGuard.domNodes,
+		"action":   memoryGuard.action,
+		"trips":    memoryGuard.trips,
+	}
+}
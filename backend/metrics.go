@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// metricsHandler exposes counters in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP ctrl_bytes_sent_total Bytes of page content sent to all displays.")
+	fmt.Fprintln(w, "# TYPE ctrl_bytes_sent_total counter")
+	fmt.Fprintf(w, "ctrl_bytes_sent_total %d\n", bytesSent.Load())
+	fmt.Fprintln(w, "# HELP ctrl_throttled_writes_total Writes delayed by a bandwidth limit.")
+	fmt.Fprintln(w, "# TYPE ctrl_throttled_writes_total counter")
+	fmt.Fprintf(w, "ctrl_throttled_writes_total %d\n", throttledN.Load())
+
+	list := listClients()
+	online := 0
+	for _, c := range list {
+		if c.Online {
+			online++
+		}
+	}
+	fmt.Fprintln(w, "# HELP ctrl_clients_online Displays seen in the last 30 seconds.")
+	fmt.Fprintln(w, "# TYPE ctrl_clients_online gauge")
+	fmt.Fprintf(w, "ctrl_clients_online %d\n", online)
+	fmt.Fprintln(w, "# HELP ctrl_client_bytes_sent_total Bytes sent to each display.")
+	fmt.Fprintln(w, "# TYPE ctrl_client_bytes_sent_total counter")
+	for _, c := range list {
+		fmt.Fprintf(w, "ctrl_client_bytes_sent_total{client=%q} %d\n", c.ID, c.BytesSent)
+	}
+	fmt.Fprintln(w, "# HELP ctrl_client_requests_total Requests made by each display.")
+	fmt.Fprintln(w, "# TYPE ctrl_client_requests_total counter")
+	for _, c := range list {
+		fmt.Fprintf(w, "ctrl_client_requests_total{client=%q} %d\n", c.ID, c.Requests)
+	}
+	writePagePerfMetrics(w)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// apiMobileSummaryHandler returns everything a phone admin screen needs in a
+// single small response.
+func apiMobileSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	config := state.Snapshot()
+
+	visible := 0
+	for _, o := range GetOverlays() {
+		if o.Visible {
+			visible++
+		}
+	}
+	watchdog.mu.Lock()
+	trips := watchdog.trips
+	watchdog.mu.Unlock()
+
+	summary := map[string]interface{}{
+		"device":          deviceID(),
+		"targetUrl":       config.TargetURL,
+		"ready":           probe.check(config.TargetURL)["ok"],
+		"lastPageLoad":    getLastPageLoad(),
+		"broadcast":       GetBroadcast() != nil,
+		"visibleOverlays": visible,
+		"watchdogTrips":   trips,
+		"lastModified":    config.LastModified,
+		"favorites":       favoriteHistory(),
+	}
+	if drifting, ok := clockReport()["drifting"]; ok {
+		summary["clockDrifting"] = drifting
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// batchAction is one step of a /api/mobile/batch request.
+type batchAction struct {
+	Action  string `json:"action"`
+	URL     string `json:"url,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Confirm bool   `json:"confirm,omitempty"`
+	// Viewport is used by the "viewport" action; a nil value resets it.
+	Viewport *Viewport `json:"viewport,omitempty"`
+}
+
+func runBatchAction(a batchAction) error {
+	switch a.Action {
+	case "reload":
+		touchConfig()
+	case "hard_reload":
+		HardReload()
+	case "url":
+		u, err := normalizeTargetURL(a.URL)
+		if err != nil {
+			return err
+		}
+		if err := checkTarget(u); err != nil {
+			return err
+		}
+		if u.Scheme == "http" && !a.Confirm {
+			return errors.New("non-HTTPS target requires confirm")
+		}
+		switchTarget(u)
+	case "open_favorite":
+		seq, err := strconv.ParseInt(a.ID, 10, 64)
+		if err != nil {
+			return errors.New("invalid favorite id")
+		}
+		u, err := historyTarget(seq)
+		if err != nil {
+			return err
+		}
+		switchTarget(u)
+	case "open_bookmark":
+		if _, err := openBookmark(a.ID); err != nil {
+			return err
+		}
+	case "overlay_show", "overlay_hide":
+		if !setOverlayVisible(a.ID, a.Action == "overlay_show") {
+			return errors.New("overlay not found")
+		}
+	case "viewport":
+		v := Viewport{Zoom: 1}
+		if a.Viewport != nil {
+			v = *a.Viewport
+		}
+		if _, err := setViewport(v); err != nil {
+			return err
+		}
+	case "broadcast_clear":
+		if err := setBroadcast(nil); err != nil {
+			return err
+		}
+		recordAudit("broadcast_off", nil)
+	default:
+		return errors.New("unknown action")
+	}
+	return nil
+}
+
+// apiMobileBatchHandler runs a list of actions in order and reports the
+// outcome of each, so a phone can fix a screen in one round trip.
+func apiMobileBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var actions []batchAction
+	if err := json.NewDecoder(r.Body).Decode(&actions); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]map[string]interface{}, len(actions))
+	for i, a := range actions {
+		result := map[string]interface{}{"action": a.Action, "ok": true}
+		if err := runBatchAction(a); err != nil {
+			result["ok"] = false
+			result["error"] = err.Error()
+		}
+		results[i] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
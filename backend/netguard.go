@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The guard keeps the runtime API from pointing the proxy at internal-only
+// services. Private, loopback and link-local addresses are refused unless
+// ALLOW_PRIVATE_TARGETS=true or they fall inside ALLOWED_NETWORKS (CIDRs).
+// Hosts named in TARGET_URL or ALLOWED_HOSTS were chosen by the operator and
+// are always trusted.
+var (
+	blockedNetworks = mustParseCIDRs(
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+		"::1/128", "fc00::/7", "fe80::/10",
+	)
+
+	guardMutex      sync.RWMutex
+	allowPrivate    bool
+	allowedNetworks []*net.IPNet
+	trustedHosts    = map[string]bool{}
+
+	guardedDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+)
+
+var errTargetBlocked = errors.New("target address is not allowed")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func initNetGuard() error {
+	guardMutex.Lock()
+	defer guardMutex.Unlock()
+
+	allowPrivate = setting("ALLOW_PRIVATE_TARGETS") == "true"
+
+	allowedNetworks = nil
+	for _, c := range strings.Split(setting("ALLOWED_NETWORKS"), ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("invalid ALLOWED_NETWORKS entry %q: %w", c, err)
+		}
+		allowedNetworks = append(allowedNetworks, n)
+	}
+
+	trustedHosts = map[string]bool{}
+	for _, h := range strings.Split(setting("ALLOWED_HOSTS"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			trustedHosts[h] = true
+		}
+	}
+	if u, err := url.Parse(setting("TARGET_URL")); err == nil && u.Hostname() != "" {
+		trustedHosts[strings.ToLower(u.Hostname())] = true
+	}
+	return nil
+}
+
+func hostTrusted(host string) bool {
+	guardMutex.RLock()
+	defer guardMutex.RUnlock()
+	return allowPrivate || trustedHosts[strings.ToLower(host)]
+}
+
+func ipAllowed(ip net.IP) bool {
+	guardMutex.RLock()
+	defer guardMutex.RUnlock()
+	if allowPrivate {
+		return true
+	}
+	for _, n := range allowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveAllowed resolves host and returns only the addresses the guard
+// permits, failing if none remain.
+func resolveAllowed(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if ipAllowed(a.IP) {
+			ips = append(ips, a.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s: %w", host, errTargetBlocked)
+	}
+	return ips, nil
+}
+
+// checkTargetHost validates a host before it is accepted as a new target.
+func checkTargetHost(host string) error {
+	if hostTrusted(host) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := resolveAllowed(ctx, host)
+	return err
+}
+
+// guardedDialContext is used by every outbound transport that talks to the
+// target. Checking at dial time also covers DNS answers that change after
+// validation.
+func guardedDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if hostTrusted(host) {
+		return guardedDialer.DialContext(ctx, network, addr)
+	}
+	ips, err := resolveAllowed(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := guardedDialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
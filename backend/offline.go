@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pageLoadTimeout bounds how long we wait for the target to start answering
+// before treating the navigation as failed (PAGE_LOAD_TIMEOUT, seconds).
+func pageLoadTimeout() time.Duration {
+	secs, _ := strconv.Atoi(setting("PAGE_LOAD_TIMEOUT"))
+	if secs <= 0 {
+		secs = 30
+	}
+	return time.Duration(secs) * time.Second
+}
+
+var offlineTemplate = template.Must(template.New("offline").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} – Reconnecting…</title>
+<style>
+html,body{margin:0;height:100%;background:#111;color:#eee;font-family:sans-serif;overflow:hidden}
+iframe{position:fixed;inset:0;width:100%;height:100%;border:0}
+.splash{display:flex;flex-direction:column;align-items:center;justify-content:center;height:100%;text-align:center}
+.splash h1{font-weight:300;font-size:3em;margin:0 0 .5em}
+.splash p{opacity:.6}
+.card{position:fixed;left:1em;bottom:1em;max-width:calc(100% - 4em);padding:.8em 1em;background:rgba(0,0,0,.8);border-left:4px solid #e55;border-radius:4px;font:14px/1.5 monospace;color:#eee;text-align:left;word-break:break-all}
+</style>
+</head>
+<body>
+{{if .FallbackURL}}<iframe src="{{.FallbackURL}}"></iframe>{{else}}<div class="splash"><h1>Content temporarily unavailable</h1><p>{{.Reason}}</p><p>Retrying automatically…</p></div>{{end}}
+{{if .Details}}<div class="card">{{.Name}}{{if .Location}} ({{.Location}}){{end}}<br>{{.Reason}}<br>URL: {{.URL}}{{if .Error}}<br>Error: {{.Error}}{{end}}<br>Failed at: {{.Time}}</div>{{end}}
+<script>
+setInterval(() => {
+    fetch('/readyz').then(res => { if (res.ok) window.location.reload(); }).catch(() => {});
+}, {{.RetryMs}});
+</script>
+</body>
+</html>
+`))
+
+// offlinePage renders the splash shown in place of a failed navigation. If
+// FALLBACK_URL is set it is shown full-screen instead of the built-in
+// message; either way the page keeps retrying the primary target. A card
+// with the URL, the error and the time lets on-site staff report what
+// failed; OFFLINE_DETAILS=off hides it on public screens.
+func offlinePage(reason, pageURL, detail string) []byte {
+	var buf bytes.Buffer
+	id := state.Snapshot().Identity
+	offlineTemplate.Execute(&buf, map[string]interface{}{
+		"Name":        id.Name,
+		"Location":    id.Location,
+		"FallbackURL": localHref(setting("FALLBACK_URL")),
+		"Reason":      reason,
+		"RetryMs":     15000,
+		"Details":     setting("OFFLINE_DETAILS") != "off",
+		"URL":         pageURL,
+		"Error":       detail,
+		"Time":        time.Now().Format("2006-01-02 15:04:05 MST"),
+	})
+	return buf.Bytes()
+}
+
+func isNavigation(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
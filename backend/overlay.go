@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Overlay is a DOM layer drawn above the target page by the injected
+// script: a scrolling ticker, a clock, a logo or a banner.
+type Overlay struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Text     string       `json:"text,omitempty"`
+	ImageURL string       `json:"imageUrl,omitempty"`
+	Position string       `json:"position"`
+	Visible  bool         `json:"visible"`
+	Style    OverlayStyle `json:"style"`
+}
+
+type OverlayStyle struct {
+	Color      string `json:"color,omitempty"`
+	Background string `json:"background,omitempty"`
+	FontSize   string `json:"fontSize,omitempty"`
+}
+
+var (
+	overlays      []Overlay
+	overlaysMutex sync.RWMutex
+	overlayPath   string
+
+	overlayTypes     = map[string]bool{"ticker": true, "clock": true, "logo": true, "banner": true}
+	overlayPositions = map[string]bool{
+		"top": true, "bottom": true, "center": true,
+		"top-left": true, "top-right": true, "bottom-left": true, "bottom-right": true,
+	}
+)
+
+func initOverlays() error {
+	overlaysMutex.Lock()
+	overlayPath = filepath.Join(dataDir, "overlays.json")
+	overlays = nil
+	overlaysMutex.Unlock()
+
+	data, err := os.ReadFile(overlayPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	overlaysMutex.Lock()
+	defer overlaysMutex.Unlock()
+	return json.Unmarshal(data, &overlays)
+}
+
+func saveOverlays() error {
+	overlaysMutex.RLock()
+	data, err := json.MarshalIndent(overlays, "", "  ")
+	overlaysMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(overlayPath, data, 0644)
+}
+
+func GetOverlays() []Overlay {
+	overlaysMutex.RLock()
+	defer overlaysMutex.RUnlock()
+	return append([]Overlay(nil), overlays...)
+}
+
+func (o *Overlay) validate() error {
+	if o.ID == "" {
+		return errors.New("id is required")
+	}
+	if !overlayTypes[o.Type] {
+		return errors.New("type must be ticker, clock, logo or banner")
+	}
+	if o.Position == "" {
+		o.Position = "bottom"
+	}
+	if !overlayPositions[o.Position] {
+		return errors.New("invalid position")
+	}
+	if o.Type == "logo" && o.ImageURL == "" {
+		return errors.New("logo overlays need an imageUrl")
+	}
+	o.ImageURL = localHref(o.ImageURL)
+	return nil
+}
+
+func upsertOverlay(o Overlay) {
+	overlaysMutex.Lock()
+	replaced := false
+	for i := range overlays {
+		if overlays[i].ID == o.ID {
+			overlays[i] = o
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		overlays = append(overlays, o)
+	}
+	overlaysMutex.Unlock()
+	persistOverlays()
+}
+
+func setOverlayVisible(id string, visible bool) bool {
+	overlaysMutex.Lock()
+	found := false
+	for i := range overlays {
+		if overlays[i].ID == id {
+			overlays[i].Visible = visible
+			found = true
+		}
+	}
+	overlaysMutex.Unlock()
+	if found {
+		persistOverlays()
+	}
+	return found
+}
+
+func deleteOverlay(id string) bool {
+	overlaysMutex.Lock()
+	found := false
+	for i := range overlays {
+		if overlays[i].ID == id {
+			overlays = append(overlays[:i], overlays[i+1:]...)
+			found = true
+			break
+		}
+	}
+	overlaysMutex.Unlock()
+	if found {
+		persistOverlays()
+	}
+	return found
+}
+
+func persistOverlays() {
+	if err := saveOverlays(); err != nil {
+		slog.Error("failed to save overlays", "err", err)
+	}
+}
+
+// apiOverlayHandler lists overlays (GET), creates or replaces one (POST with
+// an Overlay body) or removes one (DELETE ?id=).
+func apiOverlayHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"overlays": GetOverlays()})
+	case http.MethodPost:
+		requireAdminIfConfigured(func(w http.ResponseWriter, r *http.Request) {
+			var o Overlay
+			if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			if err := o.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			upsertOverlay(o)
+			recordAudit("overlay_set", map[string]interface{}{"id": o.ID, "type": o.Type, "text": o.Text, "visible": o.Visible})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(o)
+		})(w, r)
+	case http.MethodDelete:
+		requireAdminIfConfigured(func(w http.ResponseWriter, r *http.Request) {
+			if !deleteOverlay(r.URL.Query().Get("id")) {
+				http.NotFound(w, r)
+				return
+			}
+			recordAudit("overlay_delete", map[string]interface{}{"id": r.URL.Query().Get("id")})
+			w.WriteHeader(http.StatusNoContent)
+		})(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func overlayVisibilityHandler(visible bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !setOverlayVisible(r.URL.Query().Get("id"), visible) {
+			http.NotFound(w, r)
+			return
+		}
+		recordAudit("overlay_visibility", map[string]interface{}{"id": r.URL.Query().Get("id"), "visible": visible})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// overlayScript renders the overlays returned by /api/overlay and refreshes
+// them every few seconds, so changes show up without reloading the page.
+const overlayScript = `
+<script>
+(() => {
+    const positions = {
+        'top': 'top:0;left:0;right:0;',
+        'bottom': 'bottom:0;left:0;right:0;',
+        'center': 'top:50%;left:50%;transform:translate(-50%,-50%);',
+        'top-left': 'top:1em;left:1em;',
+        'top-right': 'top:1em;right:1em;',
+        'bottom-left': 'bottom:1em;left:1em;',
+        'bottom-right': 'bottom:1em;right:1em;'
+    };
+    let root = null, lastState = '', clocks = [];
+    const build = (o) => {
+        const el = document.createElement('div');
+        el.style.cssText = 'position:fixed;pointer-events:none;z-index:2147483646;font-family:sans-serif;box-sizing:border-box;' + (positions[o.position] || positions.bottom);
+        el.style.color = o.style.color || '#fff';
+        el.style.background = o.style.background || (o.type === 'logo' ? 'transparent' : 'rgba(0,0,0,0.7)');
+        el.style.fontSize = o.style.fontSize || '24px';
+        if (o.type === 'ticker') {
+            el.style.overflow = 'hidden';
+            el.style.whiteSpace = 'nowrap';
+            el.style.padding = '0.3em 0';
+            const span = document.createElement('span');
+            span.textContent = o.text || '';
+            span.style.display = 'inline-block';
+            span.style.paddingLeft = '100%';
+            span.animate([{ transform: 'translateX(0)' }, { transform: 'translateX(-100%)' }], { duration: Math.max(10000, (o.text || '').length * 200), iterations: Infinity });
+            el.appendChild(span);
+        } else if (o.type === 'clock') {
+            el.style.padding = '0.3em 0.6em';
+            clocks.push(el);
+        } else if (o.type === 'logo') {
+            const img = document.createElement('img');
+            img.src = o.imageUrl;
+            img.style.cssText = 'display:block;max-height:8em;max-width:20vw;';
+            el.appendChild(img);
+        } else {
+            el.style.padding = '0.6em 1em';
+            el.style.textAlign = 'center';
+            el.style.fontWeight = 'bold';
+            el.textContent = o.text || '';
+        }
+        return el;
+    };
+    const render = (list) => {
+        const state = JSON.stringify(list);
+        if (state === lastState) return;
+        lastState = state;
+        if (!root) {
+            root = document.createElement('div');
+            root.id = 'ctrl-overlays';
+            document.body.appendChild(root);
+        }
+        root.replaceChildren();
+        clocks = [];
+        list.filter(o => o.visible).forEach(o => root.appendChild(build(o)));
+        tickClocks();
+    };
+    const tickClocks = () => clocks.forEach(el => el.textContent = new Date().toLocaleTimeString());
+    const refresh = () => fetch('/api/overlay').then(res => res.json()).then(data => render(data.overlays || [])).catch(() => {});
+    document.addEventListener('DOMContentLoaded', refresh);
+    if (document.readyState !== 'loading') refresh();
+    setInterval(refresh, 5000);
+    setInterval(tickClocks, 1000);
+})();
+</script>
+`
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The page endpoints ask the displays about the page they show, through
+// the same channel as automation steps. The first display to answer wins,
+// so with several displays on different pages the result may come from
+// any of them.
+
+const (
+	// pageQueryWait is how long a page query waits for a display.
+	pageQueryWait = 3 * time.Second
+	// maxDOMQueryResults matches the cap in automationScript.
+	maxDOMQueryResults = 200
+)
+
+// askDisplayHandler writes a display's answer, or the reason there is none.
+func askDisplayHandler(w http.ResponseWriter, action, value string) (interface{}, bool) {
+	v, err := askDisplay(action, value, pageQueryWait, pageQueryWait)
+	if errors.Is(err, errNoDisplayAnswer) {
+		http.Error(w, "No display answered", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return v, true
+}
+
+// apiDOMQueryHandler returns the elements matching ?selector= on the page
+// with their text and bounding boxes in page coordinates, at most ?limit=
+// (default 50).
+func apiDOMQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	selector := r.URL.Query().Get("selector")
+	if selector == "" {
+		http.Error(w, "Missing selector", http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	limit = min(limit, maxDOMQueryResults)
+	v, ok := askDisplayHandler(w, "query", selector)
+	if !ok {
+		return
+	}
+	elements, _ := v.([]interface{})
+	if elements == nil {
+		elements = []interface{}{}
+	}
+	resp := map[string]interface{}{"selector": selector, "count": len(elements), "elements": elements[:min(limit, len(elements))]}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// apiPageHandler reports the current page: all of it for /api/page/metrics,
+// or only the title or URL.
+func apiPageHandler(fields ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v, ok := askDisplayHandler(w, "page", "")
+		if !ok {
+			return
+		}
+		page, _ := v.(map[string]interface{})
+		if len(fields) > 0 {
+			subset := map[string]interface{}{}
+			for _, f := range fields {
+				subset[f] = page[f]
+			}
+			page = subset
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The displays report console messages and uncaught errors from the pages
+// they show, so a blank dashboard can be diagnosed remotely. The last
+// maxPageLogs entries are kept in memory.
+type PageLog struct {
+	Seq     int64  `json:"seq"`
+	Time    int64  `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Source  string `json:"source,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Client  string `json:"client,omitempty"`
+}
+
+const (
+	maxPageLogs       = 500
+	maxPageLogBatch   = 50
+	maxPageLogMessage = 2000
+)
+
+var pageLogLevels = map[string]bool{"debug": true, "log": true, "info": true, "warn": true, "error": true, "exception": true}
+
+var (
+	pageLogs      []PageLog
+	pageLogSeq    int64
+	pageErrors    int64
+	pageLogsMutex sync.Mutex
+)
+
+// pageErrorCount is the number of errors and exceptions reported since
+// startup.
+func pageErrorCount() int64 {
+	pageLogsMutex.Lock()
+	defer pageLogsMutex.Unlock()
+	return pageErrors
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n] + "…"
+	}
+	return s
+}
+
+// apiPageLogsReportHandler takes a batch of entries from a display.
+func apiPageLogsReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var batch []PageLog
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256*1024)).Decode(&batch); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	client := ""
+	if c, err := r.Cookie(clientCookie); err == nil {
+		client = c.Value
+	}
+	now := time.Now().UnixMilli()
+	pageLogsMutex.Lock()
+	for _, e := range batch[:min(len(batch), maxPageLogBatch)] {
+		if !pageLogLevels[e.Level] {
+			continue
+		}
+		pageLogSeq++
+		e.Seq, e.Time, e.Client = pageLogSeq, now, client
+		e.Message = truncate(e.Message, maxPageLogMessage)
+		e.Stack = truncate(e.Stack, maxPageLogMessage)
+		if e.Level == "error" || e.Level == "exception" {
+			pageErrors++
+		}
+		pageLogs = append(pageLogs, e)
+	}
+	if n := len(pageLogs) - maxPageLogs; n > 0 {
+		pageLogs = append([]PageLog(nil), pageLogs[n:]...)
+	}
+	pageLogsMutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiPageLogsHandler lists the buffered entries, filtered by ?level= and
+// ?since= (a seq).
+func apiPageLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	level := r.URL.Query().Get("level")
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	pageLogsMutex.Lock()
+	list := []PageLog{}
+	for _, e := range pageLogs {
+		if e.Seq > since && (level == "" || e.Level == level) {
+			list = append(list, e)
+		}
+	}
+	errors := pageErrors
+	pageLogsMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": errors, "logs": list})
+}
+
+// pageLogScript forwards console output and uncaught errors, including
+// failed resource loads, in batches.
+const pageLogScript = `
+<script>
+(() => {
+    let queue = [];
+    const text = (v) => {
+        if (typeof v === 'string') return v;
+        if (v instanceof Error) return v.stack || String(v);
+        try { return JSON.stringify(v); } catch (e) { return String(v); }
+    };
+    const push = (entry) => {
+        if (queue.length < 50) queue.push(Object.assign({ url: location.href }, entry));
+    };
+    for (const level of ['debug', 'log', 'info', 'warn', 'error']) {
+        const orig = console[level];
+        console[level] = function (...args) {
+            push({ level, message: args.map(text).join(' ') });
+            return orig.apply(this, args);
+        };
+    }
+    window.addEventListener('error', (e) => {
+        if (e.target && e.target !== window) {
+            push({ level: 'error', message: 'Failed to load ' + (e.target.src || e.target.href || e.target.tagName) });
+            return;
+        }
+        push({
+            level: 'exception',
+            message: e.message,
+            source: e.filename ? e.filename + ':' + e.lineno + ':' + e.colno : '',
+            stack: e.error && e.error.stack || '',
+        });
+    }, true);
+    window.addEventListener('unhandledrejection', (e) => {
+        push({ level: 'exception', message: 'Unhandled rejection: ' + text(e.reason), stack: e.reason && e.reason.stack || '' });
+    });
+    const flush = () => {
+        if (!queue.length) return;
+        const body = JSON.stringify(queue);
+        queue = [];
+        if (!navigator.sendBeacon('/api/page-logs/report', new Blob([body], { type: 'application/json' }))) {
+            fetch('/api/page-logs/report', { method: 'POST', body, keepalive: true }).catch(() => {});
+        }
+    };
+    setInterval(flush, 2000);
+    window.addEventListener('pagehide', flush);
+})();
+</script>
+`
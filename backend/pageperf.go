@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PagePerf is the latest performance sample from one display's page. The
+// heap figures are only reported by Chromium; LongTasks and LayoutShifts
+// count since the page loaded, so a steady rise in them or in the heap
+// points at a page that needs a periodic hard reload.
+type PagePerf struct {
+	Client       string  `json:"client"`
+	Time         int64   `json:"time"`
+	URL          string  `json:"url"`
+	JSHeapUsed   int64   `json:"jsHeapUsed,omitempty"`
+	JSHeapTotal  int64   `json:"jsHeapTotal,omitempty"`
+	DOMNodes     int     `json:"domNodes"`
+	LongTasks    int     `json:"longTasks"`
+	LayoutShifts int     `json:"layoutShifts"`
+	FPS          float64 `json:"fps"`
+}
+
+// pagePerfMaxAge drops samples from displays that stopped reporting.
+const pagePerfMaxAge = 5 * time.Minute
+
+var (
+	pagePerf      = map[string]PagePerf{}
+	pagePerfMutex sync.Mutex
+)
+
+// listPagePerf returns the recent samples ordered by client.
+func listPagePerf() []PagePerf {
+	pagePerfMutex.Lock()
+	defer pagePerfMutex.Unlock()
+	cutoff := time.Now().Add(-pagePerfMaxAge).UnixMilli()
+	list := []PagePerf{}
+	for id, p := range pagePerf {
+		if p.Time < cutoff {
+			delete(pagePerf, id)
+			continue
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Client < list[j].Client })
+	return list
+}
+
+// apiPagePerfReportHandler stores a display's sample. The answer tells
+// the display to hard-reload when the memory guard trips.
+func apiPagePerfReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var p PagePerf
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	p.Client = "unknown"
+	if c, err := r.Cookie(clientCookie); err == nil {
+		p.Client = c.Value
+	}
+	p.Time = time.Now().UnixMilli()
+	pagePerfMutex.Lock()
+	pagePerf[p.Client] = p
+	pagePerfMutex.Unlock()
+	if memoryGuard.check(p) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"reload": "hard"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiPagePerfHandler lists the latest sample of each display.
+func apiPagePerfHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"pages": listPagePerf()})
+}
+
+// writePagePerfMetrics adds the samples to /metrics.
+func writePagePerfMetrics(w http.ResponseWriter) {
+	list := listPagePerf()
+	gauges := []struct {
+		name, help string
+		value      func(PagePerf) interface{}
+	}{
+		{"ctrl_page_js_heap_used_bytes", "JavaScript heap in use on each display's page.", func(p PagePerf) interface{} { return p.JSHeapUsed }},
+		{"ctrl_page_dom_nodes", "DOM nodes on each display's page.", func(p PagePerf) interface{} { return p.DOMNodes }},
+		{"ctrl_page_long_tasks", "Long tasks since each display's page loaded.", func(p PagePerf) interface{} { return p.LongTasks }},
+		{"ctrl_page_layout_shifts", "Layout shifts since each display's page loaded.", func(p PagePerf) interface{} { return p.LayoutShifts }},
+		{"ctrl_page_fps", "Frames per second rendered by each display's page.", func(p PagePerf) interface{} { return p.FPS }},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+		for _, p := range list {
+			fmt.Fprintf(w, "%s{client=%q} %v\n", g.name, p.Client, g.value(p))
+		}
+	}
+}
+
+// pagePerfScript samples the page every 30 seconds. FPS is the number of
+// animation frames over the last interval.
+const pagePerfScript = `
+<script>
+(() => {
+    let frames = 0, longTasks = 0, layoutShifts = 0, since = performance.now();
+    const count = () => { frames++; requestAnimationFrame(count); };
+    requestAnimationFrame(count);
+    const observe = (type, fn) => {
+        try { new PerformanceObserver((list) => fn(list.getEntries().length)).observe({ type, buffered: true }); } catch (e) {}
+    };
+    observe('longtask', (n) => { longTasks += n; });
+    observe('layout-shift', (n) => { layoutShifts += n; });
+    setInterval(() => {
+        const now = performance.now();
+        const sample = {
+            url: location.href,
+            domNodes: document.getElementsByTagName('*').length,
+            longTasks,
+            layoutShifts,
+            fps: Math.round(frames * 10000 / (now - since)) / 10,
+        };
+        if (performance.memory) {
+            sample.jsHeapUsed = performance.memory.usedJSHeapSize;
+            sample.jsHeapTotal = performance.memory.totalJSHeapSize;
+        }
+        frames = 0;
+        since = now;
+        fetch('/api/page-perf/report', { method: 'POST', body: JSON.stringify(sample) })
+            .then(res => res.status === 200 ? res.json() : {})
+            .then(data => { if (data.reload === 'hard' && window.ctrlHardReload) window.ctrlHardReload(); })
+            .catch(() => {});
+    }, 30000);
+})();
+</script>
+`
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PageRule overrides scroll and scale settings on pages whose path matches
+// Pattern, a glob where "*" matches any run of characters (including "/").
+// Only the fields that are set override the global config; the first
+// matching rule wins.
+type PageRule struct {
+	ID              string   `json:"id"`
+	Pattern         string   `json:"pattern"`
+	AutoScroll      *bool    `json:"autoScroll,omitempty"`
+	ScrollSpeed     *int     `json:"scrollSpeed,omitempty"`
+	ScrollSpeedX    *int     `json:"scrollSpeedX,omitempty"`
+	ScrollDirection *string  `json:"scrollDirection,omitempty"`
+	ScrollSequence  *string  `json:"scrollSequence,omitempty"`
+	ScrollAnchors   *string  `json:"scrollAnchors,omitempty"`
+	ScaleFactor     *float64 `json:"scaleFactor,omitempty"`
+	// Ready holds the page back until it is ready to be shown.
+	Ready *ReadyWait `json:"ready,omitempty"`
+
+	re *regexp.Regexp
+}
+
+var (
+	pageRules      []PageRule
+	pageRulesMutex sync.RWMutex
+	pageRulesPath  string
+)
+
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+}
+
+func (p *PageRule) validate() error {
+	if p.ID == "" {
+		return errors.New("id is required")
+	}
+	if p.Pattern == "" {
+		return errors.New("pattern is required")
+	}
+	re, err := globRegexp(p.Pattern)
+	if err != nil {
+		return err
+	}
+	p.re = re
+	if (p.ScrollSpeed != nil && *p.ScrollSpeed <= 0) || (p.ScrollSpeedX != nil && *p.ScrollSpeedX <= 0) {
+		return errors.New("speed must be positive")
+	}
+	if p.ScrollDirection != nil && !scrollDirections[*p.ScrollDirection] {
+		return errors.New("direction must be vertical, horizontal or snake")
+	}
+	if p.ScrollSequence != nil {
+		if _, err := parseScrollSequence(*p.ScrollSequence); err != nil {
+			return err
+		}
+	}
+	if p.ScrollAnchors != nil {
+		if _, err := parseScrollAnchors(*p.ScrollAnchors); err != nil {
+			return err
+		}
+	}
+	if p.ScaleFactor != nil && *p.ScaleFactor <= 0 {
+		return errors.New("scaleFactor must be positive")
+	}
+	if p.Ready != nil {
+		if err := p.Ready.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func initPageRules() error {
+	pageRulesMutex.Lock()
+	defer pageRulesMutex.Unlock()
+	pageRulesPath = filepath.Join(dataDir, "pagerules.json")
+	pageRules = nil
+
+	data, err := os.ReadFile(pageRulesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &pageRules); err != nil {
+		return err
+	}
+	for i := range pageRules {
+		if err := pageRules[i].validate(); err != nil {
+			return errors.New("rule " + pageRules[i].ID + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func savePageRules() {
+	pageRulesMutex.RLock()
+	data, err := json.MarshalIndent(pageRules, "", "  ")
+	pageRulesMutex.RUnlock()
+	if err == nil {
+		err = os.WriteFile(pageRulesPath, data, 0644)
+	}
+	if err != nil {
+		slog.Error("failed to save page rules", "err", err)
+	}
+}
+
+func GetPageRules() []PageRule {
+	pageRulesMutex.RLock()
+	defer pageRulesMutex.RUnlock()
+	return append([]PageRule(nil), pageRules...)
+}
+
+func upsertPageRule(p PageRule) {
+	pageRulesMutex.Lock()
+	replaced := false
+	for i := range pageRules {
+		if pageRules[i].ID == p.ID {
+			pageRules[i] = p
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		pageRules = append(pageRules, p)
+	}
+	pageRulesMutex.Unlock()
+	savePageRules()
+	touchConfig()
+}
+
+func deletePageRule(id string) bool {
+	pageRulesMutex.Lock()
+	found := false
+	for i := range pageRules {
+		if pageRules[i].ID == id {
+			pageRules = append(pageRules[:i], pageRules[i+1:]...)
+			found = true
+			break
+		}
+	}
+	pageRulesMutex.Unlock()
+	if found {
+		savePageRules()
+		touchConfig()
+	}
+	return found
+}
+
+// applyPageRules returns config with the first rule matching urlPath
+// applied.
+func applyPageRules(config Config, urlPath string) Config {
+	pageRulesMutex.RLock()
+	defer pageRulesMutex.RUnlock()
+	for _, p := range pageRules {
+		if !p.re.MatchString(urlPath) {
+			continue
+		}
+		if p.AutoScroll != nil {
+			config.AutoScroll = *p.AutoScroll
+		}
+		if p.ScrollSpeed != nil {
+			config.ScrollSpeed = *p.ScrollSpeed
+		}
+		if p.ScrollSpeedX != nil {
+			config.ScrollSpeedX = *p.ScrollSpeedX
+		}
+		if p.ScrollDirection != nil {
+			config.ScrollDirection = *p.ScrollDirection
+		}
+		if p.ScrollSequence != nil {
+			config.ScrollSequence = *p.ScrollSequence
+		}
+		if p.ScrollAnchors != nil {
+			config.ScrollAnchors = *p.ScrollAnchors
+		}
+		if p.ScaleFactor != nil {
+			config.ScaleFactor = *p.ScaleFactor
+		}
+		if p.Ready != nil {
+			config.Ready = *p.Ready
+		}
+		break
+	}
+	return config
+}
+
+// apiPageRulesHandler lists rules (GET), creates or replaces one (POST with
+// a PageRule body) or removes one (DELETE ?id=).
+func apiPageRulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"rules": GetPageRules()})
+	case http.MethodPost:
+		var p PageRule
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := p.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		upsertPageRule(p)
+		recordAudit("page_rule_set", map[string]interface{}{"id": p.ID, "pattern": p.Pattern})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if !deletePageRule(id) {
+			http.NotFound(w, r)
+			return
+		}
+		recordAudit("page_rule_delete", map[string]interface{}{"id": id})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A locked display can be unlocked at the screen: a long press on the
+// top-right corner opens a PIN pad, and the PIN is checked against
+// UNLOCK_PIN, a salted hash made with "web-scaler-proxy hash-pin <pin>".
+
+const (
+	maxPINFailures = 5
+	pinLockout     = time.Minute
+)
+
+var (
+	pinFailures    int
+	pinLockedUntil time.Time
+	pinMutex       sync.Mutex
+)
+
+// hashPIN returns the UNLOCK_PIN value for pin, "sha256:<salt>:<hash>".
+func hashPIN(pin string, salt []byte) string {
+	sum := sha256.Sum256(append(salt, pin...))
+	return "sha256:" + hex.EncodeToString(salt) + ":" + hex.EncodeToString(sum[:])
+}
+
+func checkPIN(pin, stored string) bool {
+	parts := strings.Split(stored, ":")
+	if len(parts) != 3 || parts[0] != "sha256" {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashPIN(pin, salt)), []byte(stored)) == 1
+}
+
+// runHashPIN implements the hash-pin subcommand.
+func runHashPIN(args []string) int {
+	if len(args) != 1 || args[0] == "" {
+		fmt.Fprintln(os.Stderr, "usage: hash-pin <pin>")
+		return 2
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	fmt.Println(hashPIN(args[0], salt))
+	return 0
+}
+
+// apiUnlockHandler unlocks the display when given the right PIN
+// ({"pin":"1234"}). After five wrong PINs it refuses for a minute.
+func apiUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stored := setting("UNLOCK_PIN")
+	if stored == "" {
+		http.Error(w, "PIN unlock not configured", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		PIN string `json:"pin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	pinMutex.Lock()
+	defer pinMutex.Unlock()
+	if time.Now().Before(pinLockedUntil) {
+		http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+		return
+	}
+	if !checkPIN(req.PIN, stored) {
+		pinFailures++
+		if pinFailures >= maxPINFailures {
+			pinFailures = 0
+			pinLockedUntil = time.Now().Add(pinLockout)
+		}
+		slog.Warn("wrong unlock PIN", "remote", r.RemoteAddr)
+		recordAudit("unlock_pin_failed", map[string]interface{}{"remote": r.RemoteAddr})
+		http.Error(w, "Wrong PIN", http.StatusForbidden)
+		return
+	}
+	pinFailures = 0
+	seconds := defaultUnlockSeconds
+	if v, err := strconv.Atoi(setting("UNLOCK_PIN_DURATION")); err == nil {
+		seconds = v
+	}
+	if seconds > 0 {
+		unlockFor(time.Duration(seconds)*time.Second, false)
+	} else {
+		SetLock("none")
+	}
+	slog.Info("display unlocked with PIN", "remote", r.RemoteAddr)
+	recordAudit("unlock_pin", map[string]interface{}{"remote": r.RemoteAddr})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pinPadScript opens the PIN pad on a two-second press on the top-right
+// corner. It listens on the window in the capture phase with pointer
+// events, which the lock overlay lets through.
+const pinPadScript = `
+<script>
+(() => {
+    let timer = null, pad = null, pin = '';
+    const corner = e => e.clientX > innerWidth - 80 && e.clientY < 80;
+    const close = () => { if (pad) pad.remove(); pad = null; pin = ''; };
+    const open = () => {
+        pad = document.createElement('div');
+        pad.style.cssText = 'position:fixed;inset:0;z-index:2147483647;background:rgba(0,0,0,.85);display:flex;align-items:center;justify-content:center;font:24px sans-serif;color:#fff;';
+        const keys = ['1','2','3','4','5','6','7','8','9','×','0','✓'].map(k =>
+            '<button data-ctrl-pin="' + k + '" style="width:80px;height:80px;margin:6px;font:inherit;border-radius:8px;border:0">' + k + '</button>').join('');
+        pad.innerHTML = '<div style="text-align:center"><div data-ctrl-pin-dots style="height:40px;letter-spacing:8px"></div>' +
+            '<div style="display:grid;grid-template-columns:repeat(3,auto)">' + keys + '</div></div>';
+        document.body.appendChild(pad);
+    };
+    const submit = () => fetch('/api/unlock', { method: 'POST', body: JSON.stringify({ pin }) })
+        .then(res => {
+            if (res.ok) return location.reload();
+            pad.querySelector('[data-ctrl-pin-dots]').textContent = res.status === 429 ? 'Try again later' : 'Wrong PIN';
+            pin = '';
+        })
+        .catch(close);
+    window.addEventListener('pointerdown', e => {
+        if (!pad && corner(e)) timer = setTimeout(open, 2000);
+    }, true);
+    window.addEventListener('pointerup', e => {
+        clearTimeout(timer);
+        const key = pad && e.target.closest && e.target.closest('[data-ctrl-pin]');
+        if (!pad) return;
+        if (!key) return e.target === pad && close();
+        const k = key.dataset.ctrlPin;
+        if (k === '×') pin = pin.slice(0, -1);
+        else if (k === '✓') return submit();
+        else if (pin.length < 12) pin += k;
+        pad.querySelector('[data-ctrl-pin-dots]').textContent = '•'.repeat(pin.length);
+    }, true);
+})();
+</script>
+`
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Power blanks the displays: the page is hidden behind black, media is
+// paused and scrolling and auto-reload stop, so the browser does next to
+// no work. In "auto" mode (the default) the screens are off between OffFrom
+// and OffUntil, local "HH:MM" (POWER_OFF_FROM, POWER_OFF_UNTIL); "on" and
+// "off" override the schedule. POWER_ON_COMMAND and POWER_OFF_COMMAND run
+// when the state flips, e.g. to switch the panel itself via HDMI-CEC
+// ("cec-ctl --to 0 --standby") or DPMS ("xset dpms force off").
+type Power struct {
+	Mode     string `json:"mode"`
+	OffFrom  string `json:"offFrom,omitempty"`
+	OffUntil string `json:"offUntil,omitempty"`
+}
+
+var powerModes = map[string]bool{"auto": true, "on": true, "off": true}
+
+func (p *Power) validate() error {
+	if p.Mode == "" {
+		p.Mode = "auto"
+	}
+	if !powerModes[p.Mode] {
+		return errors.New("mode must be auto, on or off")
+	}
+	if (p.OffFrom == "") != (p.OffUntil == "") {
+		return errors.New("offFrom and offUntil must be set together")
+	}
+	for _, s := range []string{p.OffFrom, p.OffUntil} {
+		if s != "" {
+			if _, err := parseClock(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func initPower() error {
+	p := Power{OffFrom: setting("POWER_OFF_FROM"), OffUntil: setting("POWER_OFF_UNTIL")}
+	if err := p.validate(); err != nil {
+		return err
+	}
+	state.Modify(func(c *Config) { c.Power = p })
+	return nil
+}
+
+// powerOff reports whether the displays are blanked at now.
+func powerOff(config Config, now time.Time) bool {
+	p := config.Power
+	switch p.Mode {
+	case "on":
+		return false
+	case "off":
+		return true
+	}
+	if p.OffFrom == "" {
+		return false
+	}
+	start, _ := parseClock(p.OffFrom)
+	end, _ := parseClock(p.OffUntil)
+	return inClockWindow(now, start, end)
+}
+
+// initPowerSchedule reloads displays and runs the power hooks whenever the
+// displays go on or off, by schedule or by request.
+func initPowerSchedule() {
+	changes := subscribeEvents(EventConfigChanged)
+	last := powerOff(state.Snapshot(), time.Now())
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for {
+			scheduled := false
+			select {
+			case <-changes:
+			case <-ticker.C:
+				scheduled = true
+			}
+			off := powerOff(state.Snapshot(), time.Now())
+			if off == last {
+				continue
+			}
+			last = off
+			slog.Info("display power changed", "off", off, "scheduled", scheduled)
+			if scheduled {
+				touchConfig()
+			}
+			word, name := "on", "POWER_ON_COMMAND"
+			if off {
+				word, name = "off", "POWER_OFF_COMMAND"
+			}
+			notify(EventPower, "displays switched "+word, map[string]interface{}{"off": off, "scheduled": scheduled})
+			if argv := strings.Fields(setting(name)); len(argv) > 0 {
+				go runPowerHook(name, argv)
+			}
+		}
+	}()
+}
+
+// runPowerHook runs POWER_ON_COMMAND or POWER_OFF_COMMAND without a shell.
+func runPowerHook(name string, argv []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryCommandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		slog.Error("power command failed", "setting", name, "err", err, "output", string(out))
+		return
+	}
+	slog.Info("power command finished", "setting", name, "output", string(out))
+}
+
+// SetPowerMode switches the displays on, off or back to the schedule.
+func SetPowerMode(mode string) {
+	state.Update("", func(c *Config) { c.Power.Mode = mode })
+}
+
+// apiPowerHandler sets the mode named by the path (POST /api/power/on,
+// /off or /auto) and reports the state.
+func apiPowerHandler(mode string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		SetPowerMode(mode)
+		slog.Info("display power mode changed", "mode", mode)
+		recordAudit("power", map[string]interface{}{"mode": mode})
+		apiPowerStatusHandler(w, r)
+	}
+}
+
+// apiPowerStatusHandler reports the mode, the schedule and whether the
+// displays are off now.
+func apiPowerStatusHandler(w http.ResponseWriter, r *http.Request) {
+	config := state.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"power": config.Power,
+		"off":   powerOff(config, time.Now()),
+	})
+}
+
+// powerOffMarkup blanks the page and pauses its media.
+const powerOffMarkup = `<style>html,body{background:#000!important;cursor:none!important}body{visibility:hidden!important}</style>
+<script>
+(() => {
+    const pause = () => document.querySelectorAll('video,audio').forEach(m => m.pause());
+    document.addEventListener('DOMContentLoaded', pause);
+    document.addEventListener('play', pause, true);
+})();
+</script>
+`
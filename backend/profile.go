@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The display browser's profile is cleared with Clear-Site-Data: a clear
+// request is recorded against a config version, displays see it on their
+// next version poll, fetch /api/profile/clear-site-data to have the browser
+// drop the data, and reload. Since every page is served from this origin,
+// that covers the proxied site.
+type siteDataClear struct {
+	version int64
+	types   []string
+}
+
+const maxSiteDataClears = 20
+
+var (
+	siteDataClears []siteDataClear
+	siteDataMutex  sync.Mutex
+
+	siteDataTypes = map[string]bool{"cache": true, "cookies": true, "storage": true}
+)
+
+func requestSiteDataClear(types ...string) {
+	version := state.Update("", nil)
+
+	siteDataMutex.Lock()
+	siteDataClears = append(siteDataClears, siteDataClear{version: version, types: types})
+	if len(siteDataClears) > maxSiteDataClears {
+		siteDataClears = siteDataClears[len(siteDataClears)-maxSiteDataClears:]
+	}
+	siteDataMutex.Unlock()
+}
+
+// pendingSiteDataClear returns the data types cleared since version since.
+func pendingSiteDataClear(since int64) []string {
+	siteDataMutex.Lock()
+	defer siteDataMutex.Unlock()
+	seen := map[string]bool{}
+	types := []string{}
+	for _, c := range siteDataClears {
+		if c.version <= since {
+			continue
+		}
+		for _, t := range c.types {
+			if !seen[t] {
+				seen[t] = true
+				types = append(types, t)
+			}
+		}
+	}
+	return types
+}
+
+// clearServerCookies empties the cookie jar the proxy replays upstream.
+func clearServerCookies() {
+	state.Modify(func(c *Config) { c.CookieJar = []Cookie{} })
+	if err := saveCookies(); err != nil {
+		slog.Error("failed to save cookies", "err", err)
+	}
+}
+
+// profileClearHandler returns a handler that clears the given site data
+// types; clearing cookies also empties the server-side cookie jar.
+func profileClearHandler(types ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		for _, t := range types {
+			if t == "cookies" {
+				clearServerCookies()
+			}
+		}
+		requestSiteDataClear(types...)
+		slog.Info("display profile cleared", "types", types)
+		recordAudit("profile_clear", map[string]interface{}{"types": types})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"cleared": types})
+	}
+}
+
+// apiProfileClearSiteDataHandler sends Clear-Site-Data for ?types=.
+func apiProfileClearSiteDataHandler(w http.ResponseWriter, r *http.Request) {
+	quoted := []string{}
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if siteDataTypes[t] {
+			quoted = append(quoted, `"`+t+`"`)
+		}
+	}
+	if len(quoted) == 0 {
+		http.Error(w, "No valid types", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Clear-Site-Data", strings.Join(quoted, ", "))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Named profiles keep separate cookie jars, e.g. "work", "guest" and
+// "demo". The default profile uses DATA_DIR/cookies.json; others live in
+// DATA_DIR/profiles/<name>/. The active profile is remembered across
+// restarts, and PROFILE selects one at startup.
+const defaultProfile = "default"
+
+var (
+	activeProfile = defaultProfile
+	profileMutex  sync.Mutex
+)
+
+func profileCookiePath(name string) string {
+	if name == defaultProfile {
+		return filepath.Join(dataDir, "cookies.json")
+	}
+	return filepath.Join(dataDir, "profiles", name, "cookies.json")
+}
+
+func initProfiles() error {
+	profileMutex.Lock()
+	activeProfile = defaultProfile
+	profileMutex.Unlock()
+
+	name := setting("PROFILE")
+	if name == "" {
+		data, _ := os.ReadFile(filepath.Join(dataDir, "profile"))
+		name = strings.TrimSpace(string(data))
+	}
+	if name == "" || name == defaultProfile {
+		return nil
+	}
+	return useProfile(name)
+}
+
+// useProfile points the cookie jar at the named profile and loads it.
+func useProfile(name string) error {
+	if !scriptNameRe.MatchString(name) {
+		return errors.New("invalid profile name")
+	}
+	path := profileCookiePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	cookieMutex.Lock()
+	cookiePath = path
+	cookieMutex.Unlock()
+	state.Modify(func(c *Config) { c.CookieJar = []Cookie{} })
+	if err := loadCookies(); err != nil {
+		return err
+	}
+	profileMutex.Lock()
+	activeProfile = name
+	profileMutex.Unlock()
+	return os.WriteFile(filepath.Join(dataDir, "profile"), []byte(name+"\n"), 0644)
+}
+
+func currentProfile() string {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	return activeProfile
+}
+
+func listProfiles() []string {
+	names := []string{defaultProfile}
+	entries, _ := os.ReadDir(filepath.Join(dataDir, "profiles"))
+	for _, e := range entries {
+		if e.IsDir() && scriptNameRe.MatchString(e.Name()) && e.Name() != defaultProfile {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+// switchProfile saves the current jar, loads the named one and has the
+// displays drop the previous profile's browser-side data and reload.
+func switchProfile(name string) error {
+	if name == currentProfile() {
+		return nil
+	}
+	if err := saveCookies(); err != nil {
+		slog.Error("failed to save cookies", "err", err)
+	}
+	if err := useProfile(name); err != nil {
+		return err
+	}
+	requestSiteDataClear("cookies", "storage", "cache")
+	return nil
+}
+
+// apiConfigProfileHandler lists profiles and the active one (GET) or
+// switches to ?name= (POST), creating it if needed.
+func apiConfigProfileHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		name := r.URL.Query().Get("name")
+		previous := currentProfile()
+		if err := switchProfile(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("profile switched", "from", previous, "to", name)
+		recordAudit("profile_switch", map[string]interface{}{"from": previous, "to": name})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"active": currentProfile(), "profiles": listProfiles()})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// A read-only viewer sees the page but cannot interact with it: the page
+// blocks clicks and keys like a locked display, and the server refuses its
+// requests that could change something, on the target or here. A display
+// becomes read-only through /api/clients/readonly, or for every new display
+// with VIEWERS_READ_ONLY=true; only an administrator can turn it back. A
+// browser holding VIEWER_TOKEN (opened as /?viewer=<token>, then kept in a
+// cookie) is always read-only, whatever its client entry says.
+
+const viewerCookie = "ctrl_viewer"
+
+type readOnlyKey struct{}
+
+// hasViewerToken reports whether r carries VIEWER_TOKEN, in ?viewer= or
+// in the cookie set when the link was opened.
+func hasViewerToken(r *http.Request) bool {
+	token := setting("VIEWER_TOKEN")
+	if token == "" {
+		return false
+	}
+	given := r.URL.Query().Get("viewer")
+	if given == "" {
+		if c, err := r.Cookie(viewerCookie); err == nil {
+			given = c.Value
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// readOnlyReports are the calls a read-only page still makes to report on
+// itself.
+var readOnlyReports = map[string]bool{
+	"/api/report-height":     true,
+	"/api/heartbeat":         true,
+	"/api/analytics/beacon":  true,
+	"/api/page-logs/report":  true,
+	"/api/page-perf/report":  true,
+	"/api/automation/result": true,
+}
+
+func readOnlyAllowed(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return readOnlyReports[r.URL.Path]
+}
+
+func withReadOnly(r *http.Request) *http.Request {
+	q := r.URL.Query()
+	if q.Has("viewer") {
+		q.Del("viewer")
+		r.URL.RawQuery = q.Encode()
+	}
+	return r.WithContext(context.WithValue(r.Context(), readOnlyKey{}, true))
+}
+
+// viewerConfig marks config for rendering a page to a read-only viewer.
+func viewerConfig(r *http.Request, config Config) Config {
+	config.ReadOnlyViewer, _ = r.Context().Value(readOnlyKey{}).(bool)
+	return config
+}
+
+// apiClientReadOnlyHandler makes the display ?id= read-only
+// (readonly=true, the default) or lets it interact again (readonly=false).
+func apiClientReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	readOnly := true
+	if v := r.URL.Query().Get("readonly"); v != "" {
+		var err error
+		if readOnly, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "readonly must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	clientsMutex.Lock()
+	c, ok := clients[id]
+	if ok {
+		c.ReadOnly = readOnly
+	}
+	clientsMutex.Unlock()
+	if !ok {
+		http.Error(w, "Unknown client", http.StatusNotFound)
+		return
+	}
+	slog.Info("display client interaction changed", "id", id, "readOnly", readOnly)
+	recordAudit("client_readonly", map[string]interface{}{"id": id, "readOnly": readOnly})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "readOnly": readOnly})
+}
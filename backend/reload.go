@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// HardReload makes displays drop their cache and reload.
+func HardReload() {
+	state.Update("", func(c *Config) { c.HardReloadVersion = c.LastModified })
+}
+
+// apiReloadHandler reloads every display; with ?hard=true they clear their
+// cache first.
+func apiReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hard := r.URL.Query().Get("hard") == "true"
+	if hard {
+		HardReload()
+	} else {
+		touchConfig()
+	}
+	slog.Info("display reload requested", "hard", hard)
+	notify(EventReload, "displays reloaded", map[string]interface{}{"hard": hard})
+	recordAudit("reload", map[string]interface{}{"hard": hard})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"hard": hard, "lastModified": state.Snapshot().LastModified})
+}
+
+// apiReloadClearCacheHandler is fetched by displays before a hard reload.
+// Everything is served from this origin, so clearing its cache covers the
+// proxied site's assets too.
+func apiReloadClearCacheHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Clear-Site-Data", `"cache"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// autoReloadScript reloads the page every config.reloadInterval seconds.
+// In "changed" mode it first asks the server whether the page changed (ETag
+// or Last-Modified from a HEAD request, falling back to comparing a hash of
+// the body) and skips the reload when it didn't; a reloadProbe expression,
+// if set, decides instead: the page reloads when it evaluates truthy. With
+// reloadHard the cache is cleared before each reload.
+const autoReloadScript = `
+<script>
+(() => {
+    const hash = (s) => { let h = 2166136261; for (let i = 0; i < s.length; i++) h = Math.imul(h ^ s.charCodeAt(i), 16777619); return h >>> 0; };
+    const fingerprint = () => fetch(location.href, { method: 'HEAD', cache: 'no-store' })
+        .then(res => {
+            const tag = res.headers.get('ETag') || res.headers.get('Last-Modified');
+            if (tag) return tag;
+            return fetch(location.href, { cache: 'no-store' }).then(r => r.text()).then(t => 'h' + hash(t));
+        });
+    const reload = () => config.reloadHard ? window.ctrlHardReload() : (window.ctrlReload || (() => window.location.reload()))();
+    let baseline = null;
+    if (config.reloadMode === 'changed' && !config.reloadProbe) fingerprint().then(f => baseline = f).catch(() => {});
+    setInterval(() => {
+        if (config.reloadProbe) {
+            let stale = false;
+            try { stale = !!new Function('return (' + config.reloadProbe + ')')(); } catch (e) {}
+            if (stale) reload();
+        } else if (config.reloadMode === 'changed') {
+            fingerprint().then(f => {
+                if (baseline === null) baseline = f;
+                else if (f !== baseline) reload();
+            }).catch(() => {});
+        } else {
+            reload();
+        }
+    }, config.reloadInterval * 1000);
+})();
+</script>
+`
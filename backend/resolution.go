@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Resolution is the size pages are laid out at, independent of the screen
+// the display actually has; the page is then zoomed to fit. Zero means the
+// display's own resolution.
+type Resolution struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+const maxResolution = 7680
+
+var resolutionPath string
+
+func (res Resolution) validate() error {
+	if res.Width < 0 || res.Height < 0 || res.Width > maxResolution || res.Height > maxResolution {
+		return fmt.Errorf("width and height must be between 0 and %d", maxResolution)
+	}
+	if (res.Width == 0) != (res.Height == 0) {
+		return errors.New("width and height must be set together")
+	}
+	return nil
+}
+
+// parseResolution reads "3840x2160".
+func parseResolution(s string) (Resolution, error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	width, err1 := strconv.Atoi(strings.TrimSpace(w))
+	height, err2 := strconv.Atoi(strings.TrimSpace(h))
+	if !ok || err1 != nil || err2 != nil {
+		return Resolution{}, fmt.Errorf("invalid resolution %q, expected WIDTHxHEIGHT", s)
+	}
+	res := Resolution{Width: width, Height: height}
+	return res, res.validate()
+}
+
+// initResolution reads RESOLUTION; a resolution set at runtime and saved
+// in DATA_DIR/resolution.json takes precedence.
+func initResolution() error {
+	resolutionPath = filepath.Join(dataDir, "resolution.json")
+	var res Resolution
+	if raw := setting("RESOLUTION"); raw != "" {
+		var err error
+		if res, err = parseResolution(raw); err != nil {
+			return err
+		}
+	}
+	if data, err := os.ReadFile(resolutionPath); err == nil {
+		var saved Resolution
+		if err := json.Unmarshal(data, &saved); err != nil || saved.validate() != nil {
+			slog.Warn("ignoring invalid saved resolution", "path", resolutionPath)
+		} else {
+			res = saved
+		}
+	}
+	state.Modify(func(c *Config) { c.Resolution = res })
+	return nil
+}
+
+// SetResolution changes the layout resolution, saves it so it survives a
+// restart, and reloads the displays.
+func SetResolution(res Resolution) {
+	state.Update("", func(c *Config) { c.Resolution = res })
+	saveResolution(res)
+}
+
+func saveResolution(res Resolution) {
+	data, _ := json.Marshal(res)
+	if err := os.WriteFile(resolutionPath, data, 0644); err != nil {
+		slog.Error("failed to save resolution", "err", err)
+	}
+}
+
+// resolutionScript lays the page out at the configured size and zooms it
+// to fit the window, letterboxing when the aspect ratios differ. A device
+// width from DEVICE takes precedence.
+func resolutionScript(config Config) string {
+	res := config.Resolution
+	if res.Width == 0 || config.Device.resolve().Width > 0 {
+		return ""
+	}
+	return fmt.Sprintf(resolutionTemplate, res.Width, res.Height)
+}
+
+const resolutionTemplate = `<script>
+(() => {
+    const w = %d, h = %d;
+    const zoom = () => Math.min(window.outerWidth / w, window.outerHeight / h);
+    const apply = () => {
+        const root = document.documentElement;
+        root.style.width = w + 'px';
+        root.style.minHeight = h + 'px';
+        root.style.zoom = zoom();
+    };
+    apply();
+    window.addEventListener('resize', apply);
+    const define = (obj, prop, get) => { try { Object.defineProperty(obj, prop, { get, configurable: true }); } catch (e) {} };
+    define(window, 'innerWidth', () => w);
+    define(window, 'innerHeight', () => h);
+    define(screen, 'width', () => w);
+    define(screen, 'height', () => h);
+})();
+</script>`
+
+// apiConfigResolutionHandler returns (GET), sets (POST ?w=&h=) or clears
+// (DELETE) the layout resolution.
+func apiConfigResolutionHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		var res Resolution
+		if r.Method == http.MethodPost {
+			var err error
+			if res, err = parseResolution(r.URL.Query().Get("w") + "x" + r.URL.Query().Get("h")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		SetResolution(res)
+		slog.Info("resolution changed", "width", res.Width, "height", res.Height)
+		recordAudit("resolution", map[string]interface{}{"width": res.Width, "height": res.Height})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state.Snapshot().Resolution)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rewrite tracing (REWRITE_DEBUG=true) records which rewrite rules fired for
+// each proxied text response and which target URLs survived unrewritten,
+// so a broken mirror can be diagnosed from /api/proxy/rewrites.
+const (
+	rewriteHistorySize = 100
+	maxEscapedPerTrace = 20
+)
+
+var quotedURLRe = regexp.MustCompile(`['"]((?:https?:)?//[^'"\s]+)`)
+
+type RewriteTrace struct {
+	URL         string         `json:"url"`
+	ContentType string         `json:"contentType"`
+	Time        int64          `json:"time"`
+	Rules       map[string]int `json:"rules"`
+	Masked      int            `json:"masked"`
+	External    int            `json:"external"`
+	Escaped     []string       `json:"escaped,omitempty"`
+}
+
+var (
+	rewriteHistory []RewriteTrace
+	rewriteTotals  = map[string]int{}
+	rewriteMutex   sync.Mutex
+)
+
+func rewriteDebugEnabled() bool {
+	return setting("REWRITE_DEBUG") == "true"
+}
+
+// newRewriteTrace returns nil when tracing is off; all methods accept a nil
+// receiver so call sites don't need to check.
+func newRewriteTrace(u, contentType string) *RewriteTrace {
+	if !rewriteDebugEnabled() {
+		return nil
+	}
+	return &RewriteTrace{URL: u, ContentType: contentType, Time: time.Now().UnixMilli(), Rules: map[string]int{}}
+}
+
+func (t *RewriteTrace) hit(rule string, n int) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.Rules[rule] += n
+}
+
+func (t *RewriteTrace) resolved(masked bool) {
+	if t == nil {
+		return
+	}
+	if masked {
+		t.Masked++
+	} else {
+		t.External++
+	}
+}
+
+// finish scans the rewritten body for absolute URLs that still point at the
+// target host and stores the trace.
+func (t *RewriteTrace) finish(body, targetHost string) {
+	if t == nil {
+		return
+	}
+	for _, m := range quotedURLRe.FindAllStringSubmatch(body, -1) {
+		rest := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(m[1], "https:"), "http:"), "//")
+		if host, _, _ := strings.Cut(rest, "/"); host == targetHost {
+			t.Escaped = append(t.Escaped, m[1])
+			if len(t.Escaped) >= maxEscapedPerTrace {
+				break
+			}
+		}
+	}
+
+	slog.Debug("proxy rewrite", "url", t.URL, "rules", t.Rules, "masked", t.Masked, "external", t.External, "escaped", len(t.Escaped))
+
+	rewriteMutex.Lock()
+	defer rewriteMutex.Unlock()
+	for rule, n := range t.Rules {
+		rewriteTotals[rule] += n
+	}
+	rewriteTotals["escaped"] += len(t.Escaped)
+	rewriteHistory = append(rewriteHistory, *t)
+	if len(rewriteHistory) > rewriteHistorySize {
+		rewriteHistory = rewriteHistory[len(rewriteHistory)-rewriteHistorySize:]
+	}
+}
+
+func apiProxyRewritesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		rewriteMutex.Lock()
+		rewriteHistory = nil
+		rewriteTotals = map[string]int{}
+		rewriteMutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rewriteMutex.Lock()
+	history := append([]RewriteTrace(nil), rewriteHistory...)
+	totals := make(map[string]int, len(rewriteTotals))
+	for k, v := range rewriteTotals {
+		totals[k] = v
+	}
+	rewriteMutex.Unlock()
+
+	if u := r.URL.Query().Get("url"); u != "" {
+		filtered := history[:0]
+		for _, t := range history {
+			if t.URL == u {
+				filtered = append(filtered, t)
+			}
+		}
+		history = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":   rewriteDebugEnabled(),
+		"totals":    totals,
+		"responses": history,
+	})
+}
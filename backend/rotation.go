@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Rotation turns the picture for screens mounted in portrait or upside
+// down. Top-level page loads get a small wrapper page holding the real page
+// in a rotated frame, so the page lays out, scrolls and takes input in its
+// own (rotated) orientation. Requests from inside the frame are told apart
+// by their Sec-Fetch-Dest header.
+var rotations = map[int]string{
+	90:  "width:100vh;height:100vw;transform:translateX(100vw) rotate(90deg)",
+	180: "width:100vw;height:100vh;transform:translate(100vw,100vh) rotate(180deg)",
+	270: "width:100vh;height:100vw;transform:translateY(100vh) rotate(270deg)",
+}
+
+var errInvalidRotation = errors.New("rotation must be 0, 90, 180 or 270")
+
+func validRotation(deg int) bool {
+	_, ok := rotations[deg]
+	return ok || deg == 0
+}
+
+func initRotation() error {
+	raw := setting("ROTATION")
+	if raw == "" {
+		return nil
+	}
+	deg, err := strconv.Atoi(raw)
+	if err != nil || !validRotation(deg) {
+		return errInvalidRotation
+	}
+	state.Modify(func(c *Config) { c.Rotation = deg })
+	return nil
+}
+
+// SetRotation changes the rotation; displays pick it up through the
+// wrapper page's poll.
+func SetRotation(deg int) {
+	state.Update("", func(c *Config) { c.Rotation = deg })
+}
+
+var rotationTemplate = template.Must(template.New("rotation").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+html,body{margin:0;height:100%;background:#000;overflow:hidden}
+iframe{position:absolute;top:0;left:0;border:0;display:block;transform-origin:0 0;{{.Style}}}
+</style>
+</head>
+<body>
+<iframe src="{{.Src}}" allow="autoplay; fullscreen; clipboard-read; clipboard-write"></iframe>
+<script>
+setInterval(() => fetch('/api/config/rotation', { cache: 'no-store' }).then(res => res.json())
+    .then(r => { if (r.rotation !== {{.Rotation}}) location.reload(); }).catch(() => {}), 2000);
+</script>
+</body>
+</html>
+`))
+
+// rotated serves the wrapper page instead of top-level documents while a
+// rotation is set.
+func rotated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deg := state.Snapshot().Rotation
+		if deg == 0 || r.Method != http.MethodGet || r.Header.Get("Sec-Fetch-Dest") != "document" {
+			next(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		rotationTemplate.Execute(w, map[string]interface{}{
+			"Style":    template.CSS(rotations[deg]),
+			"Src":      r.URL.RequestURI(),
+			"Rotation": deg,
+			"Name":     deviceID(),
+		})
+	}
+}
+
+// apiConfigRotationHandler returns (GET, open to displays) or sets
+// (POST ?degrees=90) the rotation.
+func apiConfigRotationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		requireAdminIfConfigured(func(w http.ResponseWriter, r *http.Request) {
+			deg, err := strconv.Atoi(r.URL.Query().Get("degrees"))
+			if err != nil || !validRotation(deg) {
+				http.Error(w, errInvalidRotation.Error(), http.StatusBadRequest)
+				return
+			}
+			SetRotation(deg)
+			slog.Info("rotation changed", "degrees", deg)
+			recordAudit("rotation", map[string]interface{}{"degrees": deg})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"rotation": deg})
+		})(w, r)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"rotation": state.Snapshot().Rotation})
+}
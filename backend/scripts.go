@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Custom scripts are kept as .js files in DATA_DIR/scripts and added to
+// every proxied page, in name order, after the built-in scripts. CUSTOM_JS
+// adds one more (inline, or "@/path/file.js") that runs before them.
+type UserScript struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"`
+	Size   int    `json:"size"`
+}
+
+const maxScriptBytes = 256 * 1024
+
+var (
+	userScripts      = map[string]string{}
+	customJS         string
+	userScriptsMutex sync.RWMutex
+
+	scriptNameRe  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	scriptCloseRe = regexp.MustCompile(`(?i)</script`)
+)
+
+func scriptsDir() string {
+	return filepath.Join(dataDir, "scripts")
+}
+
+func initScripts() error {
+	userScriptsMutex.Lock()
+	defer userScriptsMutex.Unlock()
+	userScripts = map[string]string{}
+
+	customJS = setting("CUSTOM_JS")
+	if path, ok := strings.CutPrefix(customJS, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		customJS = string(data)
+	}
+
+	entries, err := os.ReadDir(scriptsDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".js")
+		if !ok || e.IsDir() || !scriptNameRe.MatchString(name) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(scriptsDir(), e.Name()))
+		if err != nil {
+			return err
+		}
+		userScripts[name] = string(data)
+	}
+	return nil
+}
+
+func listScripts() []UserScript {
+	userScriptsMutex.RLock()
+	defer userScriptsMutex.RUnlock()
+	list := make([]UserScript, 0, len(userScripts))
+	for name, src := range userScripts {
+		list = append(list, UserScript{Name: name, Size: len(src)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func saveScript(name, source string) error {
+	if err := os.MkdirAll(scriptsDir(), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(scriptsDir(), name+".js"), []byte(source), 0644); err != nil {
+		return err
+	}
+	userScriptsMutex.Lock()
+	userScripts[name] = source
+	userScriptsMutex.Unlock()
+	touchConfig()
+	return nil
+}
+
+func deleteScript(name string) bool {
+	userScriptsMutex.Lock()
+	_, ok := userScripts[name]
+	delete(userScripts, name)
+	userScriptsMutex.Unlock()
+	if !ok {
+		return false
+	}
+	if err := os.Remove(filepath.Join(scriptsDir(), name+".js")); err != nil && !os.IsNotExist(err) {
+		slog.Error("failed to delete script", "name", name, "err", err)
+	}
+	touchConfig()
+	return true
+}
+
+// userScriptTags renders CUSTOM_JS and the stored scripts. Each runs in its
+// own element so an error in one doesn't stop the rest.
+func userScriptTags() string {
+	userScriptsMutex.RLock()
+	defer userScriptsMutex.RUnlock()
+
+	sources := []string{}
+	if customJS != "" {
+		sources = append(sources, customJS)
+	}
+	names := make([]string, 0, len(userScripts))
+	for name := range userScripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sources = append(sources, userScripts[name])
+	}
+
+	var b strings.Builder
+	for _, src := range sources {
+		b.WriteString("<script>\n")
+		b.WriteString(scriptCloseRe.ReplaceAllString(src, `<\/script`))
+		b.WriteString("\n</script>")
+	}
+	return b.String()
+}
+
+// apiScriptsHandler lists scripts (GET), returns one (GET ?name=), creates
+// or replaces one (POST {"name","source"}) or deletes one (DELETE ?name=).
+func apiScriptsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"scripts": listScripts()})
+	case r.Method == http.MethodGet:
+		userScriptsMutex.RLock()
+		src, ok := userScripts[name]
+		userScriptsMutex.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UserScript{Name: name, Source: src, Size: len(src)})
+	case r.Method == http.MethodPost:
+		if !evaluateEnabled {
+			http.Error(w, "evaluation is disabled", http.StatusForbidden)
+			return
+		}
+		var s UserScript
+		r.Body = http.MaxBytesReader(w, r.Body, maxScriptBytes)
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if !scriptNameRe.MatchString(s.Name) {
+			http.Error(w, "Invalid name", http.StatusBadRequest)
+			return
+		}
+		if err := saveScript(s.Name, s.Source); err != nil {
+			slog.Error("failed to save script", "name", s.Name, "err", err)
+			http.Error(w, "Failed to save script", http.StatusInternalServerError)
+			return
+		}
+		recordAudit("script_set", map[string]interface{}{"name": s.Name, "size": len(s.Source)})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UserScript{Name: s.Name, Size: len(s.Source)})
+	case r.Method == http.MethodDelete:
+		if !deleteScript(name) {
+			http.NotFound(w, r)
+			return
+		}
+		recordAudit("script_delete", map[string]interface{}{"name": name})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Startup settings are named like environment variables (TARGET_URL) and
+// looked up, in order of precedence, in:
+//
+//   - command-line flags: --target-url=https://example.com
+//   - the environment
+//   - the settings file, SETTINGS_FILE (default ./settings.yml), a flat
+//     YAML file of "TARGET_URL: https://example.com" lines
+//   - the built-in default
+//
+// An empty value counts as unset, so each layer only overrides what it
+// sets. Edits to the settings file only change the liveSettings while
+// running; every other setting keeps the value the file had at startup.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+var (
+	flagSettings = map[string]string{}
+	fileSettings = map[string]string{}
+	// startupFileSettings is the settings file as read at startup, which
+	// settings that are not live are read from.
+	startupFileSettings = map[string]string{}
+	settingsPath        string
+	settingsMutex       sync.Mutex
+	// seenSettings holds every setting looked up, for /api/config/effective.
+	seenSettings = map[string]bool{}
+
+	secretSettings = map[string]bool{"ADMIN_TOKEN": true, "AUDIT_KEY": true, "WEBHOOK_URLS": true, "WATCHDOG_WEBHOOK_URL": true, "CONFIG_SYNC_TOKEN": true, "UNLOCK_PIN": true, "VIEWER_TOKEN": true}
+)
+
+// settingName turns "target-url", "target_url" or "TARGET_URL" into
+// "TARGET_URL".
+func settingName(s string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(s), "-", "_"))
+}
+
+// initSettings reads the command-line flags and the settings file.
+func initSettings(args []string) error {
+	flags := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unexpected argument %q", arg)
+		}
+		name, value, ok := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !ok {
+			if i+1 >= len(args) {
+				return fmt.Errorf("flag %s needs a value", arg)
+			}
+			i++
+			value = args[i]
+		}
+		flags[settingName(name)] = value
+	}
+
+	settingsMutex.Lock()
+	flagSettings = flags
+	settingsMutex.Unlock()
+
+	path := setting("SETTINGS_FILE")
+	if path == "" {
+		path = "settings.yml"
+	}
+	file, err := readSettingsFile(path)
+	if os.IsNotExist(err) && setting("SETTINGS_FILE") == "" {
+		file, err = map[string]string{}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("settings file %s: %w", path, err)
+	}
+	settingsMutex.Lock()
+	settingsPath = path
+	fileSettings = file
+	startupFileSettings = file
+	settingsMutex.Unlock()
+	return nil
+}
+
+// readSettingsFile parses "KEY: value" lines; blank lines and comments
+// are skipped and values may be quoted.
+func readSettingsFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSettings(f)
+}
+
+func parseSettings(r io.Reader) (map[string]string, error) {
+	out := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY: value", n)
+		}
+		out[settingName(key)] = yamlScalar(value)
+	}
+	return out, scanner.Err()
+}
+
+// yamlScalar unquotes a YAML scalar value or strips its trailing comment.
+// Double-quoted values may use backslash escapes, as writeSettingsFile does.
+func yamlScalar(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+	}
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		return strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+// lookupSetting returns the value of name and where it came from.
+func lookupSetting(name string) (string, string) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	seenSettings[name] = true
+	if v, ok := flagSettings[name]; ok && v != "" {
+		return v, sourceFlag
+	}
+	if v := os.Getenv(name); v != "" {
+		return v, sourceEnv
+	}
+	file := startupFileSettings
+	if _, ok := liveSettings[name]; ok {
+		file = fileSettings
+	}
+	if v := file[name]; v != "" {
+		return v, sourceFile
+	}
+	return "", sourceDefault
+}
+
+// setting returns the value of a startup setting, or "" to use the
+// default.
+func setting(name string) string {
+	v, _ := lookupSetting(name)
+	return v
+}
+
+// apiConfigEffectiveHandler lists every startup setting with its value and
+// source. Settings left at their default show an empty value; the values
+// in use are in /api/config.
+func apiConfigEffectiveHandler(w http.ResponseWriter, r *http.Request) {
+	settingsMutex.Lock()
+	names := make([]string, 0, len(seenSettings))
+	for name := range seenSettings {
+		names = append(names, name)
+	}
+	for _, m := range []map[string]string{flagSettings, fileSettings} {
+		for name := range m {
+			if !seenSettings[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	path := settingsPath
+	settingsMutex.Unlock()
+	sort.Strings(names)
+
+	list := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		value, source := lookupSetting(name)
+		if secretSettings[name] && value != "" {
+			value = "********"
+		}
+		list = append(list, map[string]interface{}{"name": name, "value": value, "source": source})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"settingsFile": path, "settings": list})
+}
+
+// liveSettings are the settings applied to the running configuration when
+// the settings file changes, with the Config field each one sets. Others
+// take effect on the next restart.
+var liveSettings = map[string]struct {
+	field string
+	parse func(string) (interface{}, error)
+}{
+	"TARGET_URL":           {"targetUrl", parseString},
+	"SCALE_FACTOR":         {"scaleFactor", parseFloat},
+	"AUTO_SCROLL":          {"autoScroll", parseBool},
+	"SCROLL_SPEED":         {"scrollSpeed", parseInt},
+	"SCROLL_SPEED_X":       {"scrollSpeedX", parseInt},
+	"SCROLL_DIRECTION":     {"scrollDirection", parseString},
+	"SCROLL_SEQUENCE":      {"scrollSequence", parseString},
+	"SCROLL_ANCHORS":       {"scrollAnchors", parseString},
+	"CAPTURE_SELECTOR":     {"captureSelector", parseString},
+	"LOCK":                 {"lock", parseString},
+	"INTERFACE_LOCKED":     {"lock", parseLegacyLock},
+	"ON_SCREEN_KEYBOARD":   {"keyboardEnabled", parseBool},
+	"AUTO_RELOAD_INTERVAL": {"reloadInterval", parseInt},
+	"AUTO_RELOAD_MODE":     {"reloadMode", parseString},
+}
+
+func parseString(s string) (interface{}, error) { return s, nil }
+func parseBool(s string) (interface{}, error)   { return s == "true", nil }
+func parseInt(s string) (interface{}, error)    { return strconv.Atoi(s) }
+func parseFloat(s string) (interface{}, error)  { return strconv.ParseFloat(s, 64) }
+
+// watchSettingsFile checks the settings file every few seconds and applies
+// edits to the live settings. A setting also given as a flag or in the
+// environment keeps that value.
+func watchSettingsFile() {
+	settingsMutex.Lock()
+	path := settingsPath
+	settingsMutex.Unlock()
+	var last time.Time
+	if fi, err := os.Stat(path); err == nil {
+		last = fi.ModTime()
+	}
+	go func() {
+		for range time.Tick(settingsPollInterval) {
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(last) {
+				continue
+			}
+			last = fi.ModTime()
+			reloadSettingsFile(path)
+		}
+	}()
+}
+
+const settingsPollInterval = 2 * time.Second
+
+func reloadSettingsFile(path string) {
+	file, err := readSettingsFile(path)
+	if err == nil {
+		var changes map[string]ConfigChange
+		changes, err = applyFileSettings(file)
+		if err == nil && len(changes) > 0 {
+			slog.Info("settings file reloaded", "path", path, "changed", len(changes))
+			recordAudit("settings_reload", map[string]interface{}{"changes": changes})
+		}
+	}
+	if err != nil {
+		slog.Error("settings file not reloaded", "path", path, "err", err)
+	}
+}
+
+// applyFileSettings replaces the settings file layer with file and applies
+// the live settings that changed. Nothing changes unless every value is
+// valid.
+func applyFileSettings(file map[string]string) (map[string]ConfigChange, error) {
+	settingsMutex.Lock()
+	previous := fileSettings
+	settingsMutex.Unlock()
+
+	patch := map[string]interface{}{}
+	var restart []string
+	for _, name := range changedSettings(previous, file) {
+		if _, source := lookupSetting(name); source == sourceFlag || source == sourceEnv {
+			continue
+		}
+		live, ok := liveSettings[name]
+		if !ok || file[name] == "" {
+			restart = append(restart, name)
+			continue
+		}
+		v, err := live.parse(file[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		patch[live.field] = v
+	}
+	next, changes, err := patchConfig(state.Snapshot(), patch)
+	if err != nil {
+		return nil, err
+	}
+
+	settingsMutex.Lock()
+	fileSettings = file
+	settingsMutex.Unlock()
+	if len(restart) > 0 {
+		slog.Warn("settings changed that take effect after a restart", "settings", restart)
+	}
+	if len(changes) > 0 {
+		applyConfigChanges(next, changes)
+	}
+	return changes, nil
+}
+
+// changedSettings lists the names whose value differs between a and b.
+func changedSettings(a, b map[string]string) []string {
+	var names []string
+	for name, v := range b {
+		if a[name] != v {
+			names = append(names, name)
+		}
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// A new display with neither a settings file nor a TARGET_URL shows a
+// setup page instead of the default target. It asks for the target,
+// resolution, admin token and instance name, writes them to the settings
+// file and applies them, so a display can be brought up without editing
+// environment variables and restarting. /api/setup only works until then.
+
+var setupPending atomic.Bool
+
+func initSetup() {
+	settingsMutex.Lock()
+	path := settingsPath
+	settingsMutex.Unlock()
+	_, err := os.Stat(path)
+	_, source := lookupSetting("TARGET_URL")
+	setupPending.Store(os.IsNotExist(err) && source == sourceDefault)
+}
+
+type setupRequest struct {
+	TargetURL    string `json:"targetUrl"`
+	Resolution   string `json:"resolution"`
+	AdminToken   string `json:"adminToken"`
+	InstanceName string `json:"instanceName"`
+}
+
+// apiSetupHandler reports whether setup is pending (GET) or completes it
+// (POST with a setupRequest).
+func apiSetupHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"pending": setupPending.Load()})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !setupPending.Load() {
+		http.Error(w, "Setup already done", http.StatusConflict)
+		return
+	}
+	var req setupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	u, err := normalizeTargetURL(req.TargetURL)
+	if err == nil {
+		err = checkTarget(u)
+	}
+	if err != nil {
+		http.Error(w, "targetUrl: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	file := map[string]string{"TARGET_URL": u.String()}
+	var res Resolution
+	if req.Resolution = strings.TrimSpace(req.Resolution); req.Resolution != "" {
+		if res, err = parseResolution(req.Resolution); err != nil {
+			http.Error(w, "resolution: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		file["RESOLUTION"] = req.Resolution
+	}
+	id := state.Snapshot().Identity
+	if req.InstanceName != "" {
+		id.Name = req.InstanceName
+		if err := id.validate(); err != nil {
+			http.Error(w, "instanceName: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		file["INSTANCE_NAME"] = id.Name
+	}
+	if req.AdminToken = strings.TrimSpace(req.AdminToken); req.AdminToken != "" {
+		file["ADMIN_TOKEN"] = req.AdminToken
+	}
+
+	// Two setup pages racing each other: the first one wins.
+	if !setupPending.CompareAndSwap(true, false) {
+		http.Error(w, "Setup already done", http.StatusConflict)
+		return
+	}
+	settingsMutex.Lock()
+	merged := maps.Clone(fileSettings)
+	settingsMutex.Unlock()
+	maps.Copy(merged, file)
+	if err := writeSettingsFile(merged, "Written by the setup page."); err != nil {
+		setupPending.Store(true)
+		slog.Error("setup failed", "err", err)
+		http.Error(w, "Failed to save settings: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := applyFileSettings(merged); err != nil {
+		slog.Error("setup settings not applied", "err", err)
+	}
+	// The display was never configured, so the answers count as the
+	// startup settings: ADMIN_TOKEN protects the API right away.
+	settingsMutex.Lock()
+	startupFileSettings = merged
+	settingsMutex.Unlock()
+	if req.Resolution != "" {
+		SetResolution(res)
+	}
+	if req.InstanceName != "" {
+		SetIdentity(id)
+	}
+	slog.Info("setup done", "target", u.String(), "name", id.Name)
+	recordAudit("setup", map[string]interface{}{"target": u.String(), "name": id.Name})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"pending": false, "targetUrl": u.String()})
+}
+
+var setupTemplate = template.Must(template.New("setup").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Set up this display</title>
+<style>
+html,body{margin:0;height:100%;background:#111;color:#eee;font-family:sans-serif}
+body{display:flex;align-items:center;justify-content:center}
+form{width:min(420px,90vw)}
+h1{font-weight:300}
+label{display:block;margin:1em 0 .3em;opacity:.8}
+input{box-sizing:border-box;width:100%;padding:.6em;font-size:1.1em;border-radius:4px;border:1px solid #555;background:#222;color:#eee}
+button{margin-top:1.5em;padding:.7em 1.4em;font-size:1.1em;border:0;border-radius:4px;background:#3a7bd5;color:#fff}
+#error{color:#e66;min-height:1.2em;margin-top:1em}
+</style>
+</head>
+<body>
+<form id="setup">
+<h1>Set up this display</h1>
+<label for="targetUrl">Page to show</label>
+<input id="targetUrl" name="targetUrl" placeholder="https://example.com" required>
+<label for="resolution">Resolution (optional)</label>
+<input id="resolution" name="resolution" placeholder="1920x1080">
+<label for="instanceName">Display name</label>
+<input id="instanceName" name="instanceName" value="{{.Name}}">
+<label for="adminToken">Admin token (optional, protects the control API)</label>
+<input id="adminToken" name="adminToken" type="password" autocomplete="new-password">
+<button type="submit">Start</button>
+<div id="error"></div>
+</form>
+<script>
+document.getElementById('setup').addEventListener('submit', e => {
+    e.preventDefault();
+    const body = Object.fromEntries(new FormData(e.target));
+    fetch('/api/setup', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify(body) })
+        .then(res => res.ok ? location.reload() : res.text().then(t => { document.getElementById('error').textContent = t; }))
+        .catch(err => { document.getElementById('error').textContent = err; });
+});
+</script>
+</body>
+</html>
+`))
+
+func serveSetup(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	setupTemplate.Execute(w, map[string]interface{}{"Name": state.Snapshot().Identity.Name})
+}
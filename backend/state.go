@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateManager owns the runtime configuration. Snapshot hands out copies
+// that share no memory with the live state, so readers never race with
+// writers; every change goes through Update or Modify. Update announces the
+// change on the event bus as EventConfigChanged.
+type StateManager struct {
+	mu     sync.RWMutex
+	config Config
+}
+
+var state = &StateManager{}
+
+// clone returns a copy of c that shares no slices or pointers with it.
+func (c Config) clone() Config {
+	c.HideSelectors = slices.Clone(c.HideSelectors)
+	c.CookieJar = slices.Clone(c.CookieJar)
+	c.Identity.Tags = slices.Clone(c.Identity.Tags)
+	if c.Emulation.Latitude != nil {
+		lat, lon := *c.Emulation.Latitude, *c.Emulation.Longitude
+		c.Emulation.Latitude, c.Emulation.Longitude = &lat, &lon
+	}
+	return c
+}
+
+// Snapshot returns a private copy of the current configuration.
+func (s *StateManager) Snapshot() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.clone()
+}
+
+// Modify changes the configuration without announcing a new version to
+// displays, for startup settings and server-side state such as cookies.
+func (s *StateManager) Modify(fn func(c *Config)) {
+	s.mu.Lock()
+	fn(&s.config)
+	s.mu.Unlock()
+}
+
+// Update records a change to setting and returns the new version. fn runs
+// after the version is bumped, so c.LastModified is already the new one.
+// Displays apply soft changes in place; anything else also bumps
+// ReloadVersion so they reload.
+func (s *StateManager) Update(setting string, fn func(c *Config)) int64 {
+	s.mu.Lock()
+	v := max(time.Now().UnixMilli(), s.config.LastModified+1)
+	s.config.LastModified = v
+	if !softApply(setting) {
+		s.config.ReloadVersion = v
+	}
+	if fn != nil {
+		fn(&s.config)
+	}
+	after := s.config.clone()
+	s.mu.Unlock()
+	notify(EventConfigChanged, "configuration changed", map[string]interface{}{
+		"version": v,
+		"setting": setting,
+		"reload":  !softApply(setting),
+		"config":  configView(after),
+	})
+	return v
+}
+
+// reloadOnFlip reloads displays whenever value, checked every minute,
+// changes with the time of day. Changes that come from config updates are
+// only recorded, since the update has already reloaded the displays.
+func reloadOnFlip(name string, value func(Config, time.Time) string) {
+	changes := subscribeEvents(EventConfigChanged)
+	last := value(state.Snapshot(), time.Now())
+	ticker := time.NewTicker(time.Minute)
+	go func() {
+		for {
+			select {
+			case <-changes:
+				last = value(state.Snapshot(), time.Now())
+			case <-ticker.C:
+				if v := value(state.Snapshot(), time.Now()); v != last {
+					last = v
+					slog.Info(name+" changed", "value", v)
+					touchConfig()
+				}
+			}
+		}
+	}()
+}
+
+// softSettings can be pushed into a running page without reloading it,
+// unless they are listed in RELOAD_ON_CHANGE.
+var softSettings = map[string]bool{"scrollspeed": true, "scrollsequence": true, "css": true}
+
+func softApply(name string) bool {
+	if !softSettings[name] {
+		return false
+	}
+	for _, s := range strings.Split(setting("RELOAD_ON_CHANGE"), ",") {
+		if strings.TrimSpace(s) == name {
+			return false
+		}
+	}
+	return true
+}
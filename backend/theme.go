@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Theme forces the prefers-color-scheme media query. Mode is "system"
+// (leave it alone), "light", "dark" or "auto", which is dark between sunset
+// and sunrise at the emulated geolocation, or between DarkFrom and
+// DarkUntil (local "HH:MM", 19:00–07:00 by default) without one. Invert
+// additionally inverts pages while dark, for sites with no dark styles.
+type Theme struct {
+	Mode      string `json:"mode"`
+	Invert    bool   `json:"invert,omitempty"`
+	DarkFrom  string `json:"darkFrom,omitempty"`
+	DarkUntil string `json:"darkUntil,omitempty"`
+}
+
+var (
+	themeModes    = map[string]bool{"system": true, "light": true, "dark": true, "auto": true}
+	colorSchemeRe = regexp.MustCompile(`(?i)\(\s*prefers-color-scheme\s*:\s*(dark|light)\s*\)`)
+)
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (t *Theme) validate() error {
+	if t.Mode == "" {
+		t.Mode = "system"
+	}
+	if !themeModes[t.Mode] {
+		return errors.New("mode must be system, light, dark or auto")
+	}
+	for _, s := range []string{t.DarkFrom, t.DarkUntil} {
+		if s != "" {
+			if _, err := parseClock(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sunTimes returns sunrise and sunset on the calendar date of day using the
+// standard sunrise equation. polar is "day" or "night" when the sun doesn't
+// rise or set at all.
+func sunTimes(day time.Time, lat, lon float64) (rise, set time.Time, polar string) {
+	rad := math.Pi / 180
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	jd := float64(noon.Unix())/86400 + 2440587.5
+	n := math.Round(jd - 2451545.0)
+	jStar := n - lon/360
+	m := math.Mod(357.5291+0.98560028*jStar, 360)
+	c := 1.9148*math.Sin(m*rad) + 0.02*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	lambda := math.Mod(m+c+180+102.9372, 360)
+	transit := 2451545.0 + jStar + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+	sinDecl := math.Sin(lambda*rad) * math.Sin(23.4397*rad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosOmega := (math.Sin(-0.833*rad) - math.Sin(lat*rad)*sinDecl) / (math.Cos(lat*rad) * cosDecl)
+	if cosOmega > 1 {
+		return time.Time{}, time.Time{}, "night"
+	}
+	if cosOmega < -1 {
+		return time.Time{}, time.Time{}, "day"
+	}
+	omega := math.Acos(cosOmega) / rad
+	toTime := func(j float64) time.Time {
+		return time.Unix(0, int64((j-2440587.5)*86400*float64(time.Second)))
+	}
+	return toTime(transit - omega/360), toTime(transit + omega/360), ""
+}
+
+// colorScheme returns the scheme to force at now, or "" to leave pages
+// alone.
+func colorScheme(config Config, now time.Time) string {
+	t := config.Theme
+	switch t.Mode {
+	case "light", "dark":
+		return t.Mode
+	case "auto":
+	default:
+		return ""
+	}
+	if isNight(config.Emulation, now, t.DarkFrom, t.DarkUntil) {
+		return "dark"
+	}
+	return "light"
+}
+
+// isNight reports whether now is between sunset and sunrise at the
+// emulated geolocation or, without one, between from and until (local
+// "HH:MM", 19:00–07:00 when empty).
+func isNight(e Emulation, now time.Time, from, until string) bool {
+	if e.Latitude != nil {
+		// Use the local solar date so rise and set bracket the local day.
+		solar := now.UTC().Add(time.Duration(*e.Longitude / 15 * float64(time.Hour)))
+		rise, set, polar := sunTimes(solar, *e.Latitude, *e.Longitude)
+		if polar != "" {
+			return polar == "night"
+		}
+		return now.Before(rise) || now.After(set)
+	}
+	start, end := 19*60, 7*60
+	if from != "" {
+		start, _ = parseClock(from)
+	}
+	if until != "" {
+		end, _ = parseClock(until)
+	}
+	return inClockWindow(now, start, end)
+}
+
+// inClockWindow reports whether now falls between the minutes of the day
+// start and end, which may wrap past midnight.
+func inClockWindow(now time.Time, start, end int) bool {
+	minute := now.Hour()*60 + now.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// applyColorScheme rewrites prefers-color-scheme media features in CSS or
+// HTML into ones that always or never match.
+func applyColorScheme(body, scheme string) string {
+	return colorSchemeRe.ReplaceAllStringFunc(body, func(m string) string {
+		if colorSchemeRe.FindStringSubmatch(m)[1] == scheme {
+			return "(min-width:0px)"
+		}
+		return "(max-width:-1px)"
+	})
+}
+
+// themeScript makes matchMedia agree with the forced scheme, sets the
+// color-scheme for built-in controls and optionally inverts the page.
+func themeScript(config Config) string {
+	scheme := colorScheme(config, time.Now())
+	if scheme == "" {
+		return ""
+	}
+	out := fmt.Sprintf(themeTemplate, scheme, scheme)
+	if scheme == "dark" && config.Theme.Invert {
+		out += `<style>html{filter:invert(1) hue-rotate(180deg)}img,video,picture,canvas,iframe{filter:invert(1) hue-rotate(180deg)}</style>`
+	}
+	return out
+}
+
+const themeTemplate = `<style>:root{color-scheme:%s}</style><script>
+(() => {
+    const scheme = '%s', orig = window.matchMedia.bind(window);
+    window.matchMedia = (q) => orig(String(q).replace(/\(\s*prefers-color-scheme\s*:\s*(dark|light)\s*\)/gi,
+        (m, s) => s.toLowerCase() === scheme ? '(min-width:0px)' : '(max-width:-1px)'));
+})();
+</script>`
+
+func initTheme() error {
+	t := Theme{
+		Mode:      setting("THEME"),
+		Invert:    setting("THEME_INVERT") == "true",
+		DarkFrom:  setting("THEME_DARK_FROM"),
+		DarkUntil: setting("THEME_DARK_UNTIL"),
+	}
+	if err := t.validate(); err != nil {
+		return err
+	}
+	state.Modify(func(c *Config) { c.Theme = t })
+	return nil
+}
+
+// initThemeSchedule reloads displays whenever the auto theme flips.
+func initThemeSchedule() {
+	reloadOnFlip("color scheme", colorScheme)
+}
+
+// apiConfigThemeHandler returns (GET) or replaces (POST) the theme
+// settings, along with the scheme currently in force.
+func apiConfigThemeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var t Theme
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := t.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		state.Update("", func(c *Config) { c.Theme = t })
+		slog.Info("theme changed", "mode", t.Mode)
+		recordAudit("theme", map[string]interface{}{"mode": t.Mode, "invert": t.Invert})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	config := state.Snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"theme":  config.Theme,
+		"scheme": colorScheme(config, time.Now()),
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ReadyWait describes when a freshly loaded page is considered ready to be
+// shown: an element is present, the network has gone quiet, a delay has
+// passed and/or a JS expression is truthy. Until then (or Timeout seconds,
+// 10 by default) the page stays hidden.
+type ReadyWait struct {
+	Selector    string  `json:"selector,omitempty"`
+	NetworkIdle bool    `json:"networkIdle,omitempty"`
+	Delay       float64 `json:"delay,omitempty"`
+	Script      string  `json:"script,omitempty"`
+	Timeout     float64 `json:"timeout,omitempty"`
+}
+
+func (r ReadyWait) empty() bool {
+	return r.Selector == "" && !r.NetworkIdle && r.Delay == 0 && r.Script == ""
+}
+
+func (r ReadyWait) validate() error {
+	if r.Selector != "" {
+		if err := validateSelectors([]string{r.Selector}); err != nil {
+			return err
+		}
+	}
+	if r.Delay < 0 || r.Timeout < 0 {
+		return errors.New("delay and timeout must not be negative")
+	}
+	return nil
+}
+
+// pageTransition returns the markup that keeps pages hidden until they are
+// ready and fades between them, so switching targets doesn't flash
+// half-rendered content. PAGE_TRANSITION=cut switches without fading and
+// TRANSITION_MS sets the fade duration.
+func pageTransition(ready ReadyWait) string {
+	ms, err := strconv.Atoi(setting("TRANSITION_MS"))
+	if err != nil || ms <= 0 {
+		ms = 600
+	}
+	if setting("PAGE_TRANSITION") == "cut" {
+		if ready.empty() {
+			return ""
+		}
+		ms = 0
+	}
+	readyJSON, _ := json.Marshal(ready)
+	return fmt.Sprintf(transitionTemplate, ms, ms, readyJSON)
+}
+
+// transitionTemplate hides the body until the page is ready (never longer
+// than the timeout, so a hanging resource can't keep the screen blank) and
+// defines ctrlReload, which the auto-reload logic uses to fade out first.
+const transitionTemplate = `
+<style>body{transition:opacity %dms ease-in-out}html.ctrl-hidden body{opacity:0}</style>
+<script>
+(() => {
+    const ms = %d, ready = %s, html = document.documentElement;
+    html.classList.add('ctrl-hidden');
+    let lastResource = Date.now(), loadedAt = 0, shown = false;
+    try { new PerformanceObserver(() => lastResource = Date.now()).observe({ type: 'resource' }); } catch (e) {}
+    const isReady = () => {
+        const now = Date.now();
+        if (!loadedAt) return false;
+        if (ready.delay && now - loadedAt < ready.delay * 1000) return false;
+        if (ready.networkIdle && now - lastResource < 500) return false;
+        if (ready.selector) { try { if (!document.querySelector(ready.selector)) return false; } catch (e) {} }
+        if (ready.script) { try { if (!new Function('return (' + ready.script + ')')()) return false; } catch (e) { return false; } }
+        return true;
+    };
+    const show = () => { if (!shown) { shown = true; html.classList.remove('ctrl-hidden'); } };
+    const check = () => { if (shown) return; if (isReady()) show(); else setTimeout(check, 200); };
+    window.addEventListener('load', () => { loadedAt = Date.now(); requestAnimationFrame(check); });
+    setTimeout(show, (ready.timeout || 10) * 1000);
+    window.ctrlReload = () => {
+        html.classList.add('ctrl-hidden');
+        setTimeout(() => window.location.reload(), ms);
+    };
+})();
+</script>
+`
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Uploads let an operator hand a file to the page on the display: the file
+// is held here until the display's script fetches it and attaches it to the
+// page's file input.
+const maxUploadBytes = 25 << 20
+
+type pendingUpload struct {
+	Seq  int    `json:"seq"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size int    `json:"size"`
+	Time int64  `json:"time"`
+	data []byte
+}
+
+var (
+	upload      pendingUpload
+	uploadMutex sync.RWMutex
+)
+
+func apiUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadBytes+1))
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxUploadBytes {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	uploadMutex.Lock()
+	upload = pendingUpload{
+		Seq:  upload.Seq + 1,
+		Name: header.Filename,
+		Type: contentType,
+		Size: len(data),
+		Time: time.Now().UnixMilli(),
+		data: data,
+	}
+	info := upload
+	uploadMutex.Unlock()
+
+	recordAudit("upload", map[string]interface{}{"name": info.Name, "size": info.Size})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// apiUploadPendingHandler tells the display about an upload newer than
+// ?since=.
+func apiUploadPendingHandler(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	uploadMutex.RLock()
+	info := upload
+	uploadMutex.RUnlock()
+
+	if info.Seq <= since || info.data == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func apiUploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	seq, _ := strconv.Atoi(r.URL.Query().Get("seq"))
+	uploadMutex.RLock()
+	info := upload
+	uploadMutex.RUnlock()
+
+	if info.data == nil || info.Seq != seq {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", info.Type)
+	w.Header().Set("Content-Length", strconv.Itoa(len(info.data)))
+	w.Write(info.data)
+}
+
+// uploadScript attaches operator uploads to the file input the user last
+// touched, or the first one on the page.
+const uploadScript = `
+<script>
+(() => {
+    let seq = -1, lastInput = null;
+    const remember = (e) => { if (e.target && e.target.type === 'file') lastInput = e.target; };
+    document.addEventListener('focusin', remember, true);
+    document.addEventListener('click', remember, true);
+    const poll = () => fetch('/api/upload/pending?since=' + Math.max(seq, 0), { cache: 'no-store' })
+        .then(res => res.status === 200 ? res.json() : null)
+        .then(info => {
+            if (!info) { if (seq < 0) seq = 0; return; }
+            const first = seq < 0;
+            seq = info.seq;
+            if (first) return;
+            const input = (lastInput && lastInput.isConnected) ? lastInput : document.querySelector('input[type=file]');
+            if (!input) return;
+            return fetch('/api/upload/file?seq=' + info.seq).then(res => res.blob()).then(blob => {
+                const dt = new DataTransfer();
+                dt.items.add(new File([blob], info.name, { type: info.type }));
+                input.files = dt.files;
+                input.dispatchEvent(new Event('input', { bubbles: true }));
+                input.dispatchEvent(new Event('change', { bubbles: true }));
+            });
+        })
+        .catch(() => {});
+    poll();
+    setInterval(poll, 3000);
+})();
+</script>
+`
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const defaultUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// DeviceEmulation makes the display pose as another device: the
+// User-Agent sent upstream and reported to scripts, a CSS viewport width
+// the page is laid out at (then zoomed to fill the screen) and touch
+// support. Preset fills in whatever is left empty.
+type DeviceEmulation struct {
+	Preset    string `json:"preset,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Touch     bool   `json:"touch,omitempty"`
+}
+
+var devicePresets = map[string]DeviceEmulation{
+	"iphone": {
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		Width:     393, Height: 852, Touch: true,
+	},
+	"ipad": {
+		UserAgent: "Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+		Width:     820, Height: 1180, Touch: true,
+	},
+	"pixel": {
+		UserAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+		Width:     412, Height: 915, Touch: true,
+	},
+}
+
+func devicePresetNames() []string {
+	names := make([]string, 0, len(devicePresets))
+	for name := range devicePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve fills unset fields from the preset.
+func (d DeviceEmulation) resolve() DeviceEmulation {
+	p, ok := devicePresets[d.Preset]
+	if !ok {
+		return d
+	}
+	if d.UserAgent == "" {
+		d.UserAgent = p.UserAgent
+	}
+	if d.Width == 0 {
+		d.Width, d.Height = p.Width, p.Height
+	}
+	d.Touch = d.Touch || p.Touch
+	return d
+}
+
+func (d DeviceEmulation) validate() error {
+	if d.Preset != "" {
+		if _, ok := devicePresets[d.Preset]; !ok {
+			return fmt.Errorf("unknown device preset %q (available: %s)", d.Preset, strings.Join(devicePresetNames(), ", "))
+		}
+	}
+	if strings.ContainsAny(d.UserAgent, "\r\n") {
+		return errors.New("invalid user agent")
+	}
+	if d.Width < 0 || d.Height < 0 || d.Width > 7680 || d.Height > 7680 {
+		return errors.New("width and height must be between 0 and 7680")
+	}
+	return nil
+}
+
+// userAgent is the User-Agent sent to the target.
+func (d DeviceEmulation) userAgent() string {
+	if ua := d.resolve().UserAgent; ua != "" {
+		return ua
+	}
+	return defaultUserAgent
+}
+
+func initDevice() error {
+	d := DeviceEmulation{Preset: strings.ToLower(setting("DEVICE")), UserAgent: setting("USER_AGENT")}
+	if err := d.validate(); err != nil {
+		return err
+	}
+	state.Modify(func(c *Config) { c.Device = d })
+	return nil
+}
+
+// SetDevice applies new device settings; displays reload so the target is
+// fetched again with the new User-Agent.
+func SetDevice(d DeviceEmulation) {
+	state.Update("", func(c *Config) { c.Device = d })
+}
+
+// deviceScript reports the emulated User-Agent and touch support to the
+// page, lays it out at the emulated width and turns mouse input into touch
+// events. Like emulationScript it must run before the page's own scripts.
+// Media queries still see the real screen width.
+func deviceScript(d DeviceEmulation) string {
+	d = d.resolve()
+	if d.UserAgent == "" && d.Width == 0 && !d.Touch {
+		return ""
+	}
+	data, _ := json.Marshal(d)
+	out := ""
+	if d.Width > 0 {
+		out = fmt.Sprintf(`<meta name="viewport" content="width=%d">`, d.Width)
+	}
+	return out + fmt.Sprintf(deviceTemplate, data)
+}
+
+const deviceTemplate = `<script>
+(() => {
+    const dev = %s;
+    const define = (obj, prop, get) => { try { Object.defineProperty(obj, prop, { get, configurable: true }); } catch (e) {} };
+    if (dev.userAgent) {
+        define(navigator, 'userAgent', () => dev.userAgent);
+        define(navigator, 'appVersion', () => dev.userAgent.replace(/^Mozilla\//, ''));
+        if (/iPhone|iPad/.test(dev.userAgent)) define(navigator, 'platform', () => /iPad/.test(dev.userAgent) ? 'iPad' : 'iPhone');
+        else if (/Android/.test(dev.userAgent)) define(navigator, 'platform', () => 'Linux armv8l');
+    }
+    if (dev.width) {
+        const zoom = () => window.outerWidth / dev.width;
+        const apply = () => {
+            const root = document.documentElement;
+            root.style.width = dev.width + 'px';
+            root.style.zoom = zoom();
+        };
+        apply();
+        window.addEventListener('resize', apply);
+        define(window, 'innerWidth', () => dev.width);
+        define(window, 'innerHeight', () => Math.round(window.outerHeight / zoom()));
+        define(screen, 'width', () => dev.width);
+        define(screen, 'height', () => dev.height || Math.round(screen.availHeight / zoom()));
+    }
+    if (dev.touch) {
+        define(navigator, 'maxTouchPoints', () => 5);
+        if (!('ontouchstart' in window)) window.ontouchstart = null;
+        let active = null;
+        const touch = (type, e) => {
+            if (typeof Touch !== 'function') return;
+            const t = new Touch({ identifier: 1, target: active, clientX: e.clientX, clientY: e.clientY,
+                pageX: e.pageX, pageY: e.pageY, screenX: e.screenX, screenY: e.screenY });
+            const list = type === 'touchend' ? [] : [t];
+            active.dispatchEvent(new TouchEvent(type, { bubbles: true, cancelable: true, composed: true,
+                touches: list, targetTouches: list, changedTouches: [t] }));
+        };
+        window.addEventListener('mousedown', (e) => { active = e.target; touch('touchstart', e); }, true);
+        window.addEventListener('mousemove', (e) => { if (active) touch('touchmove', e); }, true);
+        window.addEventListener('mouseup', (e) => { if (active) { touch('touchend', e); active = null; } }, true);
+    }
+})();
+</script>`
+
+// apiConfigDeviceHandler returns (GET) or replaces (POST) the device
+// emulation; an empty object goes back to the default desktop browser.
+func apiConfigDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var d DeviceEmulation
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		d.Preset = strings.ToLower(d.Preset)
+		if err := d.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		SetDevice(d)
+		slog.Info("device emulation changed", "preset", d.Preset, "width", d.Width, "touch", d.Touch)
+		recordAudit("device_emulation", map[string]interface{}{"preset": d.Preset, "userAgent": d.UserAgent, "width": d.Width, "touch": d.Touch})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d := state.Snapshot().Device
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device":    d,
+		"effective": d.resolve(),
+		"userAgent": d.userAgent(),
+		"presets":   devicePresetNames(),
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Viewport is the region of the page currently shown on the display. X and
+// Y are the top-left corner as fractions of the screen and Zoom how much the
+// region is magnified; Zoom 1 shows the whole page as usual. The display
+// applies it as a CSS transform, so clicks land on the element under the
+// pointer without any coordinate translation on our side.
+type Viewport struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Zoom float64 `json:"zoom"`
+}
+
+const maxViewportZoom = 8
+
+var (
+	viewport      = Viewport{Zoom: 1}
+	viewportMutex sync.RWMutex
+)
+
+func GetViewport() Viewport {
+	viewportMutex.RLock()
+	defer viewportMutex.RUnlock()
+	return viewport
+}
+
+// clamp keeps the visible region inside the screen.
+func (v *Viewport) clamp() error {
+	if v.Zoom < 1 || v.Zoom > maxViewportZoom {
+		return errors.New("zoom must be between 1 and 8")
+	}
+	limit := 1 - 1/v.Zoom
+	v.X = min(max(v.X, 0), limit)
+	v.Y = min(max(v.Y, 0), limit)
+	return nil
+}
+
+func setViewport(v Viewport) (Viewport, error) {
+	if err := v.clamp(); err != nil {
+		return Viewport{}, err
+	}
+	viewportMutex.Lock()
+	viewport = v
+	viewportMutex.Unlock()
+	return v, nil
+}
+
+// apiViewportHandler returns the current viewport (GET), sets it (POST) or
+// resets it to the full page (DELETE). POST also accepts a center point
+// ({"cx":0.75,"cy":0.5,"zoom":3}), which is handier for pinch gestures.
+func apiViewportHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetViewport())
+	case http.MethodPost:
+		requireAdminIfConfigured(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Viewport
+				CX *float64 `json:"cx"`
+				CY *float64 `json:"cy"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			v := req.Viewport
+			if v.Zoom == 0 {
+				v.Zoom = 1
+			}
+			if req.CX != nil && req.CY != nil {
+				v.X = *req.CX - 0.5/v.Zoom
+				v.Y = *req.CY - 0.5/v.Zoom
+			}
+			v, err := setViewport(v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(v)
+		})(w, r)
+	case http.MethodDelete:
+		requireAdminIfConfigured(func(w http.ResponseWriter, r *http.Request) {
+			setViewport(Viewport{Zoom: 1})
+			w.WriteHeader(http.StatusNoContent)
+		})(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// viewportScript zooms the display into the current viewport. Transforms on
+// the root element are composed through ctrlSetTransform so other features
+// (element capture) can add their own, and ctrlPagePoint maps pointer events
+// back to page coordinates through whatever is applied.
+const viewportScript = `
+<script>
+(() => {
+    const parts = {};
+    const html = document.documentElement;
+    window.ctrlSetTransform = (name, value) => {
+        parts[name] = value;
+        html.style.transformOrigin = '0 0';
+        html.style.transform = [parts.viewport, parts.capture].filter(Boolean).join(' ');
+    };
+    window.ctrlPagePoint = (e) => {
+        const t = getComputedStyle(html).transform;
+        const p = t && t !== 'none' ? new DOMMatrix(t).inverse().transformPoint(new DOMPoint(e.clientX, e.clientY)) : { x: e.clientX, y: e.clientY };
+        return { x: p.x + window.scrollX, y: p.y + window.scrollY };
+    };
+    let current = { x: 0, y: 0, zoom: 1 };
+    const apply = (v) => {
+        if (v.x === current.x && v.y === current.y && v.zoom === current.zoom) return;
+        current = v;
+        html.style.transition = 'transform .3s ease-out';
+        window.ctrlSetTransform('viewport', v.zoom === 1 ? '' :
+            'scale(' + v.zoom + ') translate(' + (-v.x * 100) + 'vw,' + (-v.y * 100) + 'vh)');
+    };
+    const poll = () => fetch('/api/viewport', { cache: 'no-store' }).then(res => res.json()).then(apply).catch(() => {});
+    poll();
+    setInterval(poll, 1000);
+})();
+</script>
+`
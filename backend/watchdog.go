@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The watchdog compares the fingerprint reported by each display's injected
+// page script (scroll position and DOM mutation count). When autoscroll is
+// on and a display that is still sending heartbeats hasn't moved its
+// fingerprint for WATCHDOG_TIMEOUT seconds, it is considered frozen.
+// Displays that stopped sending heartbeats are forgotten, so a watchdog
+// with no displays never trips.
+type watchdogState struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	action   string
+	displays map[string]*watchdogDisplay
+	trips    int
+	lastTrip time.Time
+}
+
+type watchdogDisplay struct {
+	fingerprint   string
+	lastHeartbeat time.Time
+	lastChange    time.Time
+}
+
+var watchdog watchdogState
+
+func initWatchdog() {
+	secs, _ := strconv.Atoi(setting("WATCHDOG_TIMEOUT"))
+	action := setting("WATCHDOG_ACTION")
+	if action == "" {
+		action = "reload"
+	}
+
+	watchdog.mu.Lock()
+	watchdog.timeout = time.Duration(secs) * time.Second
+	watchdog.action = action
+	watchdog.displays = map[string]*watchdogDisplay{}
+	watchdog.mu.Unlock()
+
+	if secs > 0 {
+		go runWatchdog()
+	}
+}
+
+func (s *watchdogState) heartbeat(client, fingerprint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timeout <= 0 {
+		return
+	}
+	now := time.Now()
+	d, ok := s.displays[client]
+	if !ok {
+		d = &watchdogDisplay{fingerprint: fingerprint, lastChange: now}
+		s.displays[client] = d
+	}
+	d.lastHeartbeat = now
+	if fingerprint != d.fingerprint {
+		d.fingerprint = fingerprint
+		d.lastChange = now
+	}
+}
+
+// frozen returns the displays whose fingerprint is stale, restarting their
+// period so the recovery action gets a full timeout before the next check,
+// and forgets displays that stopped sending heartbeats.
+func (s *watchdogState) frozen(now time.Time) map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stale := map[string]time.Duration{}
+	for client, d := range s.displays {
+		if now.Sub(d.lastHeartbeat) > s.timeout {
+			delete(s.displays, client)
+			continue
+		}
+		if age := now.Sub(d.lastChange); age > s.timeout {
+			stale[client] = age
+			d.lastChange = now
+		}
+	}
+	if len(stale) > 0 {
+		s.trips++
+		s.lastTrip = now
+	}
+	return stale
+}
+
+func runWatchdog() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if !state.Snapshot().AutoScroll {
+			continue
+		}
+		stale := watchdog.frozen(now)
+		if len(stale) == 0 {
+			continue
+		}
+		watchdog.mu.Lock()
+		action := watchdog.action
+		watchdog.mu.Unlock()
+		for client, age := range stale {
+			slog.Warn("watchdog: display appears frozen", "client", client, "stale", age.Round(time.Second), "action", action)
+			notify(EventWatchdogTrip, "display appears frozen", map[string]interface{}{
+				"client":   client,
+				"staleSec": int(age.Seconds()),
+				"action":   action,
+			})
+		}
+		if action == "reload" {
+			touchConfig()
+		}
+	}
+}
+
+func apiHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var hb struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+		http.Error(w, "Invalid heartbeat", http.StatusBadRequest)
+		return
+	}
+	client := r.RemoteAddr
+	if c, err := r.Cookie(clientCookie); err == nil {
+		client = c.Value
+	}
+	watchdog.heartbeat(client, hb.Fingerprint)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiWatchdogHandler(w http.ResponseWriter, r *http.Request) {
+	watchdog.mu.Lock()
+	displays := map[string]interface{}{}
+	for client, d := range watchdog.displays {
+		displays[client] = map[string]interface{}{
+			"lastHeartbeat": d.lastHeartbeat.UnixMilli(),
+			"lastChange":    d.lastChange.UnixMilli(),
+		}
+	}
+	status := map[string]interface{}{
+		"enabled":    watchdog.timeout > 0,
+		"timeoutSec": int(watchdog.timeout.Seconds()),
+		"action":     watchdog.action,
+		"displays":   displays,
+		"trips":      watchdog.trips,
+	}
+	if !watchdog.lastTrip.IsZero() {
+		status["lastTrip"] = watchdog.lastTrip.UnixMilli()
+	}
+	watchdog.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+)
+
+// deviceID identifies this display in watermarks and reports: the identity
+// name, by default DEVICE_ID or the host name.
+func deviceID() string {
+	return state.Snapshot().Identity.Name
+}
+
+// watermarkStyle returns a stylesheet that tiles a faint, rotated device ID
+// over the whole page so photos of the screen can be traced back to it.
+// Enabled with WATERMARK=true; WATERMARK_OPACITY tunes visibility.
+func watermarkStyle() string {
+	if setting("WATERMARK") != "true" {
+		return ""
+	}
+	opacity, err := strconv.ParseFloat(setting("WATERMARK_OPACITY"), 64)
+	if err != nil || opacity <= 0 || opacity > 1 {
+		opacity = 0.04
+	}
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="320" height="200">`+
+		`<text x="160" y="100" text-anchor="middle" transform="rotate(-25 160 100)" `+
+		`font-family="monospace" font-size="16" fill="#808080" fill-opacity="%.3f">%s</text></svg>`,
+		opacity, html.EscapeString(deviceID()))
+
+	return fmt.Sprintf(`<style>html::after{content:"";position:fixed;inset:0;pointer-events:none;`+
+		`z-index:2147483645;background-image:url("data:image/svg+xml,%s");}</style>`,
+		url.PathEscape(svg))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookEvents are the bus topics delivered to outgoing webhooks.
+var webhookEvents = []string{EventPageLoadFailed, EventWatchdogTrip, EventRecoveryAction, EventClockDrift, EventMemoryPressure, EventPower, EventLock, EventTargetChanged}
+
+const (
+	webhookAttempts    = 4
+	webhookBaseBackoff = time.Second
+	webhookDedupWindow = time.Minute
+)
+
+type webhook struct {
+	Kind string // "slack", "discord" or "json"
+	URL  string
+}
+
+var (
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+	recentEvents  = map[string]time.Time{}
+	recentMutex   sync.Mutex
+)
+
+// webhooks parses WEBHOOK_URLS, a comma-separated list. Each entry may be
+// prefixed with "slack:", "discord:" or "json:"; otherwise the kind is
+// guessed from the host.
+func webhooks() []webhook {
+	var hooks []webhook
+	for _, entry := range strings.Split(setting("WEBHOOK_URLS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind := ""
+		for _, k := range []string{"slack", "discord", "json"} {
+			if strings.HasPrefix(entry, k+":") {
+				kind, entry = k, strings.TrimPrefix(entry, k+":")
+				break
+			}
+		}
+		if kind == "" {
+			switch {
+			case strings.Contains(entry, "hooks.slack.com"):
+				kind = "slack"
+			case strings.Contains(entry, "discord.com/api/webhooks"), strings.Contains(entry, "discordapp.com/api/webhooks"):
+				kind = "discord"
+			default:
+				kind = "json"
+			}
+		}
+		hooks = append(hooks, webhook{Kind: kind, URL: entry})
+	}
+	return hooks
+}
+
+// webhookWanted applies the optional WEBHOOK_EVENTS filter.
+func webhookWanted(event string) bool {
+	filter := setting("WEBHOOK_EVENTS")
+	if filter == "" || event == EventTest {
+		return true
+	}
+	for _, e := range strings.Split(filter, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookPayload(kind, event, message string, data map[string]interface{}) ([]byte, error) {
+	id := state.Snapshot().Identity
+	text := fmt.Sprintf("[CTRL %s] %s: %s", id.Name, event, message)
+	switch kind {
+	case "slack":
+		return json.Marshal(map[string]string{"text": text})
+	case "discord":
+		return json.Marshal(map[string]string{"content": text})
+	default:
+		return json.Marshal(map[string]interface{}{
+			"event":     event,
+			"message":   message,
+			"data":      data,
+			"targetUrl": state.Snapshot().TargetURL,
+			"instance":  id,
+			"time":      time.Now().UnixMilli(),
+		})
+	}
+}
+
+func deliverWebhook(hook webhook, body []byte) error {
+	var err error
+	backoff := webhookBaseBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		var resp *http.Response
+		resp, err = webhookClient.Post(hook.URL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+			if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return err
+			}
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// initWebhooks fires events from the bus at every configured webhook.
+// Identical event/message pairs are suppressed for a minute so a flapping
+// target doesn't flood the channel.
+func initWebhooks() {
+	ch := subscribeEvents(webhookEvents...)
+	go func() {
+		for e := range ch {
+			deliverEvent(e)
+		}
+	}()
+}
+
+// watchdogWebhook is the older WATCHDOG_WEBHOOK_URL, still honoured as an
+// extra JSON webhook for watchdog trips only.
+func watchdogWebhook() []webhook {
+	if u := strings.TrimSpace(setting("WATCHDOG_WEBHOOK_URL")); u != "" {
+		return []webhook{{Kind: "json", URL: u}}
+	}
+	return nil
+}
+
+func deliverEvent(e Event) {
+	var hooks []webhook
+	if webhookWanted(e.Event) {
+		hooks = webhooks()
+	}
+	if e.Event == EventWatchdogTrip {
+		hooks = append(hooks, watchdogWebhook()...)
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	key := e.Event + "\x00" + e.Message
+	recentMutex.Lock()
+	if last, ok := recentEvents[key]; ok && time.Since(last) < webhookDedupWindow {
+		recentMutex.Unlock()
+		return
+	}
+	for k, last := range recentEvents {
+		if time.Since(last) >= webhookDedupWindow {
+			delete(recentEvents, k)
+		}
+	}
+	recentEvents[key] = time.Now()
+	recentMutex.Unlock()
+
+	for _, hook := range hooks {
+		body, err := webhookPayload(hook.Kind, e.Event, e.Message, e.Data)
+		if err != nil {
+			continue
+		}
+		go func(hook webhook) {
+			if err := deliverWebhook(hook, body); err != nil {
+				slog.Error("webhook delivery failed", "event", e.Event, "kind", hook.Kind, "err", err)
+			}
+		}(hook)
+	}
+}
+
+func apiWebhooksTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hooks := webhooks()
+	results := make([]map[string]interface{}, len(hooks))
+	var wg sync.WaitGroup
+	for i, hook := range hooks {
+		wg.Add(1)
+		go func(i int, hook webhook) {
+			defer wg.Done()
+			result := map[string]interface{}{"kind": hook.Kind, "ok": true}
+			body, err := webhookPayload(hook.Kind, EventTest, "webhook test", nil)
+			if err == nil {
+				err = deliverWebhook(hook, body)
+			}
+			if err != nil {
+				result["ok"] = false
+				result["error"] = err.Error()
+			}
+			results[i] = result
+		}(i, hook)
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Zones split one screen into a grid of frames. ZONE_URLS lists the content
+// of each zone: "/" (or any path) shows the proxied target, local:// shows
+// local content and absolute URLs are loaded directly. ZONE_COLUMNS sets the
+// grid width (default: all zones side by side).
+//
+// Only the target is proxied, so external zones get no scaling or cookie
+// handling and must allow being framed.
+type Zone struct {
+	Index  int    `json:"index"`
+	URL    string `json:"url"`
+	Src    string `json:"src"`
+	Row    int    `json:"row"`
+	Column int    `json:"column"`
+}
+
+func zoneLayout() (zones []Zone, columns int) {
+	for _, entry := range strings.Split(setting("ZONE_URLS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		src := entry
+		switch {
+		case strings.HasPrefix(entry, "/"):
+		case strings.HasPrefix(entry, localScheme):
+			src = localHref(entry)
+		default:
+			u, err := normalizeTargetURL(entry)
+			if err != nil {
+				continue
+			}
+			src = u.String()
+		}
+		zones = append(zones, Zone{Index: len(zones), URL: entry, Src: src})
+	}
+
+	columns, _ = strconv.Atoi(setting("ZONE_COLUMNS"))
+	if columns <= 0 || columns > len(zones) {
+		columns = len(zones)
+	}
+	for i := range zones {
+		zones[i].Row = i / max(columns, 1)
+		zones[i].Column = i % max(columns, 1)
+	}
+	return zones, columns
+}
+
+var zonesTemplate = template.Must(template.New("zones").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CTRL Zones</title>
+<style>
+html,body{margin:0;height:100%;background:#000;overflow:hidden}
+.grid{display:grid;width:100vw;height:100vh;grid-template-columns:repeat({{.Columns}},1fr);grid-auto-rows:1fr}
+iframe{width:100%;height:100%;border:0;display:block}
+</style>
+</head>
+<body>
+<div class="grid">
+{{range .Zones}}<iframe src="{{.Src}}" data-zone="{{.Index}}" allow="autoplay; fullscreen"></iframe>
+{{end}}</div>
+</body>
+</html>
+`))
+
+func apiZonesHandler(w http.ResponseWriter, r *http.Request) {
+	zones, columns := zoneLayout()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": len(zones) > 0,
+		"columns": columns,
+		"zones":   zones,
+	})
+}
+
+func apiZonesViewHandler(w http.ResponseWriter, r *http.Request) {
+	zones, columns := zoneLayout()
+	if len(zones) == 0 {
+		http.Error(w, "No zones configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	zonesTemplate.Execute(w, map[string]interface{}{
+		"Zones":   zones,
+		"Columns": columns,
+	})
+}